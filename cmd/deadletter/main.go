@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jawr/mxax/internal/deadletter"
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s -queue <queue> [-n <count>] list|replay|purge\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func run() error {
+	var queue string
+	var n int
+	var body bool
+
+	flag.StringVar(&queue, "queue", "", "Queue whose dead letters to manage, i.e. logs or emails.straw")
+	flag.IntVar(&n, "n", 0, "Maximum number of messages to list or replay, 0 is all")
+	flag.BoolVar(&body, "body", false, "Print message bodies when listing")
+	flag.Usage = usage
+	flag.Parse()
+
+	if len(queue) == 0 || flag.NArg() != 1 {
+		usage()
+		return nil
+	}
+
+	// setup mq connection
+	ch, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
+	if err != nil {
+		return errors.WithMessage(err, "DialAMQP")
+	}
+	defer ch.Close()
+
+	if err := deadletter.Declare(ch, queue); err != nil {
+		return errors.WithMessage(err, "deadletter.Declare")
+	}
+
+	switch flag.Arg(0) {
+	case "list":
+		return list(ch, queue, n, body)
+	case "replay":
+		return replay(ch, queue, n)
+	case "purge":
+		return purge(ch, queue)
+	default:
+		usage()
+		return errors.Errorf("unknown command '%s'", flag.Arg(0))
+	}
+}
+
+// list dead letters without removing them, they are all held
+// unacked until we are done so each is only seen once
+func list(ch mq.Queue, queue string, n int, body bool) error {
+	var msgs []mq.Message
+
+	defer func() {
+		for _, msg := range msgs {
+			msg.Nack(true)
+		}
+	}()
+
+	for n == 0 || len(msgs) < n {
+		msg, ok, err := ch.Get(deadletter.Queue(queue))
+		if err != nil {
+			return errors.WithMessage(err, "Get")
+		}
+		if !ok {
+			break
+		}
+
+		msgs = append(msgs, msg)
+
+		fmt.Printf(
+			"%d\t%v\t%v attempts\t%v\n",
+			len(msgs),
+			msg.Headers[deadletter.HeaderFailedAt],
+			msg.Headers[deadletter.HeaderAttempts],
+			msg.Headers[deadletter.HeaderError],
+		)
+
+		if body {
+			fmt.Printf("%s\n\n", msg.Body)
+		}
+	}
+
+	fmt.Printf("%d dead letters for %s\n", len(msgs), queue)
+
+	return nil
+}
+
+// replay dead letters back on to the queue they failed on
+func replay(ch mq.Queue, queue string, n int) error {
+	var count int
+
+	for n == 0 || count < n {
+		msg, ok, err := ch.Get(deadletter.Queue(queue))
+		if err != nil {
+			return errors.WithMessage(err, "Get")
+		}
+		if !ok {
+			break
+		}
+
+		if err := deadletter.Replay(ch, &msg); err != nil {
+			msg.Nack(true)
+			return errors.WithMessage(err, "Replay")
+		}
+
+		if err := msg.Ack(); err != nil {
+			return errors.WithMessage(err, "Ack")
+		}
+
+		count++
+	}
+
+	fmt.Printf("Replayed %d dead letters to %s\n", count, queue)
+
+	return nil
+}
+
+func purge(ch mq.Queue, queue string) error {
+	count, err := ch.Purge(deadletter.Queue(queue))
+	if err != nil {
+		return errors.WithMessage(err, "Purge")
+	}
+
+	fmt.Printf("Purged %d dead letters for %s\n", count, queue)
+
+	return nil
+}
@@ -3,18 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jawr/mxax/internal/account"
 	cachePkg "github.com/jawr/mxax/internal/cache"
+	"github.com/jawr/mxax/internal/deadletter"
 	"github.com/jawr/mxax/internal/logger"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
 )
 
 func main() {
@@ -25,6 +27,11 @@ func main() {
 }
 
 func run() error {
+	var suppressAfter int
+
+	flag.IntVar(&suppressAfter, "suppress-after", 3, "Number of permanent failures before a destination is suppressed")
+	flag.Parse()
+
 	// setup a cancel context and work out what we want to do
 	// in the event of a rabbitmq failure or such
 	ctx, cancel := context.WithCancel(context.Background())
@@ -39,23 +46,27 @@ func run() error {
 
 	log.Println("Connected to the Database")
 
-	// setup rabbitmq connection
-	rabbitConn, err := rabbitmq.Dial(os.Getenv("MXAX_MQ_URL"))
+	// setup mq connection
+	publisher, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
 	if err != nil {
-		return errors.WithMessage(err, "rabbitmq.Dial")
+		return errors.WithMessage(err, "DialAMQP")
 	}
-	defer rabbitConn.Close()
+	defer publisher.Close()
 
 	hostname, err := os.Hostname()
 	if err != nil {
 		return errors.WithMessage(err, "Hostname")
 	}
 
-	logsSubscriberCh, logsSubscriber, err := createSubscriber(rabbitConn, "logs", hostname+"logs")
+	logsSubscriber, err := publisher.Consume("logs", hostname+"logs", 1)
 	if err != nil {
-		return errors.WithMessage(err, "createSubscriber logs")
+		return errors.WithMessage(err, "Consume logs")
+	}
+
+	// failed messages are retried and then dead lettered
+	if err := deadletter.Declare(publisher, "logs"); err != nil {
+		return errors.WithMessage(err, "deadletter.Declare")
 	}
-	defer logsSubscriberCh.Close()
 
 	log.Println("Connected to the MQ")
 
@@ -75,21 +86,27 @@ func run() error {
 		case <-quit:
 			return nil
 		case msg := <-logsSubscriber:
-			if err := handleMessage(ctx, db, cache, &msg); err != nil {
+			// only ack once the entry is safely stored
+			if err := handleMessage(ctx, db, cache, &msg, suppressAfter); err != nil {
 				log.Printf("Error handling message: %s", err)
+
+				if err := deadletter.Fail(publisher, "logs", &msg, err); err != nil {
+					log.Printf("Error dead lettering message: %s", err)
+				}
+				continue
+			}
+
+			if err := msg.Ack(); err != nil {
+				log.Printf("Error acking message: %s", err)
 			}
 		}
 	}
-
-	return nil
 }
 
-func handleMessage(ctx context.Context, db *pgxpool.Pool, cache *cachePkg.Cache, msg *amqp.Delivery) error {
-	defer msg.Ack(false)
-
+func handleMessage(ctx context.Context, db *pgxpool.Pool, cache *cachePkg.Cache, msg *mq.Message, suppressAfter int) error {
 	var e logger.Entry
 	if err := json.Unmarshal(msg.Body, &e); err != nil {
-		return err
+		return deadletter.Poison(errors.WithMessage(err, "Unmarshal"))
 	}
 
 	var logLevel account.LogLevel
@@ -113,27 +130,57 @@ func handleMessage(ctx context.Context, db *pgxpool.Pool, cache *cachePkg.Cache,
 
 	log.Printf("CURRENT LEVEL: %d, LOGGER RECV %+v", logLevel, e)
 
-	// depending on log level decide on logging
+	// suppression is tracked regardless of what the account
+	// wants logged, in the same transaction as the log so that
+	// a retried entry is not counted twice
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "Begin")
+	}
+	defer tx.Rollback(ctx)
 
-	if logLevel != account.LogLevelAll {
-		if logLevel == account.LogLevelNone {
-			return nil
-		}
+	if err := trackSuppression(ctx, tx, &e, suppressAfter); err != nil {
+		return errors.WithMessage(err, "trackSuppression")
+	}
 
-		if logLevel == account.LogLevelBounce && e.Etype != logger.EntryTypeBounce {
-			return nil
+	if shouldLog(logLevel, e.Etype) {
+		if err := insertLog(ctx, tx, &e); err != nil {
+			return errors.WithMessage(err, "insertLog")
 		}
+	}
 
-		if logLevel == account.LogLevelReject && e.Etype != logger.EntryTypeReject {
-			return nil
-		}
+	return tx.Commit(ctx)
+}
 
-		if logLevel == account.LogLevelBounceAndReject && (e.Etype == logger.EntryTypeSend) {
-			return nil
-		}
+// shouldLog decides if an entry of etype is logged at logLevel
+func shouldLog(logLevel account.LogLevel, etype logger.EntryType) bool {
+	if logLevel == account.LogLevelNone {
+		return false
+	}
+
+	// complaints are always logged unless logging is off
+	if logLevel == account.LogLevelAll || etype == logger.EntryTypeComplaint {
+		return true
+	}
+
+	if logLevel == account.LogLevelBounce && etype != logger.EntryTypeBounce {
+		return false
 	}
 
-	_, err := db.Exec(
+	// drops are logged alongside rejects
+	if logLevel == account.LogLevelReject && etype != logger.EntryTypeReject && etype != logger.EntryTypeDrop {
+		return false
+	}
+
+	if logLevel == account.LogLevelBounceAndReject && etype == logger.EntryTypeSend {
+		return false
+	}
+
+	return true
+}
+
+func insertLog(ctx context.Context, tx pgx.Tx, e *logger.Entry) error {
+	_, err := tx.Exec(
 		ctx,
 		`
 			INSERT INTO logs 
@@ -168,34 +215,61 @@ func handleMessage(ctx context.Context, db *pgxpool.Pool, cache *cachePkg.Cache,
 		e.QueueLevel,
 	)
 	if err != nil {
-		return err
+		return errors.WithMessage(err, "INSERT logs")
 	}
 
 	return nil
 }
 
-func createSubscriber(conn *rabbitmq.Connection, queueName, name string) (*rabbitmq.Channel, <-chan amqp.Delivery, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, nil, errors.WithMessage(err, "subscriber.Channel")
+// trackSuppression counts permanent failures against a destination
+// and clears them once it has been sent to successfully
+func trackSuppression(ctx context.Context, tx pgx.Tx, e *logger.Entry, suppressAfter int) error {
+	if e.AccountID == 0 || len(e.ToEmail) == 0 {
+		return nil
 	}
 
-	if err := ch.Qos(1, 0, false); err != nil {
-		return nil, nil, errors.WithMessage(err, "Qos")
+	switch {
+	case e.Etype == logger.EntryTypeSend:
+	case e.Etype == logger.EntryTypeBounce && e.Permanent:
+	case e.Etype == logger.EntryTypeComplaint:
+	default:
+		return nil
 	}
 
-	msgs, err := ch.Consume(
-		queueName,
-		name,
-		false, // autoack
-		false, // exclusive
-		false, // nolocal
-		false, // nowait
-		nil,
-	)
-	if err != nil {
-		return nil, nil, errors.WithMessage(err, "ch.Consume")
+	switch e.Etype {
+	case logger.EntryTypeSend:
+		// most sends have nothing to reset
+		failures, err := account.HasFailures(ctx, tx, e.AccountID, e.ToEmail)
+		if err != nil {
+			return errors.WithMessage(err, "HasFailures")
+		}
+
+		if !failures {
+			return nil
+		}
+
+		if err := account.ResetFailures(ctx, tx, e.AccountID, e.ToEmail); err != nil {
+			return errors.WithMessage(err, "ResetFailures")
+		}
+
+	case logger.EntryTypeComplaint:
+		// the recipient has told us they do not want these
+		if err := account.Suppress(ctx, tx, e.AccountID, e.ToEmail, e.Status); err != nil {
+			return errors.WithMessage(err, "Suppress")
+		}
+
+		log.Printf("SUPPRESSED - account %d - '%s': %s", e.AccountID, e.ToEmail, e.Status)
+
+	default:
+		suppressed, err := account.RecordPermanentFailure(ctx, tx, e.AccountID, e.ToEmail, e.Status, suppressAfter)
+		if err != nil {
+			return errors.WithMessage(err, "RecordPermanentFailure")
+		}
+
+		if suppressed {
+			log.Printf("SUPPRESSED - account %d - '%s': %s", e.AccountID, e.ToEmail, e.Status)
+		}
 	}
 
-	return ch, msgs, nil
+	return nil
 }
@@ -10,11 +10,12 @@ import (
 	"strings"
 	"time"
 
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/deadletter"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/sender"
 	"github.com/jawr/mxax/internal/smtp"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -37,12 +38,32 @@ func (s *StringSliceFlags) Set(v string) error {
 }
 
 func run() error {
-	var ips, rdnss StringSliceFlags
-	var queue string
+	var ips, rdnss, limits, ipPools, warmups, familyPreferences, laneWeights StringSliceFlags
+	var queue, defaultLimit, warmupSchedule, defaultFamilies, sinkTo string
+	var prefetch, sessionMaxMessages int
+	var sessionIdle time.Duration
+	var health sender.Health
 
-	flag.Var(&ips, "ips", "List of IP addresses to listen to. Order must match -rdns.")
+	flag.Var(&ips, "ips", "List of IPv4 or IPv6 addresses to send from, optionally as ip:bind or [ip]:bind. Order must match -rdns.")
 	flag.Var(&rdnss, "rdns", "List of corresponding rdns. Order must match -ips.")
-	flag.StringVar(&queue, "queue", "", "Name of the queue to subscribe to")
+	flag.StringVar(&queue, "queue", "", "Name of the queue to subscribe to, its system and submission lanes are subscribed to as well")
+	flag.Var(&laneWeights, "lane-weight", "Weight a lane is consumed at as priority=weight i.e. system=8. Defaults to system=8, submission=4, relay=1.")
+	flag.Var(&limits, "limit", "Per provider limit as provider=concurrency,interval i.e. google.com=4,500ms. Provider matches the destination domain or a suffix of its primary MX.")
+	flag.StringVar(&defaultLimit, "default-limit", "2,1s", "Limit for providers without their own -limit as concurrency,interval")
+	flag.IntVar(&prefetch, "prefetch", 10, "Maximum number of emails being delivered at once")
+	flag.DurationVar(&sessionIdle, "session-idle", time.Second*30, "How long an idle session to a destination MX is kept open, 0 disables reuse")
+	flag.IntVar(&sessionMaxMessages, "session-max-messages", 50, "Maximum number of emails sent over a single session")
+	flag.Var(&ipPools, "pool", "Named ip pool as name=ip,ip i.e. transactional=1.2.3.4,1.2.3.5. IPs not in a pool are in the default pool.")
+	flag.Var(&warmups, "warmup", "IP that is warming up as ip=date i.e. 1.2.3.4=2020-11-01, its daily volume is capped by -warmup-schedule")
+	flag.StringVar(&warmupSchedule, "warmup-schedule", "50,200,1000,5000,20000,50000", "Daily caps for warming up ips, one per week since the warmup started")
+	flag.StringVar(&defaultFamilies, "family", "4,6", "Order in which address families are tried when sending, the rest are fallbacks")
+	flag.Var(&familyPreferences, "prefer-family", "Per provider address family order as provider=families i.e. google.com=6,4")
+	flag.Float64Var(&health.MaxBounceRate, "max-bounce-rate", 0.1, "Bounce rate that pulls an ip out of rotation, 0 disables")
+	flag.Float64Var(&health.MaxDeferralRate, "max-deferral-rate", 0.3, "Deferral rate that pulls an ip out of rotation, 0 disables")
+	flag.IntVar(&health.MinAttempts, "health-min-attempts", 50, "Minimum attempts from an ip before its rates are checked")
+	flag.DurationVar(&health.Window, "health-window", time.Hour, "Window over which ip bounce and deferral rates are measured")
+	flag.DurationVar(&health.Cooldown, "health-cooldown", time.Hour*6, "How long an ip is kept out of rotation")
+	flag.StringVar(&sinkTo, "sink", "", "Deliver locally instead of to destination MXs, as maildir:dir, mbox:dir or an http(s) url to POST to. -ips and -rdns are not needed.")
 	flag.Parse()
 
 	if flag.NFlag() == 0 {
@@ -50,7 +71,18 @@ func run() error {
 		return nil
 	}
 
-	if len(ips) == 0 {
+	// a sink replaces MX delivery so no ips are needed
+	var sink sender.Sink
+	if len(sinkTo) > 0 {
+		var err error
+		sink, err = sender.ParseSink(sinkTo)
+		if err != nil {
+			return errors.WithMessage(err, "ParseSink")
+		}
+
+		ips, rdnss = nil, nil
+
+	} else if len(ips) == 0 {
 		return errors.New("must specify an ip to listen on")
 	}
 
@@ -66,73 +98,186 @@ func run() error {
 		return errors.New("that queue does not exist")
 	}
 
+	if prefetch < 1 {
+		return errors.New("prefetch must be at least 1")
+	}
+
+	weights := make(map[smtp.Priority]int, len(smtp.Priorities))
+	for priority, weight := range sender.DefaultLaneWeights {
+		weights[priority] = weight
+	}
+
+	for _, w := range laneWeights {
+		priority, weight, err := sender.ParseLaneWeight(w)
+		if err != nil {
+			return errors.WithMessage(err, "ParseLaneWeight")
+		}
+		weights[priority] = weight
+	}
+
+	// setup throttling
+	dLimit, err := sender.ParseDefaultLimit(defaultLimit)
+	if err != nil {
+		return errors.WithMessage(err, "ParseDefaultLimit")
+	}
+
+	providerLimits := make(map[string]sender.Limit, len(limits))
+	for _, l := range limits {
+		provider, limit, err := sender.ParseLimit(l)
+		if err != nil {
+			return errors.WithMessage(err, "ParseLimit")
+		}
+		providerLimits[provider] = limit
+	}
+
+	throttle := sender.NewThrottle(dLimit, providerLimits)
+
+	if sessionMaxMessages < 1 {
+		return errors.New("session-max-messages must be at least 1")
+	}
+
+	pool := sender.NewPool(sessionIdle, sessionMaxMessages)
+
+	// setup ip pools
+	warmup, err := sender.ParseWarmup(warmupSchedule)
+	if err != nil {
+		return errors.WithMessage(err, "ParseWarmup")
+	}
+
+	ipPool := make(map[string]string)
+	for _, p := range ipPools {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("bad pool: '%s'", p)
+		}
+		for _, ip := range strings.Split(parts[1], ",") {
+			ipPool[canonicalIP(ip)] = parts[0]
+		}
+	}
+
+	ipWarmup := make(map[string]time.Time)
+	for _, w := range warmups {
+		parts := strings.SplitN(w, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("bad warmup: '%s'", w)
+		}
+		start, err := time.Parse("2006-01-02", parts[1])
+		if err != nil {
+			return errors.WithMessagef(err, "warmup date for '%s'", parts[0])
+		}
+		ipWarmup[canonicalIP(parts[0])] = start
+	}
+
+	outbound := sender.NewIPPools(warmup, health)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// setup rabbitmq connection
-	rabbitConnIn, err := rabbitmq.Dial(os.Getenv("MXAX_MQ_URL"))
+	// warmup sends are counted in the db so that every sender
+	// shares the same daily caps
+	if len(ipWarmup) > 0 && len(warmup) > 0 {
+		db, err := pgxpool.Connect(ctx, os.Getenv("MXAX_ADMIN_DB_URL"))
+		if err != nil {
+			return errors.WithMessage(err, "pgxpool.Connect")
+		}
+		defer db.Close()
+
+		outbound.Counter = sender.NewDBWarmupCounter(db)
+	}
+
+	// setup address family preferences
+	dFamilies, err := sender.ParseFamilies(defaultFamilies)
 	if err != nil {
-		return errors.WithMessage(err, "rabbitmq.Dial")
+		return errors.WithMessage(err, "ParseFamilies")
 	}
-	defer rabbitConnIn.Close()
 
-	rabbitConnOut, err := rabbitmq.Dial(os.Getenv("MXAX_MQ_URL"))
+	providerFamilies := make(map[string]sender.Families, len(familyPreferences))
+	for _, f := range familyPreferences {
+		provider, families, err := sender.ParseFamilyPreference(f)
+		if err != nil {
+			return errors.WithMessage(err, "ParseFamilyPreference")
+		}
+		providerFamilies[provider] = families
+	}
+
+	families := sender.NewFamilyPreferences(dFamilies, providerFamilies)
+
+	// setup mq connections, publishing is kept separate so that
+	// flow control can not hold up consuming
+	subscriber, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
 	if err != nil {
-		return errors.WithMessage(err, "rabbitmq.Dial")
+		return errors.WithMessage(err, "DialAMQP")
 	}
-	defer rabbitConnOut.Close()
+	defer subscriber.Close()
 
-	// setup logs publisher
-	publisher, err := createPublisher(rabbitConnOut, "")
+	publisher, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
 	if err != nil {
-		return errors.WithMessage(err, "createPublisher")
+		return errors.WithMessage(err, "DialAMQP")
 	}
 	defer publisher.Close()
 
+	level := smtp.Queues[queue]
+
+	if err := publisher.Declare(level.Lanes()...); err != nil {
+		return errors.WithMessage(err, "Declare lanes")
+	}
+
+	if err := deadletter.Declare(publisher, level.Lanes()...); err != nil {
+		return errors.WithMessage(err, "deadletter.Declare")
+	}
+
 	// setup email subscriber
 	hostname, err := os.Hostname()
 	if err != nil {
 		return errors.WithMessage(err, "Hostname")
 	}
 
-	emailSubscriber, emailSubscriberCh, err := createSubscriber(rabbitConnIn, queue, hostname+".sender")
-	if err != nil {
-		return errors.WithMessage(err, "createSubscriber emails")
+	// each lane is prefetched on its own, how many are
+	// delivered at once is capped by the sender
+	lanes := make([]sender.Lane, 0, len(smtp.Priorities))
+	for _, priority := range smtp.Priorities {
+		ch, err := subscriber.Consume(level.Lane(priority), hostname+".sender", prefetch)
+		if err != nil {
+			return errors.WithMessagef(err, "Consume %s", level.Lane(priority))
+		}
+
+		lanes = append(lanes, sender.Lane{
+			Priority: priority,
+			Weight:   weights[priority],
+			Messages: ch,
+		})
 	}
-	defer emailSubscriber.Close()
 
-	bounceSubscriber, bounceSubscriberCh, err := createSubscriber(rabbitConnIn, "bounces", hostname+".sender")
+	bounceSubscriberCh, err := subscriber.Consume("bounces", hostname+".sender", 1)
 	if err != nil {
-		return errors.WithMessage(err, "createSubscriber bounces")
+		return errors.WithMessage(err, "Consume bounces")
 	}
-	defer bounceSubscriber.Close()
 
 	log.Println("Connected to MQ...")
 
 	// create our sender
-	sndr, err := sender.NewSender(publisher, emailSubscriberCh, bounceSubscriberCh)
+	sndr, err := sender.NewSender(publisher, lanes, bounceSubscriberCh, throttle, pool, outbound, families)
 	if err != nil {
 		return errors.WithMessage(err, "NewSender")
 	}
 
-	eg := &errgroup.Group{}
+	sndr.Concurrency = prefetch
 
-	for idx := range ips {
-		ip := ips[idx]
-		parts := strings.Split(ip, ":")
-		ip = parts[0]
+	if sink != nil {
+		sndr.Sink = sink
+		log.Printf("Delivering to sink %s", sinkTo)
+	}
 
-		var bind string
-		if len(parts) > 1 {
-			bind = parts[1]
-		} else {
-			bind = ip
+	for idx := range ips {
+		ip, bind, err := parseIP(ips[idx])
+		if err != nil {
+			return errors.WithMessage(err, "parseIP")
 		}
 
 		rdns := rdnss[idx]
 
 		// verify we can listen on this ip
-		laddr, err := net.ResolveTCPAddr("tcp", bind+":0")
+		laddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(bind, "0"))
 		if err != nil {
 			return errors.WithMessagef(err, "ResolveTCPAddr for '%s'", ip)
 		}
@@ -143,23 +288,52 @@ func run() error {
 			LocalAddr: laddr,
 		}
 
-		conn, err := dialer.DialContext(ctx, "tcp", "8.8.8.8:53")
+		family := sender.FamilyOf(net.ParseIP(ip))
+
+		// test dialer over the ip's own family
+		network, test := "tcp4", "8.8.8.8:53"
+		if family == sender.FamilyIPv6 {
+			network, test = "tcp6", "[2001:4860:4860::8888]:53"
+		}
+
+		conn, err := dialer.DialContext(ctx, network, test)
 		if err != nil {
 			return errors.WithMessagef(err, "Dial using %s", ip)
 		}
 		conn.Close()
 
-		// test dialer and verify that rdns matches
+		// verify that rdns matches
 		if err := verifyRdns(ip, rdns); err != nil {
 			return errors.WithMessagef(err, "verifyRdns for '%s' / '%s'", ip, rdns)
 		}
 
-		// run using errgroup
-		eg.Go(func() error {
-			return sndr.Run(ctx, dialer, rdns)
+		name, ok := ipPool[ip]
+		if !ok {
+			name = sender.DefaultIPPool
+		}
+
+		outbound.Add(name, &sender.IP{
+			Addr:        ip,
+			Rdns:        rdns,
+			Dialer:      dialer,
+			Family:      family,
+			WarmupStart: ipWarmup[ip],
 		})
+
+		log.Printf("Sending from %s (%s) in pool %s", ip, rdns, name)
 	}
 
+	eg := &errgroup.Group{}
+
+	// close idle sessions
+	eg.Go(func() error {
+		return pool.Run(ctx)
+	})
+
+	eg.Go(func() error {
+		return sndr.Run(ctx)
+	})
+
 	// signal all runners to run
 	sndr.Start()
 
@@ -171,65 +345,77 @@ func run() error {
 	return nil
 }
 
-func createSubscriber(conn *rabbitmq.Connection, queueName, name string) (*rabbitmq.Channel, <-chan amqp.Delivery, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, nil, errors.WithMessage(err, "subscriber.Channel")
-	}
+// parseIP parses an -ips value in the form of ip, ip:bind or
+// [ip]:bind, the brackets are needed when ip is IPv6
+func parseIP(v string) (string, string, error) {
+	ip, bind := v, v
 
-	if err := ch.Qos(1, 0, false); err != nil {
-		return nil, nil, errors.WithMessage(err, "Qos")
+	if net.ParseIP(v) == nil {
+		if strings.HasPrefix(v, "[") {
+			idx := strings.Index(v, "]:")
+			if idx < 0 {
+				return "", "", errors.Errorf("bad ip: '%s'", v)
+			}
+			ip, bind = v[1:idx], v[idx+2:]
+
+		} else {
+			idx := strings.LastIndex(v, ":")
+			if idx < 0 {
+				return "", "", errors.Errorf("bad ip: '%s'", v)
+			}
+			ip, bind = v[:idx], v[idx+1:]
+		}
 	}
 
-	msgs, err := ch.Consume(
-		queueName,
-		name,
-		false, // autoack
-		false, // exclusive
-		false, // nolocal
-		false, // nowait
-		nil,
-	)
-	if err != nil {
-		return nil, nil, errors.WithMessage(err, "ch.Consume")
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", "", errors.Errorf("bad ip: '%s'", v)
 	}
 
-	return ch, msgs, nil
+	// use the canonical form so it matches lookups
+	return parsed.String(), bind, nil
 }
 
-func createPublisher(conn *rabbitmq.Connection, queueName string) (*rabbitmq.Channel, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, errors.WithMessage(err, "subscriber.Channel")
-	}
-
-	if len(queueName) > 0 {
-		_, err = ch.QueueDeclare(
-			queueName,
-			true,  // durable
-			false, // autoDelete
-			false, // exclusive
-			false, // noWait
-			nil,
-		)
-		if err != nil {
-			return nil, errors.WithMessage(err, "QueueDeclare")
-		}
+// canonicalIP so that differently written IPv6 addresses match
+func canonicalIP(v string) string {
+	if parsed := net.ParseIP(v); parsed != nil {
+		return parsed.String()
 	}
-
-	return ch, nil
+	return v
 }
 
+// verifyRdns checks forward confirmed reverse dns, rdns must
+// resolve to ip and ip must resolve back to rdns
 func verifyRdns(ip, rdns string) error {
+	want := net.ParseIP(ip)
+
 	ips, err := net.LookupIP(rdns)
 	if err != nil {
 		return errors.WithMessage(err, "LookupIP")
 	}
-	if len(ips) != 1 {
-		return errors.New("too many results found")
+
+	var found bool
+	for _, i := range ips {
+		if i.Equal(want) {
+			found = true
+			break
+		}
 	}
-	if ips[0].String() != ip {
-		return errors.Errorf("lookup '%s' found '%s' expected '%s'", rdns, ips[0].String(), ip)
+
+	if !found {
+		return errors.Errorf("lookup '%s' did not find '%s'", rdns, ip)
 	}
-	return nil
+
+	names, err := net.LookupAddr(ip)
+	if err != nil {
+		return errors.WithMessage(err, "LookupAddr")
+	}
+
+	for _, name := range names {
+		if strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(rdns, ".")) {
+			return nil
+		}
+	}
+
+	return errors.Errorf("reverse lookup of '%s' found %v expected '%s'", ip, names, rdns)
 }
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseIP(t *testing.T) {
+	tests := map[string]struct {
+		ip, bind string
+	}{
+		"192.0.2.1":             {"192.0.2.1", "192.0.2.1"},
+		"192.0.2.1:10.0.0.1":    {"192.0.2.1", "10.0.0.1"},
+		"2001:db8::1":           {"2001:db8::1", "2001:db8::1"},
+		"2001:0db8:0000::0001":  {"2001:db8::1", "2001:0db8:0000::0001"},
+		"[2001:db8::1]:fd00::1": {"2001:db8::1", "fd00::1"},
+	}
+
+	for v, want := range tests {
+		t.Run(v, func(t *testing.T) {
+			ip, bind, err := parseIP(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ip != want.ip || bind != want.bind {
+				t.Fatalf("expected %s bind %s, got %s bind %s", want.ip, want.bind, ip, bind)
+			}
+		})
+	}
+}
+
+func TestParseIPInvalid(t *testing.T) {
+	for _, v := range []string{"[2001:db8::1]", "mx.example.com", "mx.example.com:10.0.0.1"} {
+		if ip, bind, err := parseIP(v); err == nil {
+			t.Errorf("'%s': expected an error, got %s %s", v, ip, bind)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/smtp"
 	"github.com/pkg/errors"
 )
@@ -34,35 +36,45 @@ func run() error {
 
 	log.Println("Connected to the Database")
 
-	// setup rabbitmq connection
-	rabbitConn, err := rabbitmq.Dial(os.Getenv("MXAX_MQ_URL"))
+	// setup mq connection
+	queue, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
 	if err != nil {
-		return errors.WithMessage(err, "rabbitmq.Dial")
+		return errors.WithMessage(err, "DialAMQP")
 	}
-	defer rabbitConn.Close()
+	defer queue.Close()
 
-	// setup logs publisher
-	logPublisher, err := createPublisher(rabbitConn, "logs")
-	if err != nil {
-		return errors.WithMessage(err, "createPublisher logs")
+	if err := queue.Declare("logs"); err != nil {
+		return errors.WithMessage(err, "Declare logs")
 	}
-	defer logPublisher.Close()
 
-	// setup email publisher
-	emailPublisher, err := createPublisher(rabbitConn, "")
+	log.Println("Connected to the MQ")
+
+	// load our certificate for TLS
+	cert, err := tls.LoadX509KeyPair(
+		"/etc/letsencrypt/live/ehlo.mx.ax/fullchain.pem",
+		"/etc/letsencrypt/live/ehlo.mx.ax/privkey.pem",
+	)
 	if err != nil {
-		return errors.WithMessage(err, "createPublisher")
+		return errors.WithMessage(err, "tls.LoadX509KeyPair")
 	}
-	defer emailPublisher.Close()
 
-	log.Println("Connected to the MQ")
+	tlsConfig := &tls.Config{
+		ServerName:   "ehlo.mx.ax",
+		Certificates: []tls.Certificate{cert},
+	}
 
 	// server will eventually handle inbound and outbound
-	server, err := smtp.NewServer(db, logPublisher, emailPublisher)
+	server, err := smtp.NewServer(db, queue, queue, tlsConfig)
 	if err != nil {
 		return errors.WithMessage(err, "NewServer")
 	}
 
+	// feedback loop reports are only accepted at addresses we
+	// have registered with providers
+	if fbl := os.Getenv("MXAX_FBL_ADDRESSES"); len(fbl) > 0 {
+		server.SetFeedbackAddresses(strings.Split(fbl, ","))
+	}
+
 	log.Println("Starting SMTP Server")
 
 	if err := server.Run(os.Getenv("MXAX_DOMAIN")); err != nil {
@@ -71,26 +83,3 @@ func run() error {
 
 	return nil
 }
-
-func createPublisher(conn *rabbitmq.Connection, queueName string) (*rabbitmq.Channel, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, errors.WithMessage(err, "subscriber.Channel")
-	}
-
-	if len(queueName) > 0 {
-		_, err = ch.QueueDeclare(
-			queueName,
-			true,  // durable
-			false, // autoDelete
-			false, // exclusive
-			false, // noWait
-			nil,
-		)
-		if err != nil {
-			return nil, errors.WithMessage(err, "QueueDeclare")
-		}
-	}
-
-	return ch, nil
-}
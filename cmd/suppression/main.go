@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-reason <reason>] list|add <address>|remove <address>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func run() error {
+	var reason string
+
+	flag.StringVar(&reason, "reason", "Suppressed by an administrator", "Reason recorded when adding an address")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the global list has no account so is only reachable
+	// as the admin user
+	db, err := pgxpool.Connect(ctx, os.Getenv("MXAX_ADMIN_DB_URL"))
+	if err != nil {
+		return errors.WithMessage(err, "pgxpool.Connect")
+	}
+	defer db.Close()
+
+	switch flag.Arg(0) {
+	case "list":
+		return list(ctx, db)
+	case "add", "remove":
+		if flag.NArg() != 2 {
+			usage()
+			return errors.Errorf("%s expects an address", flag.Arg(0))
+		}
+
+		if flag.Arg(0) == "add" {
+			return add(ctx, db, flag.Arg(1), reason)
+		}
+
+		return remove(ctx, db, flag.Arg(1))
+	default:
+		usage()
+		return errors.Errorf("unknown command '%s'", flag.Arg(0))
+	}
+}
+
+func list(ctx context.Context, db *pgxpool.Pool) error {
+	var suppressions []account.Suppression
+	if err := account.GetGlobalSuppressions(ctx, db, &suppressions); err != nil {
+		return errors.WithMessage(err, "GetGlobalSuppressions")
+	}
+
+	for _, s := range suppressions {
+		fmt.Printf("%s\t%s\t%s\n", s.Address, s.SuppressedAt.Time.Format("2006-01-02 15:04"), s.Reason)
+	}
+
+	fmt.Printf("%d globally suppressed addresses\n", len(suppressions))
+
+	return nil
+}
+
+func add(ctx context.Context, db *pgxpool.Pool, address, reason string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "Begin")
+	}
+	defer tx.Rollback(ctx)
+
+	if err := account.Suppress(ctx, tx, 0, address, reason); err != nil {
+		return errors.WithMessage(err, "Suppress")
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "Commit")
+	}
+
+	fmt.Printf("Suppressed %s for every account\n", address)
+
+	return nil
+}
+
+func remove(ctx context.Context, db *pgxpool.Pool, address string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "Begin")
+	}
+	defer tx.Rollback(ctx)
+
+	ok, err := account.DeleteGlobalSuppression(ctx, tx, address)
+	if err != nil {
+		return errors.WithMessage(err, "DeleteGlobalSuppression")
+	}
+
+	if !ok {
+		return errors.Errorf("%s is not globally suppressed", address)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "Commit")
+	}
+
+	fmt.Printf("Removed %s from the global suppression list\n", address)
+
+	return nil
+}
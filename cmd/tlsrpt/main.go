@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/deadletter"
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/smtp"
+	"github.com/jawr/mxax/internal/tlsrpt"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type config struct {
+	organisation string
+	contact      string
+	from         string
+	domain       string
+}
+
+func run() error {
+	var cfg config
+
+	flag.StringVar(&cfg.organisation, "organisation", "mx.ax", "Organisation name used in reports")
+	flag.StringVar(&cfg.contact, "contact", "tlsrpt@mx.ax", "Contact info used in reports")
+	flag.StringVar(&cfg.from, "from", "tlsrpt@mx.ax", "Address reports are mailed from")
+	flag.StringVar(&cfg.domain, "domain", "mx.ax", "Domain whose dkim key signs mailed reports")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// setup  database connection
+	db, err := pgxpool.Connect(ctx, os.Getenv("MXAX_ADMIN_DB_URL"))
+	if err != nil {
+		return errors.WithMessage(err, "pgx.Connect")
+	}
+	defer db.Close()
+
+	log.Println("Connected to the Database")
+
+	dkimKey, err := getDkimKey(ctx, db, cfg.domain)
+	if err != nil {
+		return errors.WithMessage(err, "getDkimKey")
+	}
+
+	// setup mq connection
+	emailPublisher, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
+	if err != nil {
+		return errors.WithMessage(err, "DialAMQP")
+	}
+	defer emailPublisher.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return errors.WithMessage(err, "Hostname")
+	}
+
+	if err := emailPublisher.Declare(smtp.QueueLevel(smtp.QueueLevelStraw).Lane(smtp.PrioritySystem)); err != nil {
+		return errors.WithMessage(err, "Declare emails")
+	}
+
+	if err := emailPublisher.Declare("tlsrpt"); err != nil {
+		return errors.WithMessage(err, "Declare tlsrpt")
+	}
+
+	resultsSubscriber, err := emailPublisher.Consume("tlsrpt", hostname+".tlsrpt", 1)
+	if err != nil {
+		return errors.WithMessage(err, "Consume tlsrpt")
+	}
+
+	if err := deadletter.Declare(emailPublisher, "tlsrpt"); err != nil {
+		return errors.WithMessage(err, "deadletter.Declare")
+	}
+
+	log.Println("Connected to the MQ")
+
+	// listen for interrupt
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+
+	report := time.NewTimer(untilNextReport())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return errors.New("done")
+
+		case <-quit:
+			return nil
+
+		case msg := <-resultsSubscriber:
+			if err := handleResult(ctx, db, &msg); err != nil {
+				log.Printf("Error handling result: %s", err)
+
+				if err := deadletter.Fail(emailPublisher, "tlsrpt", &msg, err); err != nil {
+					log.Printf("Error dead lettering result: %s", err)
+				}
+				continue
+			}
+
+			if err := msg.Ack(); err != nil {
+				log.Printf("Error acking result: %s", err)
+			}
+
+		case <-report.C:
+			end := time.Now().UTC().Truncate(time.Hour * 24)
+			start := end.Add(-time.Hour * 24)
+
+			if err := sendReports(ctx, db, emailPublisher, dkimKey, cfg, start, end); err != nil {
+				log.Printf("Error sending reports: %s", err)
+			}
+
+			report.Reset(untilNextReport())
+		}
+	}
+}
+
+// reports are sent shortly after midnight UTC and cover the
+// previous day
+func untilNextReport() time.Duration {
+	next := time.Now().UTC().Truncate(time.Hour * 24).Add(time.Hour*24 + time.Minute*5)
+	return time.Until(next)
+}
+
+func handleResult(ctx context.Context, db *pgxpool.Pool, msg *mq.Message) error {
+	var result tlsrpt.Result
+	if err := json.Unmarshal(msg.Body, &result); err != nil {
+		return deadletter.Poison(errors.WithMessage(err, "Unmarshal"))
+	}
+
+	_, err := db.Exec(
+		ctx,
+		`
+		INSERT INTO tls_results
+			(
+				time,
+				policy_domain,
+				sending_ip,
+				receiving_mx,
+				receiving_ip,
+				result_type,
+				information
+			)
+			VALUES ($1,$2,$3,$4,$5,$6,$7)
+		`,
+		result.Time,
+		result.PolicyDomain,
+		result.SendingIP,
+		result.ReceivingMX,
+		result.ReceivingIP,
+		result.ResultType,
+		result.Information,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "Insert")
+	}
+
+	return nil
+}
+
+func sendReports(ctx context.Context, db *pgxpool.Pool, emailPublisher mq.Publisher, dkimKey *rsa.PrivateKey, cfg config, start, end time.Time) error {
+	reports, err := tlsrpt.GetReports(ctx, db, cfg.organisation, cfg.contact, start, end)
+	if err != nil {
+		return errors.WithMessage(err, "GetReports")
+	}
+
+	log.Printf("Sending %d reports for %s - %s", len(reports), start, end)
+
+	for _, report := range reports {
+		record, err := tlsrpt.LookupRecord(report.Domain())
+		if err != nil {
+			// most domains do not publish a record
+			continue
+		}
+
+		gzipped, err := report.Gzip()
+		if err != nil {
+			return errors.WithMessagef(err, "Gzip '%s'", report.Domain())
+		}
+
+		for _, rua := range record.Rua {
+			switch rua.Scheme {
+			case "https":
+				err = tlsrpt.Post(ctx, rua, gzipped)
+
+			case "mailto":
+				err = mailReport(emailPublisher, dkimKey, cfg, report, rua.Opaque, gzipped)
+			}
+
+			if err != nil {
+				log.Printf("Error delivering report for '%s' to '%s': %s", report.Domain(), rua, err)
+				continue
+			}
+
+			log.Printf("Delivered report for '%s' to '%s'", report.Domain(), rua)
+		}
+	}
+
+	return nil
+}
+
+func mailReport(emailPublisher mq.Publisher, dkimKey *rsa.PrivateKey, cfg config, report tlsrpt.Report, to string, gzipped []byte) error {
+	message, err := report.BuildMessage(cfg.from, to, gzipped)
+	if err != nil {
+		return errors.WithMessage(err, "BuildMessage")
+	}
+
+	var signed bytes.Buffer
+
+	opts := dkim.SignOptions{
+		Domain:   cfg.domain,
+		Selector: "mxax",
+		Signer:   dkimKey,
+		Hash:     crypto.SHA256,
+	}
+
+	if err := dkim.Sign(&signed, bytes.NewReader(message), &opts); err != nil {
+		return errors.WithMessage(err, "dkim.Sign")
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return errors.WithMessage(err, "NewRandom")
+	}
+
+	email := smtp.Email{
+		ID:       id,
+		From:     cfg.from,
+		To:       to,
+		Message:  signed.Bytes(),
+		Priority: smtp.PrioritySystem,
+	}
+
+	b, err := json.Marshal(email)
+	if err != nil {
+		return errors.WithMessage(err, "Marshal")
+	}
+
+	msg := mq.Message{
+		Timestamp:   time.Now(),
+		ContentType: "application/json",
+		Body:        b,
+	}
+
+	err = emailPublisher.Publish(email.QueueLevel.Lane(email.Priority), msg)
+	if err != nil {
+		return errors.WithMessage(err, "Publish")
+	}
+
+	return nil
+}
+
+func getDkimKey(ctx context.Context, db *pgxpool.Pool, domain string) (*rsa.PrivateKey, error) {
+	var privateKey []byte
+	err := db.QueryRow(
+		ctx,
+		`
+		SELECT k.private_key
+		FROM dkim_keys AS k
+			JOIN domains AS d on k.domain_id = d.id
+		WHERE
+			d.name = $1
+			AND k.deleted_at IS NULL
+		`,
+		domain,
+	).Scan(&privateKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Select")
+	}
+
+	d, _ := pem.Decode(privateKey)
+	if d == nil {
+		return nil, errors.New("pem.Decode")
+	}
+
+	return x509.ParsePKCS1PrivateKey(d.Bytes)
+}
@@ -6,8 +6,9 @@ import (
 	"log"
 	"os"
 
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/smtp"
 	"github.com/jawr/mxax/internal/website"
 	"github.com/pkg/errors"
 )
@@ -30,19 +31,16 @@ func run() error {
 
 	log.Println("Connected to the Database")
 
-	// setup rabbitmq connection
-	rabbitConn, err := rabbitmq.Dial(os.Getenv("MXAX_MQ_URL"))
+	// setup mq connection
+	emailPublisher, err := mq.DialAMQP(os.Getenv("MXAX_MQ_URL"))
 	if err != nil {
-		return errors.WithMessage(err, "rabbitmq.Dial")
+		return errors.WithMessage(err, "DialAMQP")
 	}
-	defer rabbitConn.Close()
+	defer emailPublisher.Close()
 
-	// setup logs publisher
-	emailPublisher, err := createPublisher(rabbitConn, "emails.straw")
-	if err != nil {
-		return errors.WithMessage(err, "createPublisher emails")
+	if err := emailPublisher.Declare(smtp.QueueLevel(smtp.QueueLevelStraw).Lane(smtp.PrioritySystem)); err != nil {
+		return errors.WithMessage(err, "Declare emails")
 	}
-	defer emailPublisher.Close()
 
 	log.Println("Connected to the MQ")
 
@@ -59,26 +57,3 @@ func run() error {
 
 	return nil
 }
-
-func createPublisher(conn *rabbitmq.Connection, queueName string) (*rabbitmq.Channel, error) {
-	ch, err := conn.Channel()
-	if err != nil {
-		return nil, errors.WithMessage(err, "subscriber.Channel")
-	}
-
-	if len(queueName) > 0 {
-		_, err = ch.QueueDeclare(
-			queueName,
-			true,  // durable
-			false, // autoDelete
-			false, // exclusive
-			false, // noWait
-			nil,
-		)
-		if err != nil {
-			return nil, errors.WithMessage(err, "QueueDeclare")
-		}
-	}
-
-	return ch, nil
-}
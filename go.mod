@@ -16,6 +16,8 @@ require (
 	github.com/gofrs/uuid v3.2.0+incompatible
 	github.com/google/uuid v1.1.1
 	github.com/isayme/go-amqp-reconnect v0.0.0-20180930040740-e71660afb5ca
+	github.com/jackc/pgconn v1.5.1-0.20200601181101-fa742c524853
+	github.com/jackc/pgproto3/v2 v2.0.1
 	github.com/jackc/pgtype v1.3.1-0.20200612023650-09efc3839047
 	github.com/jackc/pgx v3.6.2+incompatible
 	github.com/jackc/pgx/v4 v4.6.1-0.20200606145419-4e5062306904
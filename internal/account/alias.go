@@ -2,7 +2,9 @@ package account
 
 import (
 	"context"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,6 +22,7 @@ type ReturnPath struct {
 	AccountID int
 	AliasID   int
 	ReturnTo  string
+	MessageID string
 
 	CreatedAt  time.Time
 	ReturnedAt pgtype.Timestamp
@@ -35,7 +38,49 @@ type Alias struct {
 	AccountID int
 	DomainID  int
 
-	Rule string
+	Rule     string
+	RuleType RuleType
+
+	// position in the domain's alias list, lower is
+	// checked first
+	Priority int
+
+	// matches any local part and is only checked once
+	// every other alias has failed to match
+	CatchAll bool
+
+	// what to do with matching mail, RejectMessage is given
+	// in the 550 of AliasStateReject
+	State         AliasState
+	RejectMessage string
+
+	// when set mail is only forwarded between these times
+	ActiveFrom  pgtype.Timestamp
+	ActiveUntil pgtype.Timestamp
+
+	// burner aliases expire at ExpiresAt or once Uses reaches
+	// MaxUses, ExpiredAt records when that happened
+	ExpiresAt             pgtype.Timestamp
+	MaxUses               int
+	Uses                  int
+	ExpiredAt             pgtype.Timestamp
+	FallbackDestinationID pgtype.Int4
+
+	// created by the relay from the domain's AutoCreateRule
+	AutoCreated bool
+
+	// what happens to mail from senders refused by the
+	// Alias' SenderRules
+	SenderAction SenderAction
+
+	// remove identifying headers and trackers from
+	// forwarded mail
+	Scrub bool
+
+	// prefixed to the subject of forwarded mail, and if
+	// X-MXAX-Alias and X-MXAX-Domain headers are added
+	SubjectTag string
+	TagHeaders bool
 
 	// internal use
 	rule         *regexp.Regexp
@@ -45,13 +90,32 @@ type Alias struct {
 }
 
 // Take an email local part and check it against the
-// Alias' rule. regexp is compiled lazily
+// Alias' rule according to its RuleType. Matching is case
+// insensitive, regex and glob rules must match the whole
+// local part and are compiled lazily. A catch all matches
+// anything
 func (a *Alias) Check(user string) (bool, error) {
+	if a.CatchAll {
+		return true, nil
+	}
+
+	user = strings.ToLower(user)
+
+	switch a.RuleType {
+	case RuleTypeExact:
+		return user == strings.ToLower(a.Rule), nil
+
+	case RuleTypeSubaddress:
+		base := strings.ToLower(a.Rule)
+		return user == base || strings.HasPrefix(user, base+"+"), nil
+	}
+
 	if a.rule == nil {
-		rule := strings.ToLower(a.Rule)
-		rule = strings.TrimPrefix(rule, "^")
-		rule = strings.TrimSuffix(rule, "$")
-		rule = "^" + rule + "$"
+		rule := anchorRegex(a.Rule)
+		if a.RuleType == RuleTypeGlob {
+			rule = globRegex(strings.ToLower(a.Rule))
+		}
+
 		r, err := regexp.Compile(rule)
 		if err != nil {
 			return false, err
@@ -62,6 +126,115 @@ func (a *Alias) Check(user string) (bool, error) {
 	return a.rule.MatchString(user), nil
 }
 
+// Subaddress returns the +tag of user if the Alias is a
+// subaddress rule
+func (a *Alias) Subaddress(user string) string {
+	if a.RuleType != RuleTypeSubaddress {
+		return ""
+	}
+
+	parts := strings.SplitN(user, "+", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+
+	return parts[1]
+}
+
+// SortAliases puts aliases in the order they are checked in,
+// catch alls last and otherwise by Priority with the oldest
+// first when priorities are equal
+func SortAliases(aliases []Alias) {
+	sort.SliceStable(aliases, func(i, j int) bool {
+		if aliases[i].CatchAll != aliases[j].CatchAll {
+			return !aliases[i].CatchAll
+		}
+		if aliases[i].Priority != aliases[j].Priority {
+			return aliases[i].Priority < aliases[j].Priority
+		}
+		return aliases[i].ID < aliases[j].ID
+	})
+}
+
+// AliasMatch is the outcome of checking user against a
+// single Alias
+type AliasMatch struct {
+	Alias   Alias
+	Matched bool
+	// set if the rule does not compile
+	Err error
+}
+
+// MatchAlias returns the first alias whose rule matches user,
+// aliases must already be in SortAliases order. Aliases with
+// a rule that does not compile never match. aliases is not
+// modified so it can be shared
+func MatchAlias(aliases []Alias, user string) (Alias, bool) {
+	matches := checkAliases(aliases, user, false)
+	if len(matches) == 0 || !matches[len(matches)-1].Matched {
+		return Alias{}, false
+	}
+
+	return matches[len(matches)-1].Alias, true
+}
+
+// ExplainMatch checks user against every alias in the order
+// MatchAlias does, the first Matched result is the alias
+// MatchAlias would return
+func ExplainMatch(aliases []Alias, user string) []AliasMatch {
+	return checkAliases(aliases, user, true)
+}
+
+// checkAliases checks aliases in order, stopping at the first
+// match unless all is set
+func checkAliases(aliases []Alias, user string, all bool) []AliasMatch {
+	var matches []AliasMatch
+
+	for _, alias := range aliases {
+		ok, err := alias.Check(user)
+
+		matches = append(matches, AliasMatch{
+			Alias:   alias,
+			Matched: ok && err == nil,
+			Err:     err,
+		})
+
+		if ok && err == nil && !all {
+			break
+		}
+	}
+
+	return matches
+}
+
+// GetDomainAliases returns the aliases of a verified domain in
+// the order they are checked in
+func GetDomainAliases(ctx context.Context, db pgxscan.Querier, aliases *[]Alias, domain string) error {
+	err := pgxscan.Select(
+		ctx,
+		db,
+		aliases,
+		`
+		SELECT a.* 
+		FROM aliases AS a 
+			JOIN domains AS d ON a.domain_id = d.id 
+		WHERE d.name = $1 
+			AND a.deleted_at IS NULL 
+			AND d.deleted_at IS NULL 
+			AND d.verified_at IS NOT NULL
+		ORDER BY a.catch_all, a.priority, a.id
+		`,
+		domain,
+	)
+	if err != nil {
+		return err
+	}
+
+	SortAliases(*aliases)
+
+	return nil
+}
+
 func GetAlias(ctx context.Context, db pgx.Tx, alias *Alias, aliasID int) error {
 	return pgxscan.Get(
 		ctx,
@@ -78,7 +251,10 @@ func GetAlias(ctx context.Context, db pgx.Tx, alias *Alias, aliasID int) error {
 	)
 }
 
-func CreateAlias(ctx context.Context, db pgx.Tx, rule string, domainID, destinationID int) error {
+// CreateAlias adds rule to the end of the domain's alias list,
+// or as its catch all, and attaches destinationID to it. A
+// non zero burner makes it expire
+func CreateAlias(ctx context.Context, db pgx.Tx, ruleType RuleType, rule string, catchAll bool, burner Burner, domainID, destinationID int) error {
 	// get domain
 	var domain Domain
 	err := GetDomainByID(ctx, db, &domain, domainID)
@@ -93,20 +269,66 @@ func CreateAlias(ctx context.Context, db pgx.Tx, rule string, domainID, destinat
 		return errors.WithMessage(err, "GetDestinationByID")
 	}
 
+	// catch alls have no rule and are unique per domain
+	conflict := "(domain_id, rule, rule_type)"
+	existing := "rule = $2 AND rule_type = $4"
+	if catchAll {
+		rule = ""
+		conflict = "(domain_id) WHERE catch_all"
+		existing = "catch_all"
+	}
+
+	expiresAt := pgtype.Timestamptz{Status: pgtype.Null}
+	if !burner.ExpiresAt.IsZero() {
+		expiresAt = pgtype.Timestamptz{Time: burner.ExpiresAt, Status: pgtype.Present}
+	}
+
+	fallbackID := pgtype.Int4{Status: pgtype.Null}
+	if burner.FallbackDestinationID > 0 {
+		var fallback Destination
+		err = GetDestinationByID(ctx, db, &fallback, burner.FallbackDestinationID)
+		if err != nil {
+			return errors.WithMessage(err, "GetDestinationByID fallback")
+		}
+		fallbackID = pgtype.Int4{Int: int32(fallback.ID), Status: pgtype.Present}
+	}
+
 	// create alias
 	var aliasID int
 	err = db.QueryRow(
 		ctx,
-		`
+		fmt.Sprintf(`
 			WITH e AS (
-				INSERT INTO aliases (account_id, domain_id, rule) 
-				VALUES (current_setting('mxax.current_account_id')::INT, $1, $2) 
-				ON CONFLICT (domain_id, rule) DO UPDATE SET deleted_at = NULL RETURNING id
+				INSERT INTO aliases (account_id, domain_id, rule, catch_all, rule_type, expires_at, max_uses, fallback_destination_id, priority) 
+				VALUES (
+					current_setting('mxax.current_account_id')::INT,
+					$1,
+					$2,
+					$3,
+					$4,
+					$5,
+					$6,
+					$7,
+					(SELECT COALESCE(MAX(priority) + 1, 0) FROM aliases WHERE domain_id = $1 AND deleted_at IS NULL)
+				) 
+				ON CONFLICT %s DO UPDATE SET
+					deleted_at = NULL,
+					expires_at = EXCLUDED.expires_at,
+					max_uses = EXCLUDED.max_uses,
+					fallback_destination_id = EXCLUDED.fallback_destination_id,
+					uses = 0,
+					expired_at = NULL
+				RETURNING id
 			)
-			SELECT * FROM e UNION SELECT id FROM aliases WHERE domain_id = $1 AND rule = $2
-			`,
+			SELECT * FROM e UNION SELECT id FROM aliases WHERE domain_id = $1 AND %s
+			`, conflict, existing),
 		domainID,
 		rule,
+		catchAll,
+		ruleType,
+		expiresAt,
+		burner.MaxUses,
+		fallbackID,
 	).Scan(&aliasID)
 	if err != nil {
 		return errors.WithMessage(err, "INSERT aliases")
@@ -115,6 +337,42 @@ func CreateAlias(ctx context.Context, db pgx.Tx, rule string, domainID, destinat
 	return CreateAliasDestination(ctx, db, aliasID, destinationID)
 }
 
+// ReorderAliases sets the priority of each alias in aliasIDs to
+// its position, aliases not in domainID are ignored
+func ReorderAliases(ctx context.Context, db pgx.Tx, domainID int, aliasIDs []int) error {
+	for priority, aliasID := range aliasIDs {
+		_, err := db.Exec(
+			ctx,
+			`
+			UPDATE aliases SET priority = $1, updated_at = NOW()
+			WHERE
+				id = $2
+				AND domain_id = $3
+				AND deleted_at IS NULL
+			`,
+			priority,
+			aliasID,
+			domainID,
+		)
+		if err != nil {
+			return errors.WithMessage(err, "UPDATE aliases")
+		}
+	}
+
+	return nil
+}
+
+// SetAliasScrub turns scrubbing of forwarded mail on or off
+func SetAliasScrub(ctx context.Context, db pgx.Tx, aliasID int, scrub bool) error {
+	_, err := db.Exec(
+		ctx,
+		"UPDATE aliases SET scrub = $2, updated_at = NOW() WHERE id = $1",
+		aliasID,
+		scrub,
+	)
+	return err
+}
+
 func CreateAliasDestination(ctx context.Context, db pgx.Tx, aliasID, destinationID int) error {
 	_, err := db.Exec(
 		ctx,
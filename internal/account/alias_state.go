@@ -0,0 +1,138 @@
+package account
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// AliasState decides what happens to mail that matches an
+// Alias, an Alias that is not enabled still matches so that
+// it never falls through to a catch all
+type AliasState int
+
+const (
+	AliasStateEnabled AliasState = iota
+	// rejected as an unknown recipient
+	AliasStateDisabled
+	// rejected with the Alias' RejectMessage
+	AliasStateReject
+)
+
+// AliasStates in the order they are offered
+var AliasStates = []AliasState{AliasStateEnabled, AliasStateDisabled, AliasStateReject}
+
+func (s AliasState) String() string {
+	switch s {
+	case AliasStateDisabled:
+		return "disabled"
+	case AliasStateReject:
+		return "reject"
+	default:
+		return "enabled"
+	}
+}
+
+// ParseAliasState parses the name of an alias state
+func ParseAliasState(s string) (AliasState, error) {
+	for _, state := range AliasStates {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+	return 0, errors.Errorf("bad alias state: '%s'", s)
+}
+
+// longest reject message, it is sent as a single SMTP reply line
+const maxRejectMessage = 200
+
+// NormaliseRejectMessage validates a reject message, returning
+// it in the form it is stored
+func NormaliseRejectMessage(message string) (string, error) {
+	message = strings.TrimSpace(message)
+
+	if len(message) == 0 {
+		return "", errors.New("reject message is empty")
+	}
+
+	if len(message) > maxRejectMessage {
+		return "", errors.Errorf("reject message is longer than %d characters", maxRejectMessage)
+	}
+
+	for _, r := range message {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "", errors.New("reject message can only contain printable ASCII characters")
+		}
+	}
+
+	return message, nil
+}
+
+// Accepts reports if mail matching the Alias is forwarded at
+// now. If not the message to reject with is returned, an empty
+// message means it is rejected as an unknown recipient
+func (a *Alias) Accepts(now time.Time) (bool, string) {
+	switch a.State {
+	case AliasStateDisabled:
+		return false, ""
+	case AliasStateReject:
+		return false, a.RejectMessage
+	}
+
+	if a.ActiveFrom.Status == pgtype.Present && now.Before(a.ActiveFrom.Time) {
+		return false, ""
+	}
+
+	if a.ActiveUntil.Status == pgtype.Present && !now.Before(a.ActiveUntil.Time) {
+		return false, ""
+	}
+
+	return true, ""
+}
+
+// UpdateAliasState sets the state and active window of an Alias,
+// a zero from or until leaves that side of the window open
+func UpdateAliasState(ctx context.Context, db pgx.Tx, aliasID int, state AliasState, rejectMessage string, from, until time.Time) error {
+	if !from.IsZero() && !until.IsZero() && !until.After(from) {
+		return errors.New("active until must be after active from")
+	}
+
+	window := func(t time.Time) pgtype.Timestamptz {
+		if t.IsZero() {
+			return pgtype.Timestamptz{Status: pgtype.Null}
+		}
+		return pgtype.Timestamptz{Time: t, Status: pgtype.Present}
+	}
+
+	tag, err := db.Exec(
+		ctx,
+		`
+		UPDATE aliases SET
+			state = $2,
+			reject_message = $3,
+			active_from = $4,
+			active_until = $5,
+			updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+		`,
+		aliasID,
+		state,
+		rejectMessage,
+		window(from),
+		window(until),
+	)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.Errorf("alias %d not found", aliasID)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package account
+
+import (
+	"context"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// AutoCreates reports if an alias for user is created when no
+// other alias of the Domain matches
+func (d Domain) AutoCreates(user string) bool {
+	if len(d.AutoCreateRule) == 0 {
+		return false
+	}
+
+	rule := Alias{
+		Rule:     d.AutoCreateRule,
+		RuleType: RuleTypeGlob,
+	}
+
+	ok, err := rule.Check(user)
+	return ok && err == nil
+}
+
+// GetDefaultDestinations returns the destinations auto created
+// aliases of a domain forward to
+func GetDefaultDestinations(ctx context.Context, db pgxscan.Querier, destinations *[]Destination, domainID int) error {
+	return pgxscan.Select(
+		ctx,
+		db,
+		destinations,
+		`
+		SELECT d.*
+		FROM destinations AS d
+		JOIN default_destinations AS dd ON d.id = dd.destination_id
+		WHERE dd.domain_id = $1
+		AND dd.deleted_at IS NULL
+		AND d.deleted_at IS NULL
+		ORDER BY d.address
+		`,
+		domainID,
+	)
+}
+
+// SetAutoCreate sets the auto create rule of a domain and the
+// destinations its auto created aliases forward to, an empty
+// rule turns auto create off
+func SetAutoCreate(ctx context.Context, db pgx.Tx, domainID int, rule string, destinationIDs []int) error {
+	if len(rule) > 0 {
+		var err error
+		rule, err = NormaliseRule(RuleTypeGlob, rule)
+		if err != nil {
+			return err
+		}
+
+		if len(destinationIDs) == 0 {
+			return errors.New("auto create needs at least one destination")
+		}
+	}
+
+	tag, err := db.Exec(
+		ctx,
+		"UPDATE domains SET auto_create_rule = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL",
+		domainID,
+		rule,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "UPDATE domains")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.Errorf("domain %d not found", domainID)
+	}
+
+	_, err = db.Exec(
+		ctx,
+		`
+		UPDATE default_destinations SET deleted_at = NOW()
+		WHERE domain_id = $1 AND NOT destination_id = ANY($2)
+		`,
+		domainID,
+		destinationIDs,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "UPDATE default_destinations")
+	}
+
+	for _, destinationID := range destinationIDs {
+		// validate that destination belongs to this account
+		var destination Destination
+		err := GetDestinationByID(ctx, db, &destination, destinationID)
+		if err != nil {
+			return errors.WithMessage(err, "GetDestinationByID")
+		}
+
+		_, err = db.Exec(
+			ctx,
+			`
+			INSERT INTO default_destinations (account_id, domain_id, destination_id)
+			VALUES (current_setting('mxax.current_account_id')::INT, $1, $2)
+			ON CONFLICT (domain_id, destination_id) DO UPDATE SET deleted_at = NULL
+			`,
+			domainID,
+			destinationID,
+		)
+		if err != nil {
+			return errors.WithMessage(err, "INSERT default_destinations")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package account
+
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/pkg/errors"
+)
+
+// Burner limits how long an Alias forwards mail for, a zero
+// Burner never expires
+type Burner struct {
+	// zero for no expiry time
+	ExpiresAt time.Time
+	// zero for no limit
+	MaxUses int
+	// expired mail is forwarded here, zero rejects it
+	FallbackDestinationID int
+}
+
+// IsZero reports if the Burner has no limits
+func (b Burner) IsZero() bool {
+	return b.ExpiresAt.IsZero() && b.MaxUses == 0
+}
+
+// Validate checks the limits of a Burner at now
+func (b Burner) Validate(now time.Time) error {
+	if !b.ExpiresAt.IsZero() && !b.ExpiresAt.After(now) {
+		return errors.New("expiry must be in the future")
+	}
+
+	if b.MaxUses < 0 {
+		return errors.New("max messages can not be negative")
+	}
+
+	if b.IsZero() && b.FallbackDestinationID > 0 {
+		return errors.New("a fallback needs an expiry or max messages")
+	}
+
+	return nil
+}
+
+// IsBurner reports if the Alias expires
+func (a Alias) IsBurner() bool {
+	return a.ExpiresAt.Status == pgtype.Present || a.MaxUses > 0
+}
+
+// Expired reports if a burner Alias had expired at now, Uses
+// may be stale so this is only a hint until the use is claimed
+func (a Alias) Expired(now time.Time) bool {
+	if a.ExpiredAt.Status == pgtype.Present {
+		return true
+	}
+
+	if a.ExpiresAt.Status == pgtype.Present && !now.Before(a.ExpiresAt.Time) {
+		return true
+	}
+
+	return a.MaxUses > 0 && a.Uses >= a.MaxUses
+}
+
+// HasFallback reports if expired mail is forwarded rather
+// than rejected
+func (a Alias) HasFallback() bool {
+	return a.FallbackDestinationID.Status == pgtype.Present
+}
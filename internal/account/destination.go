@@ -65,3 +65,21 @@ func GetDestinationByID(ctx context.Context, db pgx.Tx, destination *Destination
 		destinationID,
 	)
 }
+
+// GetAliasDestinations returns the destinations an alias forwards to
+func GetAliasDestinations(ctx context.Context, db pgxscan.Querier, destinations *[]Destination, aliasID int) error {
+	return pgxscan.Select(
+		ctx,
+		db,
+		destinations,
+		`
+		SELECT d.* 
+		FROM destinations AS d 
+		JOIN alias_destinations AS ad ON d.id = ad.destination_id 
+		WHERE ad.alias_id = $1
+		AND ad.deleted_at IS NULL
+		AND d.deleted_at IS NULL
+		`,
+		aliasID,
+	)
+}
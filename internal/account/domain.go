@@ -35,6 +35,14 @@ type Domain struct {
 	// when the domain expires
 	ExpiresAt pgtype.Date
 
+	// glob of local parts that get an alias created on first
+	// receipt when no other alias matches, empty is off
+	AutoCreateRule string
+
+	// forwarded mail is shown as from a ReverseAlias of each
+	// correspondent so replies keep the destination hidden
+	ReverseAliases bool
+
 	MetaData
 }
 
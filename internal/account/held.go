@@ -0,0 +1,118 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// HeldClaimTimeout is how long a server has to queue a held email
+// it has claimed before it is treated as held again, in case the
+// server went away
+const HeldClaimTimeout = time.Minute * 10
+
+// unclaimed matches held emails that are not being released
+var unclaimed = fmt.Sprintf(
+	"(released_at IS NULL OR released_at < NOW() - INTERVAL '%d seconds')",
+	int(HeldClaimTimeout.Seconds()),
+)
+
+// HeldEmail is a submitted email waiting for its release time
+type HeldEmail struct {
+	ID        int
+	EmailID   uuid.UUID
+	AccountID int
+	DomainID  int
+
+	FromEmail string
+	ToEmail   string
+	Subject   string
+
+	ReleaseAt time.Time
+	// set while the email is being queued, see HeldClaimTimeout
+	ReleasedAt pgtype.Timestamptz
+
+	CreatedAt time.Time
+}
+
+// GetHeldEmails returns the held emails visible to db, soonest
+// first
+func GetHeldEmails(ctx context.Context, db pgx.Tx, held *[]HeldEmail) error {
+	return pgxscan.Select(
+		ctx,
+		db,
+		held,
+		`
+		SELECT id, email_id, account_id, domain_id, from_email, to_email, subject, release_at, released_at, created_at
+		FROM held_emails
+		WHERE `+unclaimed+`
+		ORDER BY release_at
+		`,
+	)
+}
+
+func GetHeldEmailByID(ctx context.Context, db pgx.Tx, held *HeldEmail, heldID int) error {
+	return pgxscan.Get(
+		ctx,
+		db,
+		held,
+		`
+		SELECT id, email_id, account_id, domain_id, from_email, to_email, subject, release_at, released_at, created_at
+		FROM held_emails
+		WHERE
+			id = $1
+			AND `+unclaimed+`
+		`,
+		heldID,
+	)
+}
+
+// CancelHeldEmail removes a held email so it is never sent,
+// fails if it is already being released
+func CancelHeldEmail(ctx context.Context, db pgx.Tx, heldID int) error {
+	tag, err := db.Exec(
+		ctx,
+		"DELETE FROM held_emails WHERE id = $1 AND "+unclaimed,
+		heldID,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "DELETE held_emails")
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errors.New("email has already been released")
+	}
+
+	return nil
+}
+
+// CreateHeldEmail stores email, an encoded smtp.Email, until the
+// ReleaseAt time of held
+func CreateHeldEmail(ctx context.Context, db pgx.Tx, held HeldEmail, email []byte) error {
+	_, err := db.Exec(
+		ctx,
+		`
+		INSERT INTO held_emails (email_id, account_id, domain_id, from_email, to_email, subject, email, release_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`,
+		held.EmailID,
+		held.AccountID,
+		held.DomainID,
+		held.FromEmail,
+		held.ToEmail,
+		held.Subject,
+		email,
+		held.ReleaseAt,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "INSERT held_emails")
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// the local part is limited to 64 characters, leaving room
+// for the separator and the id
+const maxReverseAliasPrefix = 64 - 37
+
+// ReverseAlias stands in for a correspondent of an Alias, mail
+// sent to it by a destination goes on to the correspondent from
+// the address they originally wrote to
+type ReverseAlias struct {
+	ID uuid.UUID
+
+	AccountID int
+	DomainID  int
+	AliasID   int
+
+	// the address the correspondent wrote to
+	AliasAddress string
+
+	Correspondent string
+
+	CreatedAt  time.Time
+	LastUsedAt pgtype.Timestamp
+}
+
+// Address returns the reverse alias in the form of
+// correspondent=id@domain, the same form as return paths
+func (r ReverseAlias) Address(domain string) string {
+	return fmt.Sprintf("%s=%s@%s", reverseAliasPrefix(r.Correspondent), r.ID, domain)
+}
+
+// reverseAliasPrefix makes the correspondent readable in a
+// local part, i.e. bob@example.com becomes bob_at_example.com
+func reverseAliasPrefix(correspondent string) string {
+	correspondent = strings.Replace(strings.ToLower(correspondent), "@", "_at_", 1)
+
+	var b strings.Builder
+	for _, c := range correspondent {
+		if b.Len() >= maxReverseAliasPrefix {
+			break
+		}
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	return strings.Trim(b.String(), ".")
+}
+
+// GetReverseAliases returns the most recently used reverse
+// aliases of an alias
+func GetReverseAliases(ctx context.Context, db pgxscan.Querier, reverseAliases *[]ReverseAlias, aliasID int) error {
+	return pgxscan.Select(
+		ctx,
+		db,
+		reverseAliases,
+		`
+		SELECT *
+		FROM reverse_aliases
+		WHERE alias_id = $1
+		ORDER BY COALESCE(last_used_at, created_at) DESC
+		LIMIT 50
+		`,
+		aliasID,
+	)
+}
+
+// SetReverseAliases turns reverse aliases on or off for a domain
+func SetReverseAliases(ctx context.Context, db pgx.Tx, domainID int, enabled bool) error {
+	tag, err := db.Exec(
+		ctx,
+		"UPDATE domains SET reverse_aliases = $2, updated_at = NOW() WHERE id = $1 AND deleted_at IS NULL",
+		domainID,
+		enabled,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "UPDATE domains")
+	}
+
+	if tag.RowsAffected() != 1 {
+		return errors.Errorf("domain %d not found", domainID)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package account
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RuleType decides how an Alias' rule is matched against
+// the local part of an address
+type RuleType int
+
+const (
+	// a Go regular expression anchored to the whole local part
+	RuleTypeRegex RuleType = iota
+	// the local part itself
+	RuleTypeExact
+	// * matches any run of characters and ? a single one,
+	// i.e. shop-*
+	RuleTypeGlob
+	// the local part with or without a +tag, i.e. me matches
+	// me and me+shop
+	RuleTypeSubaddress
+)
+
+// RuleTypes in the order they are offered
+var RuleTypes = []RuleType{RuleTypeExact, RuleTypeGlob, RuleTypeSubaddress, RuleTypeRegex}
+
+func (t RuleType) String() string {
+	switch t {
+	case RuleTypeExact:
+		return "exact"
+	case RuleTypeGlob:
+		return "glob"
+	case RuleTypeSubaddress:
+		return "subaddress"
+	default:
+		return "regex"
+	}
+}
+
+// ParseRuleType parses the name of a rule type
+func ParseRuleType(s string) (RuleType, error) {
+	for _, t := range RuleTypes {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, errors.Errorf("bad rule type: '%s'", s)
+}
+
+// limits on regex rules, every alias of a domain is checked
+// against each incoming recipient
+const (
+	maxRegexLength       = 256
+	maxRegexInstructions = 500
+)
+
+// longest local part allowed by RFC 5321
+const maxLocalPart = 64
+
+// characters allowed in a local part, dot-atom of RFC 5322
+var localPartRegex = regexp.MustCompile("^[a-z0-9!#$%&'*+/=?^_`{|}~.-]+$")
+
+// NormaliseRule validates rule for ruleType, returning it in
+// the form it is stored
+func NormaliseRule(ruleType RuleType, rule string) (string, error) {
+	rule = strings.TrimSpace(rule)
+
+	if len(rule) == 0 {
+		return "", errors.New("rule is empty")
+	}
+
+	switch ruleType {
+	case RuleTypeExact:
+		rule = strings.ToLower(rule)
+		if err := checkLocalPart(rule); err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(rule, "*?") {
+			return "", errors.New("exact rules can not contain * or ?, use a glob rule")
+		}
+
+	case RuleTypeGlob:
+		rule = strings.ToLower(rule)
+		if err := checkLocalPart(rule); err != nil {
+			return "", err
+		}
+
+	case RuleTypeSubaddress:
+		rule = strings.TrimSuffix(strings.ToLower(rule), "+*")
+		if err := checkLocalPart(rule); err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(rule, "+*?") {
+			return "", errors.New("subaddress rules are the address before the +, i.e. me or me+*")
+		}
+
+	case RuleTypeRegex:
+		if err := checkRegex(rule); err != nil {
+			return "", err
+		}
+
+	default:
+		return "", errors.Errorf("bad rule type: %d", ruleType)
+	}
+
+	return rule, nil
+}
+
+// checkRegex limits the length and complexity of a regex rule
+// as it is anchored and run against every address
+func checkRegex(rule string) error {
+	if len(rule) > maxRegexLength {
+		return errors.Errorf("regex is longer than %d characters", maxRegexLength)
+	}
+
+	re, err := syntax.Parse(anchorRegex(rule), syntax.Perl)
+	if err != nil {
+		return err
+	}
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return err
+	}
+
+	if len(prog.Inst) > maxRegexInstructions {
+		return errors.New("regex is too complex, try a simpler rule or a glob")
+	}
+
+	return nil
+}
+
+func checkLocalPart(s string) error {
+	if len(s) > maxLocalPart {
+		return errors.Errorf("rule is longer than %d characters", maxLocalPart)
+	}
+
+	if !localPartRegex.MatchString(s) {
+		return errors.New("rule contains characters not allowed in an address")
+	}
+
+	if strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") || strings.Contains(s, "..") {
+		return errors.New("rule has a misplaced '.'")
+	}
+
+	return nil
+}
+
+// anchor a regex rule to the whole local part, matching is
+// case insensitive as local parts are lowered
+func anchorRegex(rule string) string {
+	rule = strings.ToLower(rule)
+	rule = strings.TrimPrefix(rule, "^")
+	rule = strings.TrimSuffix(rule, "$")
+	return "^" + rule + "$"
+}
+
+// globRegex turns a glob rule in to an anchored regex
+func globRegex(rule string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range rule {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
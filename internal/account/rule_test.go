@@ -0,0 +1,94 @@
+package account
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormaliseRule(t *testing.T) {
+	for _, tc := range []struct {
+		ruleType RuleType
+		rule     string
+		want     string
+		err      bool
+	}{
+		{RuleTypeExact, " Shop ", "shop", false},
+		{RuleTypeExact, "first.last", "first.last", false},
+		{RuleTypeExact, "shop-*", "", true},
+		{RuleTypeExact, ".shop", "", true},
+		{RuleTypeExact, "sh..op", "", true},
+		{RuleTypeExact, "shop@example.com", "", true},
+		{RuleTypeExact, strings.Repeat("a", maxLocalPart+1), "", true},
+		{RuleTypeExact, "", "", true},
+		{RuleTypeGlob, "Shop-*", "shop-*", false},
+		{RuleTypeGlob, "s?op", "s?op", false},
+		{RuleTypeGlob, "shop space", "", true},
+		{RuleTypeSubaddress, "Me", "me", false},
+		{RuleTypeSubaddress, "me+*", "me", false},
+		{RuleTypeSubaddress, "me+shop", "", true},
+		{RuleTypeRegex, "^Shop.*$", "^Shop.*$", false},
+		{RuleTypeRegex, "shop(", "", true},
+		{RuleTypeRegex, strings.Repeat("a", maxRegexLength+1), "", true},
+		{RuleTypeRegex, "(a{1,100}){1,100}", "", true},
+		{RuleType(99), "shop", "", true},
+	} {
+		got, err := NormaliseRule(tc.ruleType, tc.rule)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s '%s': expected an error, got '%s'", tc.ruleType, tc.rule, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s '%s': %s", tc.ruleType, tc.rule, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("%s '%s': expected '%s', got '%s'", tc.ruleType, tc.rule, tc.want, got)
+		}
+	}
+}
+
+func TestGlobRegex(t *testing.T) {
+	tests := map[string]string{
+		"shop":   "^shop$",
+		"shop-*": "^shop-.*$",
+		"s?op":   "^s.op$",
+		"a.b+c":  `^a\.b\+c$`,
+	}
+
+	for rule, want := range tests {
+		if got := globRegex(rule); got != want {
+			t.Errorf("'%s': expected '%s', got '%s'", rule, want, got)
+		}
+	}
+}
+
+// checkAlias asserts which users an alias does and does not match
+func checkAlias(t *testing.T, alias Alias, match, miss []string) {
+	t.Helper()
+
+	for _, user := range match {
+		if ok, err := alias.Check(user); err != nil || !ok {
+			t.Errorf("%s '%s': expected '%s' to match (%v)", alias.RuleType, alias.Rule, user, err)
+		}
+	}
+
+	for _, user := range miss {
+		if ok, err := alias.Check(user); err != nil || ok {
+			t.Errorf("%s '%s': expected '%s' not to match (%v)", alias.RuleType, alias.Rule, user, err)
+		}
+	}
+}
+
+func TestAliasCheck(t *testing.T) {
+	checkAlias(t, Alias{RuleType: RuleTypeExact, Rule: "shop"}, []string{"Shop"}, []string{"workshop"})
+	checkAlias(t, Alias{RuleType: RuleTypeGlob, Rule: "shop-*"}, []string{"shop-amazon"}, []string{"shop"})
+	checkAlias(t, Alias{RuleType: RuleTypeGlob, Rule: "s?op"}, []string{"stop"}, nil)
+	checkAlias(t, Alias{RuleType: RuleTypeGlob, Rule: "a.b"}, nil, []string{"axb"})
+	checkAlias(t, Alias{RuleType: RuleTypeSubaddress, Rule: "me"}, []string{"me", "me+shop"}, []string{"meet"})
+	checkAlias(t, Alias{RuleType: RuleTypeRegex, Rule: "shop"}, nil, []string{"workshop"})
+	checkAlias(t, Alias{RuleType: RuleTypeRegex, Rule: "^shop.*"}, []string{"SHOPPING"}, nil)
+}
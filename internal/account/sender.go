@@ -0,0 +1,258 @@
+package account
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// SenderRuleType decides how a SenderRule's pattern is matched
+// against the envelope sender
+type SenderRuleType int
+
+const (
+	// the whole address
+	SenderRuleAddress SenderRuleType = iota
+	// the domain of the address or any of its subdomains
+	SenderRuleDomain
+	// a Go regular expression anchored to the whole address
+	SenderRuleRegex
+)
+
+// SenderRuleTypes in the order they are offered
+var SenderRuleTypes = []SenderRuleType{SenderRuleAddress, SenderRuleDomain, SenderRuleRegex}
+
+func (t SenderRuleType) String() string {
+	switch t {
+	case SenderRuleDomain:
+		return "domain"
+	case SenderRuleRegex:
+		return "regex"
+	default:
+		return "address"
+	}
+}
+
+// ParseSenderRuleType parses the name of a sender rule type
+func ParseSenderRuleType(s string) (SenderRuleType, error) {
+	for _, t := range SenderRuleTypes {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, errors.Errorf("bad sender rule type: '%s'", s)
+}
+
+// SenderAction is what happens to mail from a sender that an
+// Alias does not accept
+type SenderAction int
+
+const (
+	// rejected as an unknown recipient
+	SenderActionReject SenderAction = iota
+	// accepted and thrown away
+	SenderActionDrop
+)
+
+// SenderActions in the order they are offered
+var SenderActions = []SenderAction{SenderActionReject, SenderActionDrop}
+
+func (a SenderAction) String() string {
+	switch a {
+	case SenderActionDrop:
+		return "drop"
+	default:
+		return "reject"
+	}
+}
+
+// ParseSenderAction parses the name of a sender action
+func ParseSenderAction(s string) (SenderAction, error) {
+	for _, a := range SenderActions {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, errors.Errorf("bad sender action: '%s'", s)
+}
+
+// SenderRule allows or blocks envelope senders of an Alias. If
+// an Alias has any allow rules only senders matching one are
+// accepted, otherwise senders matching a block rule are refused
+type SenderRule struct {
+	ID int
+
+	AccountID int
+	AliasID   int
+
+	Pattern     string
+	PatternType SenderRuleType
+	Allow       bool
+
+	// internal use, compiled regex patterns
+	re *regexp.Regexp
+
+	MetaData
+}
+
+// NormaliseSenderPattern validates pattern for ruleType, returning
+// it in the form it is stored
+func NormaliseSenderPattern(ruleType SenderRuleType, pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+
+	if len(pattern) == 0 {
+		return "", errors.New("pattern is empty")
+	}
+
+	switch ruleType {
+	case SenderRuleAddress:
+		parts := strings.Split(pattern, "@")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return "", errors.Errorf("'%s' is not an address", pattern)
+		}
+
+	case SenderRuleDomain:
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "@"), "*.")
+		if strings.ContainsAny(pattern, "@* ") || !strings.Contains(pattern, ".") {
+			return "", errors.Errorf("'%s' is not a domain", pattern)
+		}
+
+	case SenderRuleRegex:
+		if err := checkRegex(pattern); err != nil {
+			return "", err
+		}
+
+	default:
+		return "", errors.Errorf("bad sender rule type: %d", ruleType)
+	}
+
+	return pattern, nil
+}
+
+// Check reports if sender matches the rule
+func (r SenderRule) Check(sender string) (bool, error) {
+	sender = strings.ToLower(sender)
+
+	switch r.PatternType {
+	case SenderRuleAddress:
+		return sender == r.Pattern, nil
+
+	case SenderRuleDomain:
+		parts := strings.Split(sender, "@")
+		domain := parts[len(parts)-1]
+		return domain == r.Pattern || strings.HasSuffix(domain, "."+r.Pattern), nil
+	}
+
+	re := r.re
+	if re == nil {
+		var err error
+		re, err = regexp.Compile(anchorRegex(r.Pattern))
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return re.MatchString(sender), nil
+}
+
+// SenderAllowed checks sender against an Alias' rules, rules
+// that fail to compile never match
+func SenderAllowed(rules []SenderRule, sender string) bool {
+	allowList := false
+
+	for _, rule := range rules {
+		ok, err := rule.Check(sender)
+		ok = ok && err == nil
+
+		if rule.Allow {
+			allowList = true
+			if ok {
+				return true
+			}
+			continue
+		}
+
+		if ok {
+			return false
+		}
+	}
+
+	return !allowList
+}
+
+// GetSenderRules returns the sender rules of an Alias
+func GetSenderRules(ctx context.Context, db pgxscan.Querier, rules *[]SenderRule, aliasID int) error {
+	err := pgxscan.Select(
+		ctx,
+		db,
+		rules,
+		`
+		SELECT *
+		FROM sender_rules
+		WHERE
+			alias_id = $1
+			AND deleted_at IS NULL
+		ORDER BY allow DESC, pattern
+		`,
+		aliasID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// compile regex patterns once so cached rules can be
+	// checked against each message, those that fail to
+	// compile never match
+	for i := range *rules {
+		rule := &(*rules)[i]
+		if rule.PatternType == SenderRuleRegex {
+			rule.re, _ = regexp.Compile(anchorRegex(rule.Pattern))
+		}
+	}
+
+	return nil
+}
+
+// CreateSenderRule adds a sender rule to an Alias
+func CreateSenderRule(ctx context.Context, db pgx.Tx, aliasID int, ruleType SenderRuleType, pattern string, allow bool) error {
+	pattern, err := NormaliseSenderPattern(ruleType, pattern)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		ctx,
+		`
+		INSERT INTO sender_rules (account_id, alias_id, pattern, pattern_type, allow)
+		VALUES (current_setting('mxax.current_account_id')::INT, $1, $2, $3, $4)
+		ON CONFLICT (alias_id, pattern, allow) DO UPDATE SET
+			deleted_at = NULL,
+			pattern_type = EXCLUDED.pattern_type
+		`,
+		aliasID,
+		pattern,
+		ruleType,
+		allow,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "INSERT sender_rules")
+	}
+
+	return nil
+}
+
+// SetSenderAction sets what happens to mail from senders an
+// Alias does not accept
+func SetSenderAction(ctx context.Context, db pgx.Tx, aliasID int, action SenderAction) error {
+	_, err := db.Exec(
+		ctx,
+		"UPDATE aliases SET sender_action = $2, updated_at = NOW() WHERE id = $1",
+		aliasID,
+		action,
+	)
+	return err
+}
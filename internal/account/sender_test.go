@@ -0,0 +1,40 @@
+package account
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormaliseSenderPatternRegex(t *testing.T) {
+	if _, err := NormaliseSenderPattern(SenderRuleRegex, `.*@(shop|news)\.example\.com`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same limits as alias regex rules
+	for _, pattern := range []string{"(a{1,100}){1,100}", strings.Repeat("a", maxRegexLength+1), "shop("} {
+		if _, err := NormaliseSenderPattern(SenderRuleRegex, pattern); err == nil {
+			t.Errorf("'%.20s': expected an error", pattern)
+		}
+	}
+}
+
+func TestSenderAllowed(t *testing.T) {
+	block := []SenderRule{
+		{PatternType: SenderRuleDomain, Pattern: "spam.test"},
+		{PatternType: SenderRuleRegex, Pattern: `news.*@.*`},
+	}
+
+	if SenderAllowed(block, "offers@mail.spam.test") || SenderAllowed(block, "newsletter@shop.test") {
+		t.Error("expected blocked senders to be refused")
+	}
+
+	if !SenderAllowed(block, "alice@sender.test") {
+		t.Error("expected other senders to be allowed")
+	}
+
+	allow := []SenderRule{{PatternType: SenderRuleAddress, Pattern: "alice@sender.test", Allow: true}}
+
+	if !SenderAllowed(allow, "Alice@Sender.test") || SenderAllowed(allow, "bob@sender.test") {
+		t.Error("expected only allowed senders to be accepted")
+	}
+}
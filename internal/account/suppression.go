@@ -0,0 +1,194 @@
+package account
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// Suppression is a destination address that we no longer
+// send to, either for a single Account or for everyone
+type Suppression struct {
+	ID int
+
+	// null for the global list
+	AccountID pgtype.Int4
+
+	Address string
+	Reason  string
+
+	// permanent failures so far
+	Failures int
+
+	CreatedAt    time.Time
+	SuppressedAt pgtype.Timestamp
+}
+
+// RecordPermanentFailure counts a permanent failure for address and
+// suppresses it once it has failed threshold times. Returns true if
+// the address is suppressed
+func RecordPermanentFailure(ctx context.Context, db pgx.Tx, accountID int, address, reason string, threshold int) (bool, error) {
+	var suppressed bool
+	err := db.QueryRow(
+		ctx,
+		`
+		INSERT INTO suppressions (account_id, address, reason, failures, suppressed_at)
+			VALUES ($1, $2, $3, 1, CASE WHEN 1 >= $4 THEN NOW() END)
+			ON CONFLICT (COALESCE(account_id, 0), address) DO UPDATE SET
+				failures = suppressions.failures + 1,
+				reason = EXCLUDED.reason,
+				suppressed_at = CASE
+					WHEN suppressions.failures + 1 >= $4 THEN COALESCE(suppressions.suppressed_at, NOW())
+					ELSE suppressions.suppressed_at
+				END
+		RETURNING suppressed_at IS NOT NULL
+		`,
+		accountID,
+		strings.ToLower(address),
+		reason,
+		threshold,
+	).Scan(&suppressed)
+	if err != nil {
+		return false, errors.WithMessage(err, "INSERT suppressions")
+	}
+
+	return suppressed, nil
+}
+
+// HasFailures checks if any permanent failures are counted
+// against an address that has not yet been suppressed
+func HasFailures(ctx context.Context, db pgx.Tx, accountID int, address string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(
+		ctx,
+		`
+		SELECT EXISTS (
+			SELECT 1 FROM suppressions
+			WHERE
+				COALESCE(account_id, 0) = $1
+				AND address = $2
+				AND suppressed_at IS NULL
+		)
+		`,
+		accountID,
+		strings.ToLower(address),
+	).Scan(&exists)
+	if err != nil {
+		return false, errors.WithMessage(err, "SELECT suppressions")
+	}
+
+	return exists, nil
+}
+
+// ResetFailures clears any permanent failures counted against
+// an address that has not yet been suppressed
+func ResetFailures(ctx context.Context, db pgx.Tx, accountID int, address string) error {
+	_, err := db.Exec(
+		ctx,
+		`
+		DELETE FROM suppressions
+		WHERE
+			COALESCE(account_id, 0) = $1
+			AND address = $2
+			AND suppressed_at IS NULL
+		`,
+		accountID,
+		strings.ToLower(address),
+	)
+	if err != nil {
+		return errors.WithMessage(err, "DELETE suppressions")
+	}
+
+	return nil
+}
+
+// Suppress address straight away, an accountID of 0 adds it
+// to the global list
+func Suppress(ctx context.Context, db pgx.Tx, accountID int, address, reason string) error {
+	_, err := db.Exec(
+		ctx,
+		`
+		INSERT INTO suppressions (account_id, address, reason, suppressed_at)
+			VALUES (NULLIF($1, 0), $2, $3, NOW())
+			ON CONFLICT (COALESCE(account_id, 0), address) DO UPDATE SET
+				reason = EXCLUDED.reason,
+				suppressed_at = COALESCE(suppressions.suppressed_at, NOW())
+		`,
+		accountID,
+		strings.ToLower(address),
+		reason,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "INSERT suppressions")
+	}
+
+	return nil
+}
+
+func GetSuppressionByID(ctx context.Context, db pgx.Tx, suppression *Suppression, suppressionID int) error {
+	return pgxscan.Get(
+		ctx,
+		db,
+		suppression,
+		`
+		SELECT *
+		FROM suppressions
+		WHERE
+			id = $1
+			AND suppressed_at IS NOT NULL
+		`,
+		suppressionID,
+	)
+}
+
+// DeleteSuppression removes a suppression so the address
+// will be sent to again
+func DeleteSuppression(ctx context.Context, db pgx.Tx, suppressionID int) error {
+	_, err := db.Exec(
+		ctx,
+		"DELETE FROM suppressions WHERE id = $1",
+		suppressionID,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "DELETE suppressions")
+	}
+
+	return nil
+}
+
+// GetGlobalSuppressions returns every address on the global list
+func GetGlobalSuppressions(ctx context.Context, db pgxscan.Querier, suppressions *[]Suppression) error {
+	return pgxscan.Select(
+		ctx,
+		db,
+		suppressions,
+		`
+		SELECT *
+		FROM suppressions
+		WHERE
+			account_id IS NULL
+			AND suppressed_at IS NOT NULL
+		ORDER BY address
+		`,
+	)
+}
+
+// DeleteGlobalSuppression removes address from the global list,
+// returns false if it was not on it
+func DeleteGlobalSuppression(ctx context.Context, db pgx.Tx, address string) (bool, error) {
+	tag, err := db.Exec(
+		ctx,
+		"DELETE FROM suppressions WHERE account_id IS NULL AND address = $1",
+		strings.ToLower(address),
+	)
+	if err != nil {
+		return false, errors.WithMessage(err, "DELETE suppressions")
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,49 @@
+package account
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// longest subject tag, it is added to every forwarded subject
+const maxSubjectTag = 32
+
+// NormaliseSubjectTag validates a subject tag, returning it in
+// the form it is stored. An empty tag turns tagging off
+func NormaliseSubjectTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+
+	if len(tag) > maxSubjectTag {
+		return "", errors.Errorf("subject tag is longer than %d characters", maxSubjectTag)
+	}
+
+	for _, r := range tag {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "", errors.New("subject tag can only contain printable ASCII characters")
+		}
+	}
+
+	return tag, nil
+}
+
+// SetAliasTagging sets the subject tag of an Alias and if its
+// forwarded mail is given X-MXAX-Alias and X-MXAX-Domain headers
+func SetAliasTagging(ctx context.Context, db pgx.Tx, aliasID int, subjectTag string, tagHeaders bool) error {
+	subjectTag, err := NormaliseSubjectTag(subjectTag)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		ctx,
+		"UPDATE aliases SET subject_tag = $2, tag_headers = $3, updated_at = NOW() WHERE id = $1",
+		aliasID,
+		subjectTag,
+		tagHeaders,
+	)
+	return err
+}
@@ -0,0 +1,161 @@
+package arf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Report is an abuse report in the Abuse Reporting Format
+// as defined in RFC 5965
+type Report struct {
+	// required fields of the message/feedback-report part
+	FeedbackType string
+	UserAgent    string
+	Version      string
+
+	// optional fields that help us find the original
+	OriginalMailFrom string
+	OriginalRcptTo   []string
+	ArrivalDate      string
+	ReportingMTA     string
+	SourceIP         string
+
+	// headers of the original message, from either a
+	// message/rfc822 or text/rfc822-headers part
+	Original mail.Header
+}
+
+// Parse reads an email and extracts the feedback report
+func Parse(r io.Reader) (*Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ReadMessage")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.WithMessage(err, "ParseMediaType")
+	}
+
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "feedback-report") {
+		return nil, errors.Errorf("not a feedback report: '%s'", msg.Header.Get("Content-Type"))
+	}
+
+	var report *Report
+	var original mail.Header
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "NextPart")
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch partType {
+		case "message/feedback-report":
+			report, err = parseFeedbackReport(part)
+			if err != nil {
+				return nil, errors.WithMessage(err, "parseFeedbackReport")
+			}
+
+		case "message/rfc822", "text/rfc822-headers":
+			body, err := ioutil.ReadAll(decodePart(part))
+			if err != nil {
+				return nil, errors.WithMessage(err, "ReadAll original")
+			}
+
+			original, err = parseHeaders(body)
+			if err != nil {
+				return nil, errors.WithMessage(err, "parseHeaders")
+			}
+		}
+	}
+
+	if report == nil {
+		return nil, errors.New("no message/feedback-report part found")
+	}
+
+	report.Original = original
+
+	return report, nil
+}
+
+func parseFeedbackReport(r io.Reader) (*Report, error) {
+	header, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, errors.WithMessage(err, "ReadMIMEHeader")
+	}
+
+	report := &Report{
+		FeedbackType:     strings.ToLower(header.Get("Feedback-Type")),
+		UserAgent:        header.Get("User-Agent"),
+		Version:          header.Get("Version"),
+		OriginalMailFrom: trimAddress(header.Get("Original-Mail-From")),
+		ArrivalDate:      header.Get("Arrival-Date"),
+		ReportingMTA:     header.Get("Reporting-MTA"),
+		SourceIP:         header.Get("Source-IP"),
+	}
+
+	for _, rcpt := range header.Values("Original-Rcpt-To") {
+		report.OriginalRcptTo = append(report.OriginalRcptTo, trimAddress(rcpt))
+	}
+
+	if len(report.FeedbackType) == 0 {
+		return nil, errors.New("missing Feedback-Type")
+	}
+
+	return report, nil
+}
+
+// parse just the headers of an original message, the body if
+// there is one is ignored
+func parseHeaders(b []byte) (mail.Header, error) {
+	// text/rfc822-headers may be missing the blank line that
+	// ends the header block
+	if !bytes.Contains(b, []byte("\r\n\r\n")) && !bytes.Contains(b, []byte("\n\n")) {
+		b = append(b, "\r\n\r\n"...)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Header, nil
+}
+
+// multipart.Reader only decodes quoted-printable, some reporters
+// base64 encode the original
+func decodePart(part *multipart.Part) io.Reader {
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// trim an address in the form of <user@domain> or rfc822;user@domain
+func trimAddress(s string) string {
+	s = strings.TrimSpace(s)
+	if idx := strings.Index(s, ";"); idx >= 0 {
+		s = s[idx+1:]
+	}
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "<")
+	s = strings.TrimSuffix(s, ">")
+	return strings.ToLower(s)
+}
@@ -0,0 +1,100 @@
+package arf
+
+import (
+	"strings"
+	"testing"
+)
+
+const testReport = `From: fbl@provider.test
+To: fbl@mx.ax
+Subject: Abuse report
+Content-Type: multipart/report; report-type=feedback-report; boundary="b"
+
+--b
+Content-Type: text/plain
+
+This is an abuse report.
+
+--b
+Content-Type: message/feedback-report
+
+Feedback-Type: Abuse
+User-Agent: ProviderFBL/1.0
+Version: 1
+Original-Mail-From: <Jess=0F8C6F5E-8B0A-4F8E-9D43-2F0C3F9A1D11@example.com>
+Original-Rcpt-To: <jess@dest.test>
+Original-Rcpt-To: rfc822;sam@dest.test
+Source-IP: 192.0.2.1
+
+--b
+Content-Type: message/rfc822
+
+From: Alice <alice@sender.test>
+Message-ID: <hello@sender.test>
+
+Hello
+--b--
+`
+
+// replace swaps the first old for new in the test report
+func replace(old, new string) string {
+	return strings.Replace(testReport, old, new, 1)
+}
+
+func parse(t *testing.T, message string) *Report {
+	t.Helper()
+
+	report, err := Parse(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "jess=0f8c6f5e-8b0a-4f8e-9d43-2f0c3f9a1d11@example.com"; report.OriginalMailFrom != want {
+		t.Errorf("expected original mail from '%s', got '%s'", want, report.OriginalMailFrom)
+	}
+
+	if got := strings.Join(report.OriginalRcptTo, ","); got != "jess@dest.test,sam@dest.test" {
+		t.Errorf("unexpected original rcpt to '%s'", got)
+	}
+
+	if report.Original == nil || report.Original.Get("Message-ID") != "<hello@sender.test>" {
+		t.Errorf("expected the original headers, got %v", report.Original)
+	}
+
+	return report
+}
+
+func TestParse(t *testing.T) {
+	if report := parse(t, testReport); report.FeedbackType != "abuse" {
+		t.Errorf("expected feedback type 'abuse', got '%s'", report.FeedbackType)
+	}
+}
+
+func TestParseNotSpam(t *testing.T) {
+	report := parse(t, replace("Feedback-Type: Abuse", "Feedback-Type: not-spam"))
+	if report.FeedbackType != "not-spam" {
+		t.Errorf("expected feedback type 'not-spam', got '%s'", report.FeedbackType)
+	}
+}
+
+func TestParseBase64Original(t *testing.T) {
+	parse(t, replace(
+		"Content-Type: message/rfc822\n\nFrom: Alice <alice@sender.test>\nMessage-ID: <hello@sender.test>\n\nHello\n",
+		"Content-Type: message/rfc822\nContent-Transfer-Encoding: base64\n\nRnJvbTogQWxpY2UgPGFsaWNlQHNlbmRlci50ZXN0PgpNZXNzYWdlLUlEOiA8aGVsbG9Ac2VuZGVyLnRlc3Q+CgpIZWxsbwo=\n",
+	))
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := map[string]string{
+		"not a report":     "Content-Type: text/plain\n\nhello\n",
+		"delivery status":  replace("feedback-report;", "delivery-status;"),
+		"no feedback type": replace("Feedback-Type: Abuse\n", ""),
+		"no report part":   replace("message/feedback-report", "text/plain"),
+	}
+
+	for name, message := range invalid {
+		if _, err := Parse(strings.NewReader(message)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/jawr/mxax/internal/account"
 	"github.com/jawr/mxax/internal/logger"
@@ -32,6 +35,11 @@ func (s *Site) getPostManageAlias() (*route, error) {
 		HID     string
 	}
 
+	type SenderRule struct {
+		account.SenderRule
+		HID string
+	}
+
 	// definte template data
 	type data struct {
 		Route string
@@ -43,22 +51,49 @@ func (s *Site) getPostManageAlias() (*route, error) {
 
 		Errors FormErrors
 
+		// state form
+		AliasStates []account.AliasState
+		ActiveFrom  string
+		ActiveUntil string
+		StateErrors FormErrors
+
+		// senders form
+		SenderRules     []SenderRule
+		SenderRuleTypes []account.SenderRuleType
+		SenderActions   []account.SenderAction
+		SenderErrors    FormErrors
+
+		// tagging form
+		TagErrors FormErrors
+
+		// correspondents
+		ReverseAliases []account.ReverseAlias
+
 		// stream
 		Entries []logger.Entry
 
 		// stats
-		Labels        []string
-		InboundSend   []int
-		InboundBounce []int
-		InboundReject []int
+		Labels           []string
+		InboundSend      []int
+		InboundBounce    []int
+		InboundReject    []int
+		InboundComplaint []int
 	}
 
 	// actual handler
 	r.h = func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
 
 		d := data{
-			Route:  "aliases",
-			Errors: newFormErrors(),
+			Route:       "aliases",
+			Errors:      newFormErrors(),
+			AliasStates: account.AliasStates,
+			StateErrors: newFormErrors(),
+
+			SenderRuleTypes: account.SenderRuleTypes,
+			SenderActions:   account.SenderActions,
+			SenderErrors:    newFormErrors(),
+
+			TagErrors: newFormErrors(),
 		}
 
 		ids := s.idHasher.Decode(ps.ByName("hash"))
@@ -80,7 +115,36 @@ func (s *Site) getPostManageAlias() (*route, error) {
 		}
 
 		// once we have the alias we can do some work with it
-		if req.Method == "POST" {
+		if req.Method == "POST" && req.FormValue("action") == "state" {
+			if err := s.postAliasState(req, tx, &d.Alias, d.StateErrors); err != nil {
+				return err
+			}
+
+		} else if req.Method == "POST" && req.FormValue("action") == "sender-rule" {
+			if err := s.postSenderRule(req, tx, d.Alias, d.SenderErrors); err != nil {
+				return err
+			}
+
+		} else if req.Method == "POST" && req.FormValue("action") == "tagging" {
+			if err := s.postAliasTagging(req, tx, &d.Alias, d.TagErrors); err != nil {
+				return err
+			}
+
+		} else if req.Method == "POST" && req.FormValue("action") == "scrub" {
+			scrub := req.FormValue("scrub") == "on"
+
+			if err := account.SetAliasScrub(req.Context(), tx, d.Alias.ID, scrub); err != nil {
+				return errors.WithMessage(err, "SetAliasScrub")
+			}
+
+			d.Alias.Scrub = scrub
+
+		} else if req.Method == "POST" && req.FormValue("action") == "sender-action" {
+			if err := s.postSenderAction(req, tx, &d.Alias); err != nil {
+				return err
+			}
+
+		} else if req.Method == "POST" {
 
 			destinationID, err := strconv.Atoi(req.FormValue("destination"))
 			if err != nil {
@@ -114,6 +178,35 @@ func (s *Site) getPostManageAlias() (*route, error) {
 			}
 		}
 
+		if d.Alias.ActiveFrom.Status == pgtype.Present {
+			d.ActiveFrom = d.Alias.ActiveFrom.Time.UTC().Format(activeWindowLayout)
+		}
+		if d.Alias.ActiveUntil.Status == pgtype.Present {
+			d.ActiveUntil = d.Alias.ActiveUntil.Time.UTC().Format(activeWindowLayout)
+		}
+
+		var rules []account.SenderRule
+		err = account.GetSenderRules(req.Context(), tx, &rules, d.Alias.ID)
+		if err != nil {
+			return errors.WithMessage(err, "GetSenderRules")
+		}
+
+		for _, rule := range rules {
+			hid, err := s.idHasher.Encode([]int{d.Alias.ID, rule.ID})
+			if err != nil {
+				return err
+			}
+			d.SenderRules = append(d.SenderRules, SenderRule{
+				SenderRule: rule,
+				HID:        hid,
+			})
+		}
+
+		err = account.GetReverseAliases(req.Context(), tx, &d.ReverseAliases, d.Alias.ID)
+		if err != nil {
+			return errors.WithMessage(err, "GetReverseAliases")
+		}
+
 		// get domain
 		err = account.GetDomainByID(
 			req.Context(),
@@ -351,6 +444,49 @@ func (s *Site) getPostManageAlias() (*route, error) {
 			return errors.Wrap(err, "Select EntryTypeReject")
 		}
 
+		err = pgxscan.Select(
+			req.Context(),
+			tx,
+			&d.InboundComplaint,
+			`
+			WITH series AS (
+				SELECT date_trunc(
+					'hour',
+					generate_series(
+						NOW() - INTERVAL '24 HOURS',
+						NOW(),
+						INTERVAL '1 HOUR'
+					)
+				) AS hour
+			), metrics AS (
+				SELECT
+					date_trunc('hour', l.time) AS hour,
+					COUNT(l.*) AS cnt
+				FROM logs AS l
+					JOIN domains AS d ON l.domain_id = d.id
+				WHERE
+					time > NOW() - INTERVAL '24 HOURS'
+					AND l.etype = $1
+					AND l.alias_id = $2
+				GROUP BY 1
+				ORDER BY 1
+			)
+			SELECT
+				COALESCE(SUM(metrics.cnt), 0)
+				
+			FROM series
+				LEFT JOIN metrics ON series.hour = metrics.hour
+
+			GROUP BY series.hour
+			ORDER BY series.hour
+			`,
+			logger.EntryTypeComplaint,
+			aliasID,
+		)
+		if err != nil {
+			return errors.Wrap(err, "Select EntryTypeComplaint")
+		}
+
 		s.renderTemplate(w, tmpl, r, d)
 
 		return nil
@@ -359,6 +495,78 @@ func (s *Site) getPostManageAlias() (*route, error) {
 	return r, nil
 }
 
+// layout of datetime-local inputs, times are in UTC
+const activeWindowLayout = "2006-01-02T15:04"
+
+// postAliasState updates the state and active window of alias
+// from the alias page's state form, adding to errs on bad input
+func (s *Site) postAliasState(req *http.Request, tx pgx.Tx, alias *account.Alias, errs FormErrors) error {
+	state, err := account.ParseAliasState(req.FormValue("state"))
+	if err != nil {
+		// hard fail as smells of malicious intent
+		return errors.WithMessage(err, "ParseAliasState")
+	}
+
+	// the message is kept for the next time reject is picked
+	rejectMessage := strings.TrimSpace(req.FormValue("reject-message"))
+	if state == account.AliasStateReject || len(rejectMessage) > 0 {
+		rejectMessage, err = account.NormaliseRejectMessage(rejectMessage)
+		if err != nil {
+			errs.Add("reject-message", err.Error())
+		}
+	}
+
+	window := func(name string) time.Time {
+		value := req.FormValue(name)
+		if len(value) == 0 {
+			return time.Time{}
+		}
+		t, err := time.ParseInLocation(activeWindowLayout, value, time.UTC)
+		if err != nil {
+			errs.Add(name, "Bad date and time: "+value)
+		}
+		return t
+	}
+
+	from := window("active-from")
+	until := window("active-until")
+
+	if errs.Error() {
+		return nil
+	}
+
+	err = account.UpdateAliasState(req.Context(), tx, alias.ID, state, rejectMessage, from, until)
+	if err != nil {
+		log.Printf("Error updating alias state %d: %s", alias.ID, err)
+		errs.Add("", err.Error())
+		return nil
+	}
+
+	// reload so the page shows what was stored
+	return account.GetAlias(req.Context(), tx, alias, alias.ID)
+}
+
+// postAliasTagging sets how forwarded mail of alias is tagged,
+// adding to errs on bad input
+func (s *Site) postAliasTagging(req *http.Request, tx pgx.Tx, alias *account.Alias, errs FormErrors) error {
+	subjectTag, err := account.NormaliseSubjectTag(req.FormValue("subject-tag"))
+	if err != nil {
+		errs.Add("subject-tag", err.Error())
+		return nil
+	}
+
+	tagHeaders := req.FormValue("tag-headers") == "on"
+
+	if err := account.SetAliasTagging(req.Context(), tx, alias.ID, subjectTag, tagHeaders); err != nil {
+		return errors.WithMessage(err, "SetAliasTagging")
+	}
+
+	alias.SubjectTag = subjectTag
+	alias.TagHeaders = tagHeaders
+
+	return nil
+}
+
 func (s *Site) getDeleteAliasDestination() (*route, error) {
 	r := &route{
 		path:    "/alias/destination/delete/:hash",
@@ -498,3 +706,58 @@ func (s *Site) getDeleteAlias() (*route, error) {
 
 	return r, nil
 }
+
+// reorder a domain's aliases, expects the hashed alias ids
+// in their new order
+func (s *Site) postOrderAliases() (*route, error) {
+	r := &route{
+		path:    "/domain/aliases/order/:domain",
+		methods: []string{"POST"},
+	}
+
+	// actual handler
+	r.h = func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		var domain account.Domain
+
+		// get domain
+		err := account.GetDomain(
+			req.Context(),
+			tx,
+			&domain,
+			ps.ByName("domain"),
+		)
+		if err != nil {
+			return errors.WithMessage(err, "GetDomain")
+		}
+
+		if err := req.ParseForm(); err != nil {
+			return errors.WithMessage(err, "ParseForm")
+		}
+
+		aliasIDs := make([]int, 0, len(req.PostForm["alias"]))
+		for _, hid := range req.PostForm["alias"] {
+			ids := s.idHasher.Decode(hid)
+			if len(ids) != 1 {
+				return errors.New("No id found")
+			}
+			aliasIDs = append(aliasIDs, ids[0])
+		}
+
+		err = account.ReorderAliases(
+			req.Context(),
+			tx,
+			domain.ID,
+			aliasIDs,
+		)
+		if err != nil {
+			return errors.WithMessage(err, "ReorderAliases")
+		}
+
+		http.Redirect(w, req, "/domain/manage/"+domain.Name, http.StatusFound)
+
+		return nil
+	}
+
+	return r, nil
+}
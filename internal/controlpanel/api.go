@@ -0,0 +1,236 @@
+package controlpanel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/jawr/mxax/internal/smtp"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// largest request accepted by the send api
+const maxAPISendBytes = 10 << 20
+
+// most recipients of a single send api request
+const maxAPISendRecipients = 50
+
+type apiSendRequest struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+
+	// the raw RFC 5322 message
+	Message string `json:"message"`
+
+	// RFC 4865 HOLDFOR=seconds or HOLDUNTIL=time, takes
+	// precedence over a Future-Release header
+	FutureRelease string `json:"future_release"`
+}
+
+// badSendError is returned for requests that are at fault
+type badSendError string
+
+func (e badSendError) Error() string { return string(e) }
+
+type apiSendResponse struct {
+	IDs       []uuid.UUID `json:"ids,omitempty"`
+	ReleaseAt time.Time   `json:"release_at,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// postAPISend accepts messages over http from accounts using
+// their smtp credentials. Messages are held like submission
+// mail, and signed and queued by the smtp server at release
+func (s *Site) postAPISend() (httprouter.Handle, error) {
+	r := &route{
+		path:    "/api/send",
+		methods: []string{"POST"},
+	}
+
+	reply := func(w http.ResponseWriter, status int, resp apiSendResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(&resp); err != nil {
+			log.Printf("%v %s ERROR: Encode: %s", r.methods, r.path, err)
+		}
+	}
+
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		email, password, ok := req.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="mx.ax"`)
+			reply(w, http.StatusUnauthorized, apiSendResponse{Error: "smtp credentials required"})
+			return
+		}
+
+		accountID, err := s.apiLogin(req.Context(), email, password)
+		if err != nil {
+			log.Printf("%v %s: apiLogin '%s': %s", r.methods, r.path, email, err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="mx.ax"`)
+			reply(w, http.StatusUnauthorized, apiSendResponse{Error: "not authorized"})
+			return
+		}
+
+		var send apiSendRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxAPISendBytes)).Decode(&send); err != nil {
+			reply(w, http.StatusBadRequest, apiSendResponse{Error: "bad request body"})
+			return
+		}
+
+		tx, err := s.db.Begin(req.Context())
+		if err != nil {
+			s.handleErrorPlain(w, r, err)
+			return
+		}
+		defer tx.Rollback(req.Context())
+
+		setCurrentAccountID := fmt.Sprintf("SET mxax.current_account_id TO %d", accountID)
+
+		if _, err := tx.Exec(req.Context(), setCurrentAccountID); err != nil {
+			s.handleErrorPlain(w, r, err)
+			return
+		}
+
+		resp, err := s.holdAPISend(req.Context(), tx, accountID, send, time.Now())
+		if err != nil {
+			if bad, ok := err.(badSendError); ok {
+				reply(w, http.StatusBadRequest, apiSendResponse{Error: string(bad)})
+				return
+			}
+			s.handleErrorPlain(w, r, err)
+			return
+		}
+
+		if err := tx.Commit(req.Context()); err != nil {
+			s.handleErrorPlain(w, r, err)
+			return
+		}
+
+		reply(w, http.StatusAccepted, resp)
+	}, nil
+}
+
+// apiLogin checks the smtp credentials of an account, returning
+// its id
+func (s *Site) apiLogin(ctx context.Context, email, password string) (int, error) {
+	var accountID int
+	var hash []byte
+	err := s.adminDB.QueryRow(
+		ctx,
+		"SELECT id, smtp_password FROM accounts WHERE email = $1 AND verified_at IS NOT NULL",
+		email,
+	).Scan(&accountID, &hash)
+	if err != nil {
+		return 0, errors.WithMessage(err, "SELECT")
+	}
+
+	if len(hash) == 0 {
+		return 0, errors.New("no smtp password set")
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return 0, err
+	}
+
+	return accountID, nil
+}
+
+// holdAPISend validates send and holds a copy for each recipient
+// until its release time, now if it has none. Problems with send
+// are returned as a badSendError
+func (s *Site) holdAPISend(ctx context.Context, tx pgx.Tx, accountID int, send apiSendRequest, now time.Time) (apiSendResponse, error) {
+	var resp apiSendResponse
+
+	if len(send.To) == 0 || len(send.To) > maxAPISendRecipients {
+		return resp, badSendError(fmt.Sprintf("between 1 and %d recipients are needed", maxAPISendRecipients))
+	}
+
+	for _, to := range send.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return resp, badSendError(fmt.Sprintf("bad recipient '%s'", to))
+		}
+	}
+
+	from, err := mail.ParseAddress(send.From)
+	if err != nil {
+		return resp, badSendError(fmt.Sprintf("bad from '%s'", send.From))
+	}
+
+	// the domain must be one of ours to sign for
+	var domain account.Domain
+	name := strings.ToLower(from.Address[strings.LastIndex(from.Address, "@")+1:])
+	if err := account.GetDomain(ctx, tx, &domain, name); err != nil || domain.VerifiedAt.Status != pgtype.Present {
+		return resp, badSendError(fmt.Sprintf("'%s' is not a verified domain", name))
+	}
+
+	release, message, err := smtp.FutureRelease([]byte(send.Message), now)
+	if err != nil {
+		return resp, badSendError(err.Error())
+	}
+
+	if len(send.FutureRelease) > 0 {
+		release, err = smtp.ParseFutureRelease(send.FutureRelease, now)
+		if err != nil {
+			return resp, badSendError(err.Error())
+		}
+	}
+
+	if release.Before(now) {
+		release = now
+	}
+
+	headers, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return resp, badSendError("message is not RFC 5322")
+	}
+
+	resp.ReleaseAt = release
+
+	for _, to := range send.To {
+		email := smtp.Email{
+			ID:        uuid.New(),
+			From:      from.Address,
+			To:        to,
+			Message:   message,
+			AccountID: accountID,
+			DomainID:  domain.ID,
+			Priority:  smtp.PrioritySubmission,
+			NotBefore: release,
+		}
+
+		b, err := json.Marshal(&email)
+		if err != nil {
+			return resp, errors.WithMessage(err, "Marshal")
+		}
+
+		held := account.HeldEmail{
+			EmailID:   email.ID,
+			AccountID: accountID,
+			DomainID:  domain.ID,
+			FromEmail: email.From,
+			ToEmail:   to,
+			Subject:   headers.Header.Get("Subject"),
+			ReleaseAt: release,
+		}
+
+		if err := account.CreateHeldEmail(ctx, tx, held, b); err != nil {
+			return resp, errors.WithMessage(err, "CreateHeldEmail")
+		}
+
+		resp.IDs = append(resp.IDs, email.ID)
+	}
+
+	return resp, nil
+}
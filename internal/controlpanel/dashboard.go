@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/jawr/mxax/internal/account"
 	"github.com/jawr/mxax/internal/logger"
@@ -31,18 +32,30 @@ func (s *Site) getDashboard() (*route, error) {
 	// custom defines
 	type Domain struct {
 		account.Domain
-		Aliases  int
-		CatchAll int
-		Records  int
-		Status   string
-		Expiring bool
-		Expired  bool
+		Aliases     int
+		CatchAll    int
+		AutoCreated int
+		Records     int
+		Status      string
+		Expiring    bool
+		Expired     bool
 	}
 
 	type Destination struct {
 		account.Destination
 		Aliases int
 		HID     string
+
+		// set when the address is suppressed
+		SuppressionID    pgtype.Int4
+		SuppressedReason pgtype.Text
+		SuppressionHID   string
+	}
+
+	type AutoCreated struct {
+		account.Alias
+		Domain string
+		HID    string
 	}
 
 	// definte template data
@@ -57,14 +70,18 @@ func (s *Site) getDashboard() (*route, error) {
 		Destinations          []Destination
 		DestinationFormErrors FormErrors
 
+		// latest aliases created by the relay
+		AutoCreated []AutoCreated
+
 		// stream
 		Entries []logger.Entry
 
 		// stats
-		Labels        []string
-		InboundSend   []int
-		InboundBounce []int
-		InboundReject []int
+		Labels           []string
+		InboundSend      []int
+		InboundBounce    []int
+		InboundReject    []int
+		InboundComplaint []int
 	}
 
 	// actual handler
@@ -169,7 +186,8 @@ func (s *Site) getDashboard() (*route, error) {
 					AND r.deleted_at IS NULL
 					OR r.last_verified_at > NOW() - INTERVAL '24 hours'
 				)) as records,
-				COALESCE(COUNT(DISTINCT a.id) FILTER (WHERE rule = '.*')) as catch_all
+				COALESCE(COUNT(DISTINCT a.id) FILTER (WHERE a.catch_all AND a.deleted_at IS NULL)) as catch_all,
+				COALESCE(COUNT(DISTINCT a.id) FILTER (WHERE a.auto_created AND a.deleted_at IS NULL)) as auto_created
 			FROM domains AS d 
 				LEFT JOIN aliases AS a ON d.id = a.domain_id 
 				LEFT JOIN records AS r ON d.id = r.domain_id
@@ -201,6 +219,34 @@ func (s *Site) getDashboard() (*route, error) {
 			}
 		}
 
+		// get auto created aliases
+		err = pgxscan.Select(
+			req.Context(),
+			tx,
+			&d.AutoCreated,
+			`
+			SELECT a.*, d.name AS domain
+			FROM aliases AS a
+				JOIN domains AS d ON a.domain_id = d.id
+			WHERE
+				a.auto_created
+				AND a.deleted_at IS NULL
+				AND d.deleted_at IS NULL
+			ORDER BY a.created_at DESC
+			LIMIT 10
+			`,
+		)
+		if err != nil {
+			return errors.WithMessage(err, "Select AutoCreated")
+		}
+
+		for idx := range d.AutoCreated {
+			d.AutoCreated[idx].HID, err = s.idHasher.Encode([]int{d.AutoCreated[idx].ID})
+			if err != nil {
+				return err
+			}
+		}
+
 		// get forward entries
 		err = pgxscan.Select(
 			req.Context(),
@@ -229,11 +275,14 @@ func (s *Site) getDashboard() (*route, error) {
 				d.*, 
 				COALESCE(COUNT(ad.*) FILTER (
 					WHERE ad.deleted_at IS NULL
-				), 0) AS aliases
+				), 0) AS aliases,
+				s.id AS suppression_id,
+				s.reason AS suppressed_reason
 			FROM destinations AS d
 				LEFT JOIN alias_destinations AS ad ON d.id = ad.destination_id
+				LEFT JOIN suppressions AS s ON s.address = d.address AND s.suppressed_at IS NOT NULL
 			WHERE d.deleted_at IS NULL
-			GROUP BY d.id
+			GROUP BY d.id, s.id
 			`,
 		)
 		if err != nil {
@@ -247,6 +296,15 @@ func (s *Site) getDashboard() (*route, error) {
 			if err != nil {
 				return err
 			}
+
+			if d.Destinations[idx].SuppressionID.Status == pgtype.Present {
+				d.Destinations[idx].SuppressionHID, err = s.idHasher.Encode([]int{
+					int(d.Destinations[idx].SuppressionID.Int),
+				})
+				if err != nil {
+					return err
+				}
+			}
 		}
 
 		// handle stats
@@ -390,6 +448,47 @@ func (s *Site) getDashboard() (*route, error) {
 			return errors.Wrap(err, "Select EntryTypeReject")
 		}
 
+		err = pgxscan.Select(
+			req.Context(),
+			tx,
+			&d.InboundComplaint,
+			`
+			WITH series AS (
+				SELECT date_trunc(
+					'hour',
+					generate_series(
+						NOW() - INTERVAL '24 HOURS',
+						NOW(),
+						INTERVAL '1 HOUR'
+					)
+				) AS hour
+			), metrics AS (
+				SELECT
+					date_trunc('hour', l.time) AS hour,
+					COUNT(l.*) AS cnt
+				FROM logs AS l
+					JOIN domains AS d ON l.domain_id = d.id
+				WHERE
+					time > NOW() - INTERVAL '24 HOURS'
+					AND l.etype = $1
+				GROUP BY 1
+				ORDER BY 1
+			)
+			SELECT
+				COALESCE(SUM(metrics.cnt), 0)
+				
+			FROM series
+				LEFT JOIN metrics ON series.hour = metrics.hour
+
+			GROUP BY series.hour
+			ORDER BY series.hour
+			`,
+			logger.EntryTypeComplaint,
+		)
+		if err != nil {
+			return errors.Wrap(err, "Select EntryTypeComplaint")
+		}
+
 		s.renderTemplate(w, tmpl, r, d)
 		return nil
 	}
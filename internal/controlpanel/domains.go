@@ -3,8 +3,8 @@ package controlpanel
 import (
 	"log"
 	"net/http"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/georgysavva/scany/pgxscan"
@@ -51,15 +51,21 @@ func (s *Site) getDomain() (*route, error) {
 		Aliases         []Alias
 		AliasFormErrors FormErrors
 		Destinations    []account.Destination
+		RuleTypes       []account.RuleType
+
+		// auto create
+		DefaultDestinations  map[int]bool
+		AutoCreateFormErrors FormErrors
 
 		// stream
 		Entries []logger.Entry
 
 		// stats
-		Labels        []string
-		InboundSend   []int
-		InboundBounce []int
-		InboundReject []int
+		Labels           []string
+		InboundSend      []int
+		InboundBounce    []int
+		InboundReject    []int
+		InboundComplaint []int
 	}
 
 	// go net.LookupCNAME follows the Canonical chain
@@ -75,6 +81,10 @@ func (s *Site) getDomain() (*route, error) {
 			Route:           "domains",
 			Errors:          newFormErrors(),
 			AliasFormErrors: newFormErrors(),
+			RuleTypes:       account.RuleTypes,
+
+			DefaultDestinations:  make(map[int]bool),
+			AutoCreateFormErrors: newFormErrors(),
 		}
 
 		err := account.GetDomain(
@@ -146,7 +156,40 @@ func (s *Site) getDomain() (*route, error) {
 
 		// if complete get the aliases
 		if d.IsComplete {
-			if req.Method == "POST" {
+			if req.Method == "POST" && req.FormValue("action") == "auto-create" {
+				if err := req.ParseForm(); err != nil {
+					return errors.WithMessage(err, "ParseForm")
+				}
+
+				destinationIDs := make([]int, 0, len(req.PostForm["default-destination"]))
+				for _, value := range req.PostForm["default-destination"] {
+					destinationID, err := strconv.Atoi(value)
+					if err != nil {
+						// hard fail as smells of malicious intent
+						return errors.WithMessage(err, "Atoi default-destination")
+					}
+					destinationIDs = append(destinationIDs, destinationID)
+				}
+
+				rule := strings.TrimSpace(req.FormValue("auto-create-rule"))
+
+				err := account.SetAutoCreate(req.Context(), tx, d.Domain.ID, rule, destinationIDs)
+				if err != nil {
+					d.AutoCreateFormErrors.Add("auto-create-rule", err.Error())
+				} else if err := account.GetDomainByID(req.Context(), tx, &d.Domain.Domain, d.Domain.ID); err != nil {
+					return errors.WithMessage(err, "GetDomainByID")
+				}
+
+			} else if req.Method == "POST" && req.FormValue("action") == "reverse-aliases" {
+				enabled := req.FormValue("reverse-aliases") == "on"
+
+				if err := account.SetReverseAliases(req.Context(), tx, d.Domain.ID, enabled); err != nil {
+					return errors.WithMessage(err, "SetReverseAliases")
+				}
+
+				d.Domain.ReverseAliases = enabled
+
+			} else if req.Method == "POST" {
 
 				allowed, err := s.aclAliasCreateCheck(req.Context(), tx)
 				if err != nil {
@@ -164,13 +207,30 @@ func (s *Site) getDomain() (*route, error) {
 					return errors.WithMessage(err, "Atoi destinationID")
 				}
 
-				if len(rule) == 0 {
+				catchAll := req.FormValue("catch-all") == "on"
+
+				if len(rule) == 0 && !catchAll {
 					d.AliasFormErrors.Add("rule", "Must enter a Rule")
 				}
 
-				_, err = regexp.Compile(rule)
+				ruleType, err := account.ParseRuleType(req.FormValue("rule-type"))
 				if err != nil {
-					d.AliasFormErrors.Add("rule", err.Error())
+					// hard fail as smells of malicious intent
+					return errors.WithMessage(err, "ParseRuleType")
+				}
+
+				burner, err := parseBurner(req)
+				if err != nil {
+					d.AliasFormErrors.Add("burner", err.Error())
+				} else if err := burner.Validate(time.Now()); err != nil {
+					d.AliasFormErrors.Add("burner", err.Error())
+				}
+
+				if !catchAll && len(rule) > 0 {
+					rule, err = account.NormaliseRule(ruleType, rule)
+					if err != nil {
+						d.AliasFormErrors.Add("rule", err.Error())
+					}
 				}
 
 				if !d.AliasFormErrors.Error() {
@@ -178,7 +238,10 @@ func (s *Site) getDomain() (*route, error) {
 					err = account.CreateAlias(
 						req.Context(),
 						tx,
+						ruleType,
 						rule,
+						catchAll,
+						burner,
 						d.Domain.ID,
 						destinationID,
 					)
@@ -212,7 +275,7 @@ func (s *Site) getDomain() (*route, error) {
 					AND d.deleted_at IS NULL
 					AND dom.id = $1
 				GROUP BY a.id, dom.name
-				ORDER BY dom.name, a.rule
+				ORDER BY a.catch_all, a.priority, a.id
 			`,
 				d.Domain.ID,
 			)
@@ -238,6 +301,16 @@ func (s *Site) getDomain() (*route, error) {
 				return errors.WithMessage(err, "GetDestinations")
 			}
 
+			var defaults []account.Destination
+			err = account.GetDefaultDestinations(req.Context(), tx, &defaults, d.Domain.ID)
+			if err != nil {
+				return errors.WithMessage(err, "GetDefaultDestinations")
+			}
+
+			for _, destination := range defaults {
+				d.DefaultDestinations[destination.ID] = true
+			}
+
 			// get forward entries
 			err = pgxscan.Select(
 				req.Context(),
@@ -405,6 +478,49 @@ func (s *Site) getDomain() (*route, error) {
 				return errors.Wrap(err, "Select EntryTypeReject")
 			}
 
+			err = pgxscan.Select(
+				req.Context(),
+				tx,
+				&d.InboundComplaint,
+				`
+			WITH series AS (
+				SELECT date_trunc(
+					'hour',
+					generate_series(
+						NOW() - INTERVAL '24 HOURS',
+						NOW(),
+						INTERVAL '1 HOUR'
+					)
+				) AS hour
+			), metrics AS (
+				SELECT
+					date_trunc('hour', l.time) AS hour,
+					COUNT(l.*) AS cnt
+				FROM logs AS l
+					JOIN domains AS d ON l.domain_id = d.id
+				WHERE
+					time > NOW() - INTERVAL '24 HOURS'
+					AND l.etype = $1
+					AND l.domain_id = $2
+				GROUP BY 1
+				ORDER BY 1
+			)
+			SELECT
+				COALESCE(SUM(metrics.cnt), 0)
+				
+			FROM series
+				LEFT JOIN metrics ON series.hour = metrics.hour
+
+			GROUP BY series.hour
+			ORDER BY series.hour
+			`,
+				logger.EntryTypeComplaint,
+				d.Domain.ID,
+			)
+			if err != nil {
+				return errors.Wrap(err, "Select EntryTypeComplaint")
+			}
+
 		}
 
 		s.renderTemplate(w, tmpl, r, d)
@@ -452,3 +568,34 @@ func (s *Site) getDeleteDomain() (*route, error) {
 
 	return r, nil
 }
+
+// parseBurner reads the burner fields of the add alias form
+func parseBurner(req *http.Request) (account.Burner, error) {
+	var burner account.Burner
+
+	if value := req.FormValue("expires-at"); len(value) > 0 {
+		t, err := time.ParseInLocation(activeWindowLayout, value, time.UTC)
+		if err != nil {
+			return burner, errors.Errorf("Bad expiry: %s", value)
+		}
+		burner.ExpiresAt = t
+	}
+
+	if value := req.FormValue("max-uses"); len(value) > 0 {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return burner, errors.Errorf("Bad max messages: %s", value)
+		}
+		burner.MaxUses = n
+	}
+
+	if value := req.FormValue("fallback"); len(value) > 0 {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return burner, errors.Errorf("Bad fallback: %s", value)
+		}
+		burner.FallbackDestinationID = id
+	}
+
+	return burner, nil
+}
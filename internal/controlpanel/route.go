@@ -28,6 +28,13 @@ func (s *Site) setupRoutes() error {
 	s.router.GET("/login", getPostLogin)
 	s.router.POST("/login", getPostLogin)
 
+	postAPISend, err := s.postAPISend()
+	if err != nil {
+		return errors.WithMessage(err, "postAPISend")
+	}
+
+	s.router.POST("/api/send", postAPISend)
+
 	// make these all accountID/auth handlers by default and apply the auth
 	// middleware here
 	routes := []routeFn{
@@ -41,6 +48,12 @@ func (s *Site) setupRoutes() error {
 		s.getPostSecurity,
 		s.getPostManageAlias,
 		s.getDeleteAliasDestination,
+		s.getDeleteSenderRule,
+		s.postOrderAliases,
+		s.getPostAliasTester,
+		s.getDeleteSuppression,
+		s.getScheduled,
+		s.getCancelScheduled,
 		// logout
 		s.getLogout,
 	}
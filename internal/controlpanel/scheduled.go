@@ -0,0 +1,98 @@
+package controlpanel
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+func (s *Site) getScheduled() (*route, error) {
+	r := &route{
+		path:    "/scheduled",
+		methods: []string{"GET"},
+	}
+
+	// setup template
+	tmpl, err := s.loadTemplate("templates/controlpanel/scheduled.html")
+	if err != nil {
+		return r, err
+	}
+
+	// custom defines
+	type HeldEmail struct {
+		account.HeldEmail
+		HID string
+	}
+
+	// definte template data
+	type data struct {
+		Route string
+		Held  []HeldEmail
+	}
+
+	// actual handler
+	r.h = func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		d := data{
+			Route: "scheduled",
+		}
+
+		var held []account.HeldEmail
+		if err := account.GetHeldEmails(req.Context(), tx, &held); err != nil {
+			return errors.WithMessage(err, "GetHeldEmails")
+		}
+
+		for idx := range held {
+			hid, err := s.idHasher.Encode([]int{held[idx].ID})
+			if err != nil {
+				return err
+			}
+
+			d.Held = append(d.Held, HeldEmail{
+				HeldEmail: held[idx],
+				HID:       hid,
+			})
+		}
+
+		s.renderTemplate(w, tmpl, r, d)
+		return nil
+	}
+
+	return r, nil
+}
+
+func (s *Site) getCancelScheduled() (*route, error) {
+	r := &route{
+		path:    "/scheduled/cancel/:hash",
+		methods: []string{"GET"},
+	}
+
+	// actual handler
+	r.h = s.confirmAction(func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		ids := s.idHasher.Decode(ps.ByName("hash"))
+		if len(ids) != 1 {
+			return errors.New("No id found")
+		}
+
+		// validate that the held email belongs to this account
+		var held account.HeldEmail
+		err := account.GetHeldEmailByID(req.Context(), tx, &held, ids[0])
+		if err != nil {
+			return errors.WithMessage(err, "GetHeldEmailByID")
+		}
+
+		if err := account.CancelHeldEmail(req.Context(), tx, held.ID); err != nil {
+			return errors.WithMessage(err, "CancelHeldEmail")
+		}
+
+		http.Redirect(w, req, "/scheduled", http.StatusFound)
+
+		return nil
+	})
+
+	return r, nil
+}
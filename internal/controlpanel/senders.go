@@ -0,0 +1,98 @@
+package controlpanel
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// postSenderRule adds a sender rule to alias from the alias
+// page's senders form, adding to errs on bad input
+func (s *Site) postSenderRule(req *http.Request, tx pgx.Tx, alias account.Alias, errs FormErrors) error {
+	ruleType, err := account.ParseSenderRuleType(req.FormValue("pattern-type"))
+	if err != nil {
+		// hard fail as smells of malicious intent
+		return errors.WithMessage(err, "ParseSenderRuleType")
+	}
+
+	var allow bool
+	switch req.FormValue("list") {
+	case "allow":
+		allow = true
+	case "block":
+	default:
+		return errors.Errorf("bad list: '%s'", req.FormValue("list"))
+	}
+
+	pattern := req.FormValue("pattern")
+	if _, err := account.NormaliseSenderPattern(ruleType, pattern); err != nil {
+		errs.Add("pattern", err.Error())
+		return nil
+	}
+
+	return account.CreateSenderRule(req.Context(), tx, alias.ID, ruleType, pattern, allow)
+}
+
+// postSenderAction sets what alias does with refused senders
+func (s *Site) postSenderAction(req *http.Request, tx pgx.Tx, alias *account.Alias) error {
+	action, err := account.ParseSenderAction(req.FormValue("sender-action"))
+	if err != nil {
+		// hard fail as smells of malicious intent
+		return errors.WithMessage(err, "ParseSenderAction")
+	}
+
+	if err := account.SetSenderAction(req.Context(), tx, alias.ID, action); err != nil {
+		return errors.WithMessage(err, "SetSenderAction")
+	}
+
+	alias.SenderAction = action
+
+	return nil
+}
+
+func (s *Site) getDeleteSenderRule() (*route, error) {
+	r := &route{
+		path:    "/alias/sender/delete/:hash",
+		methods: []string{"GET"},
+	}
+
+	// actual handler
+	r.h = s.confirmAction(func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		ids := s.idHasher.Decode(ps.ByName("hash"))
+		if len(ids) != 2 {
+			return errors.New("No id found")
+		}
+
+		aliasID := ids[0]
+		ruleID := ids[1]
+
+		_, err := tx.Exec(
+			req.Context(),
+			`
+			UPDATE sender_rules 
+			SET deleted_at = NOW()
+			WHERE id = $1 AND alias_id = $2
+			`,
+			ruleID,
+			aliasID,
+		)
+		if err != nil {
+			return errors.WithMessage(err, "Delete")
+		}
+
+		aliasHID, err := s.idHasher.Encode([]int{aliasID})
+		if err != nil {
+			return err
+		}
+
+		http.Redirect(w, req, "/alias/manage/"+aliasHID, http.StatusFound)
+
+		return nil
+	})
+
+	return r, nil
+}
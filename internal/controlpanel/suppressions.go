@@ -0,0 +1,43 @@
+package controlpanel
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+func (s *Site) getDeleteSuppression() (*route, error) {
+	r := &route{
+		path:    "/suppressions/delete/:hash",
+		methods: []string{"GET"},
+	}
+
+	// actual handler
+	r.h = s.confirmAction(func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		ids := s.idHasher.Decode(ps.ByName("hash"))
+		if len(ids) != 1 {
+			return errors.New("No id found")
+		}
+
+		// validate that the suppression belongs to this account
+		var suppression account.Suppression
+		err := account.GetSuppressionByID(req.Context(), tx, &suppression, ids[0])
+		if err != nil {
+			return errors.WithMessage(err, "GetSuppressionByID")
+		}
+
+		if err := account.DeleteSuppression(req.Context(), tx, suppression.ID); err != nil {
+			return errors.WithMessage(err, "DeleteSuppression")
+		}
+
+		http.Redirect(w, req, "/", http.StatusFound)
+
+		return nil
+	})
+
+	return r, nil
+}
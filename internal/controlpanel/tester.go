@@ -0,0 +1,222 @@
+package controlpanel
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// test which alias an address would be forwarded by, aliases are
+// loaded and matched the same way the relay does
+func (s *Site) getPostAliasTester() (*route, error) {
+	r := &route{
+		path:    "/aliases/tester",
+		methods: []string{"GET", "POST"},
+	}
+
+	// setup template
+	tmpl, err := s.loadTemplate("templates/controlpanel/tester.html")
+	if err != nil {
+		return r, err
+	}
+
+	// custom defines
+	type Result struct {
+		account.AliasMatch
+		HID string
+
+		// matched but a higher priority alias won
+		Lost bool
+	}
+
+	type Destination struct {
+		account.Destination
+		SuppressedReason string
+	}
+
+	// definte template data
+	type data struct {
+		Route string
+
+		Domains []account.Domain
+
+		// form
+		Address string
+		Domain  string
+		Errors  FormErrors
+
+		// results
+		Tested       bool
+		User         string
+		Match        *Result
+		Results      []Result
+		Destinations []Destination
+
+		// the match is not accepting mail
+		Rejected      bool
+		RejectMessage string
+		// the match is an expired burner
+		Expired bool
+		// nothing matched but an alias would be created
+		AutoCreate bool
+	}
+
+	// actual handler
+	r.h = func(tx pgx.Tx, w http.ResponseWriter, req *http.Request, ps httprouter.Params) error {
+
+		d := data{
+			Route:  "tester",
+			Errors: newFormErrors(),
+		}
+
+		if err := account.GetVerifiedDomains(req.Context(), tx, &d.Domains); err != nil {
+			return errors.WithMessage(err, "GetVerifiedDomains")
+		}
+
+		if req.Method != "POST" {
+			s.renderTemplate(w, tmpl, r, d)
+			return nil
+		}
+
+		d.Address = strings.ToLower(strings.TrimSpace(req.FormValue("address")))
+		d.Domain = req.FormValue("domain")
+
+		// a full address overrides the selected domain
+		d.User = d.Address
+		if parts := strings.Split(d.Address, "@"); len(parts) == 2 {
+			d.User, d.Domain = parts[0], parts[1]
+		}
+
+		if len(d.User) == 0 {
+			d.Errors.Add("address", "Must enter a local part or an address")
+		}
+
+		if len(d.Domain) == 0 {
+			d.Errors.Add("domain", "Must select a Domain")
+		}
+
+		if d.Errors.Error() {
+			s.renderTemplate(w, tmpl, r, d)
+			return nil
+		}
+
+		var aliases []account.Alias
+		err := account.GetDomainAliases(req.Context(), tx, &aliases, d.Domain)
+		if err != nil {
+			return errors.WithMessage(err, "GetDomainAliases")
+		}
+
+		if len(aliases) == 0 {
+			d.Errors.Add("domain", "Domain is not verified or has no aliases")
+			s.renderTemplate(w, tmpl, r, d)
+			return nil
+		}
+
+		d.Tested = true
+
+		for _, match := range account.ExplainMatch(aliases, d.User) {
+			hid, err := s.idHasher.Encode([]int{match.Alias.ID})
+			if err != nil {
+				return err
+			}
+
+			d.Results = append(d.Results, Result{
+				AliasMatch: match,
+				HID:        hid,
+			})
+		}
+
+		for idx := range d.Results {
+			if !d.Results[idx].Matched {
+				continue
+			}
+
+			if d.Match == nil {
+				d.Match = &d.Results[idx]
+			} else {
+				d.Results[idx].Lost = true
+			}
+		}
+
+		if d.Match == nil {
+			for _, domain := range d.Domains {
+				if domain.Name == d.Domain {
+					d.AutoCreate = domain.AutoCreates(d.User)
+				}
+			}
+		}
+
+		if d.Match != nil {
+			now := time.Now()
+			ok, message := d.Match.Alias.Accepts(now)
+			d.Rejected = !ok
+			d.RejectMessage = message
+			d.Expired = d.Match.Alias.Expired(now)
+
+			if d.Expired && !d.Match.Alias.HasFallback() {
+				d.Rejected = true
+			}
+		}
+
+		// the first match is the alias the relay would pick
+		if d.Match != nil && !d.Rejected {
+			var destinations []account.Destination
+			if d.Expired {
+				var fallback account.Destination
+				err := account.GetDestinationByID(req.Context(), tx, &fallback, int(d.Match.Alias.FallbackDestinationID.Int))
+				if err != nil {
+					return errors.WithMessage(err, "GetDestinationByID fallback")
+				}
+				destinations = append(destinations, fallback)
+
+			} else {
+				err := account.GetAliasDestinations(req.Context(), tx, &destinations, d.Match.Alias.ID)
+				if err != nil {
+					return errors.WithMessage(err, "GetAliasDestinations")
+				}
+			}
+
+			// destinations that are suppressed are skipped by the relay
+			var suppressed []struct {
+				Address string
+				Reason  string
+			}
+			err = pgxscan.Select(
+				req.Context(),
+				tx,
+				&suppressed,
+				`
+				SELECT address, reason
+				FROM suppressions
+				WHERE suppressed_at IS NOT NULL
+				`,
+			)
+			if err != nil {
+				return errors.WithMessage(err, "Select suppressions")
+			}
+
+			reasons := make(map[string]string, len(suppressed))
+			for _, s := range suppressed {
+				reasons[s.Address] = s.Reason
+			}
+
+			for _, destination := range destinations {
+				d.Destinations = append(d.Destinations, Destination{
+					Destination:      destination,
+					SuppressedReason: reasons[strings.ToLower(destination.Address)],
+				})
+			}
+		}
+
+		s.renderTemplate(w, tmpl, r, d)
+		return nil
+	}
+
+	return r, nil
+}
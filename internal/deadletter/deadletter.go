@@ -0,0 +1,194 @@
+package deadletter
+
+import (
+	"time"
+
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/pkg/errors"
+)
+
+// number of times a message is retried before being dead lettered
+const DefaultMaxAttempts = 5
+
+// delay before the first retry, doubled for each attempt after
+// up to maxRetryDelay
+const (
+	retryDelay    = time.Second * 5
+	maxRetryDelay = time.Minute * 10
+)
+
+// headers attached to retried and dead lettered messages
+const (
+	HeaderQueue    = "x-mxax-queue"
+	HeaderError    = "x-mxax-error"
+	HeaderAttempts = "x-mxax-attempts"
+	HeaderFailedAt = "x-mxax-failed-at"
+)
+
+// Queue returns the name of the dead letter queue for queue
+func Queue(queue string) string {
+	return queue + ".dead"
+}
+
+// Declare a dead letter queue for each of queues
+func Declare(q mq.Queue, queues ...string) error {
+	for _, queue := range queues {
+		if err := q.Declare(Queue(queue)); err != nil {
+			return errors.WithMessagef(err, "Declare '%s'", Queue(queue))
+		}
+	}
+
+	return nil
+}
+
+// poison marks an error that retrying will never fix,
+// i.e. a message that can not be decoded
+type poison struct {
+	error
+}
+
+func (p poison) Unwrap() error { return p.error }
+
+// Poison marks err as one that retrying will not fix
+func Poison(err error) error {
+	return poison{err}
+}
+
+// IsPoison checks if err was marked with Poison
+func IsPoison(err error) bool {
+	var p poison
+	return errors.As(err, &p)
+}
+
+// Fail handles a msg that could not be processed, poison messages
+// are dead lettered straight away and anything else is retried.
+// In both cases msg is acked
+func Fail(publisher mq.Publisher, queue string, msg *mq.Message, reason error) error {
+	if IsPoison(reason) {
+		if err := Publish(publisher, queue, msg, reason); err != nil {
+			msg.Nack(true)
+			return err
+		}
+		return msg.Ack()
+	}
+
+	return Retry(publisher, queue, msg, reason, DefaultMaxAttempts)
+}
+
+// Attempts returns how many times msg has already failed
+func Attempts(msg *mq.Message) int {
+	switch v := msg.Headers[HeaderAttempts].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// Backoff returns how long to wait before retrying a message
+// that has failed attempts times
+func Backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	return delay
+}
+
+// Publish msg to the dead letter queue for queue with the reason
+// it failed attached. The caller is responsible for acking msg
+func Publish(publisher mq.Publisher, queue string, msg *mq.Message, reason error) error {
+	return publish(publisher, Queue(queue), queue, msg, reason, Attempts(msg)+1, 0)
+}
+
+// Retry puts msg back on queue with its attempts incremented once
+// it has backed off, once it has failed maxAttempts times it is
+// dead lettered instead. In both cases msg is acked
+func Retry(publisher mq.Publisher, queue string, msg *mq.Message, reason error, maxAttempts int) error {
+	attempts := Attempts(msg) + 1
+
+	target := queue
+	delay := Backoff(attempts)
+	if attempts >= maxAttempts {
+		target = Queue(queue)
+		delay = 0
+	}
+
+	if err := publish(publisher, target, queue, msg, reason, attempts, delay); err != nil {
+		// leave it on the queue rather than lose it
+		msg.Nack(true)
+		return err
+	}
+
+	return msg.Ack()
+}
+
+func publish(publisher mq.Publisher, target, queue string, msg *mq.Message, reason error, attempts int, delay time.Duration) error {
+	headers := mq.Headers{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	headers[HeaderQueue] = queue
+	headers[HeaderError] = reason.Error()
+	headers[HeaderAttempts] = int32(attempts)
+	headers[HeaderFailedAt] = time.Now()
+
+	out := mq.Message{
+		Headers:     headers,
+		Timestamp:   msg.Timestamp,
+		ContentType: msg.ContentType,
+		Body:        msg.Body,
+	}
+
+	if delay > 0 {
+		if err := publisher.PublishDelayed(target, out, delay); err != nil {
+			return errors.WithMessage(err, "PublishDelayed")
+		}
+		return nil
+	}
+
+	if err := publisher.Publish(target, out); err != nil {
+		return errors.WithMessage(err, "Publish")
+	}
+
+	return nil
+}
+
+// Replay publishes a dead lettered msg back on to the queue it
+// failed on with its failure headers removed
+func Replay(publisher mq.Publisher, msg *mq.Message) error {
+	queue, ok := msg.Headers[HeaderQueue].(string)
+	if !ok || len(queue) == 0 {
+		return errors.New("message has no queue header")
+	}
+
+	headers := mq.Headers{}
+	for k, v := range msg.Headers {
+		switch k {
+		case HeaderQueue, HeaderError, HeaderAttempts, HeaderFailedAt:
+			continue
+		}
+		headers[k] = v
+	}
+
+	err := publisher.Publish(queue, mq.Message{
+		Headers:     headers,
+		Timestamp:   msg.Timestamp,
+		ContentType: msg.ContentType,
+		Body:        msg.Body,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "Publish")
+	}
+
+	return nil
+}
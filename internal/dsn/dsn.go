@@ -0,0 +1,199 @@
+package dsn
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Report is a delivery status notification as defined
+// in RFC 3464
+type Report struct {
+	ReportingMTA string
+
+	Recipients []Recipient
+
+	// headers of the original message if they were returned
+	Original mail.Header
+}
+
+// Recipient is the status of delivery to a single recipient
+type Recipient struct {
+	FinalRecipient    string
+	OriginalRecipient string
+
+	// failed, delayed, delivered, relayed or expanded
+	Action string
+
+	// enhanced status code i.e. 5.1.1
+	Status string
+
+	DiagnosticCode string
+	RemoteMTA      string
+}
+
+// Failed checks if delivery to the recipient has failed
+func (r Recipient) Failed() bool {
+	return r.Action == "failed"
+}
+
+// Permanent checks if the failure will never succeed on a retry
+func (r Recipient) Permanent() bool {
+	return r.Failed() && strings.HasPrefix(r.Status, "5.")
+}
+
+// MailboxProblem checks if the failure was down to the recipient's
+// address or mailbox rather than the message, i.e. 5.1.1 bad
+// mailbox or 5.2.1 mailbox disabled
+func (r Recipient) MailboxProblem() bool {
+	if !r.Permanent() {
+		return false
+	}
+
+	parts := strings.Split(r.Status, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	switch parts[1] {
+	case "1":
+		return true
+	case "2":
+		return parts[2] == "1"
+	}
+
+	return false
+}
+
+// Parse reads an email and extracts the delivery status notification
+func Parse(r io.Reader) (*Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, errors.WithMessage(err, "ReadMessage")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.WithMessage(err, "ParseMediaType")
+	}
+
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") {
+		return nil, errors.Errorf("not a delivery status notification: '%s'", msg.Header.Get("Content-Type"))
+	}
+
+	var report *Report
+	var original mail.Header
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, "NextPart")
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			report, err = parseDeliveryStatus(part)
+			if err != nil {
+				return nil, errors.WithMessage(err, "parseDeliveryStatus")
+			}
+
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			body, err := ioutil.ReadAll(part)
+			if err != nil {
+				return nil, errors.WithMessage(err, "ReadAll original")
+			}
+
+			// the original is a nice to have
+			original, _ = parseHeaders(body)
+		}
+	}
+
+	if report == nil {
+		return nil, errors.New("no message/delivery-status part found")
+	}
+
+	report.Original = original
+
+	return report, nil
+}
+
+// the delivery status is a block of per message fields followed
+// by a block of fields for each recipient
+func parseDeliveryStatus(r io.Reader) (*Report, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+
+	perMessage, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, errors.WithMessage(err, "ReadMIMEHeader per message")
+	}
+
+	report := &Report{
+		ReportingMTA: trimType(perMessage.Get("Reporting-MTA")),
+	}
+
+	for err != io.EOF {
+		var fields textproto.MIMEHeader
+		fields, err = tp.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return nil, errors.WithMessage(err, "ReadMIMEHeader per recipient")
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		report.Recipients = append(report.Recipients, Recipient{
+			FinalRecipient:    strings.ToLower(trimType(fields.Get("Final-Recipient"))),
+			OriginalRecipient: strings.ToLower(trimType(fields.Get("Original-Recipient"))),
+			Action:            strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+			Status:            strings.TrimSpace(fields.Get("Status")),
+			DiagnosticCode:    trimType(fields.Get("Diagnostic-Code")),
+			RemoteMTA:         trimType(fields.Get("Remote-MTA")),
+		})
+	}
+
+	if len(report.Recipients) == 0 {
+		return nil, errors.New("no recipients found")
+	}
+
+	return report, nil
+}
+
+// parse just the headers of an original message
+func parseHeaders(b []byte) (mail.Header, error) {
+	if !bytes.Contains(b, []byte("\r\n\r\n")) && !bytes.Contains(b, []byte("\n\n")) {
+		b = append(b, "\r\n\r\n"...)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Header, nil
+}
+
+// trim the type from a typed field i.e. rfc822;user@domain
+// or smtp; 550 5.1.1 unknown user
+func trimType(s string) string {
+	if idx := strings.Index(s, ";"); idx >= 0 {
+		s = s[idx+1:]
+	}
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "<")
+	return strings.TrimSuffix(s, ">")
+}
@@ -0,0 +1,131 @@
+package dsn
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDSN = `From: MAILER-DAEMON@mx.dest.test
+To: jess=0f8c6f5e-8b0a-4f8e-9d43-2f0c3f9a1d11@example.com
+Subject: Undelivered Mail Returned to Sender
+Content-Type: multipart/report; report-type=delivery-status; boundary="b"
+
+--b
+Content-Type: text/plain
+
+Your message could not be delivered.
+
+--b
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.dest.test
+
+Final-Recipient: rfc822; Jess@Dest.Test
+Original-Recipient: rfc822;jess@dest.test
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; mx.dest.test
+Diagnostic-Code: smtp; 550 5.1.1 unknown user
+
+Final-Recipient: rfc822; sam@dest.test
+Action: delayed
+Status: 4.4.1
+
+--b
+Content-Type: text/rfc822-headers
+
+From: Alice <alice@sender.test>
+Message-ID: <hello@sender.test>
+--b--
+`
+
+func TestParse(t *testing.T) {
+	report, err := Parse(strings.NewReader(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.ReportingMTA != "mx.dest.test" {
+		t.Errorf("expected reporting mta mx.dest.test, got '%s'", report.ReportingMTA)
+	}
+
+	if len(report.Recipients) != 2 {
+		t.Fatalf("expected 2 recipients, got %d", len(report.Recipients))
+	}
+
+	want := Recipient{
+		FinalRecipient:    "jess@dest.test",
+		OriginalRecipient: "jess@dest.test",
+		Action:            "failed",
+		Status:            "5.1.1",
+		DiagnosticCode:    "550 5.1.1 unknown user",
+		RemoteMTA:         "mx.dest.test",
+	}
+
+	if got := report.Recipients[0]; got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if got := report.Recipients[1]; got.FinalRecipient != "sam@dest.test" || got.Action != "delayed" {
+		t.Errorf("expected sam@dest.test to be delayed, got %+v", got)
+	}
+
+	if got := report.Original.Get("Message-ID"); got != "<hello@sender.test>" {
+		t.Errorf("expected the original Message-ID, got '%s'", got)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Run("not a report", func(t *testing.T) {
+		if _, err := Parse(strings.NewReader("Content-Type: text/plain\n\nhello\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("wrong report type", func(t *testing.T) {
+		message := strings.Replace(testDSN, "delivery-status;", "feedback-report;", 1)
+		if _, err := Parse(strings.NewReader(message)); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("no status part", func(t *testing.T) {
+		message := strings.Replace(testDSN, "message/delivery-status", "text/plain", 1)
+		if _, err := Parse(strings.NewReader(message)); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestRecipient(t *testing.T) {
+	for _, tc := range []struct {
+		action         string
+		status         string
+		failed         bool
+		permanent      bool
+		mailboxProblem bool
+	}{
+		{"failed", "5.1.1", true, true, true},
+		{"failed", "5.2.1", true, true, true},
+		{"failed", "5.2.2", true, true, false},
+		{"failed", "5.7.1", true, true, false},
+		{"failed", "4.4.1", true, false, false},
+		{"delayed", "4.4.1", false, false, false},
+		{"delayed", "5.1.1", false, false, false},
+		{"failed", "5.1", true, true, false},
+	} {
+		r := Recipient{Action: tc.action, Status: tc.status}
+
+		if got := r.Failed(); got != tc.failed {
+			t.Errorf("%s %s: expected Failed %t, got %t", tc.action, tc.status, tc.failed, got)
+		}
+
+		if got := r.Permanent(); got != tc.permanent {
+			t.Errorf("%s %s: expected Permanent %t, got %t", tc.action, tc.status, tc.permanent, got)
+		}
+
+		if got := r.MailboxProblem(); got != tc.mailboxProblem {
+			t.Errorf("%s %s: expected MailboxProblem %t, got %t", tc.action, tc.status, tc.mailboxProblem, got)
+		}
+	}
+}
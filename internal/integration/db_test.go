@@ -0,0 +1,441 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+)
+
+// fixtures held by the fake database
+type fakeDomain struct {
+	ID        int
+	AccountID int
+	Name      string
+
+	AutoCreateRule      string
+	DefaultDestinations []int
+	ReverseAliases      bool
+}
+
+type fakeAlias struct {
+	ID        int
+	AccountID int
+	DomainID  int
+	Rule      string
+	RuleType  account.RuleType
+	Priority  int
+	CatchAll  bool
+
+	State         account.AliasState
+	RejectMessage string
+
+	MaxUses               int
+	Uses                  int
+	Expired               bool
+	FallbackDestinationID int
+	AutoCreated           bool
+
+	SenderAction account.SenderAction
+	SenderRules  []account.SenderRule
+
+	Scrub bool
+
+	SubjectTag string
+	TagHeaders bool
+}
+
+var fakeAliasColumns = []string{"id", "account_id", "domain_id", "rule", "rule_type", "priority", "catch_all", "state", "reject_message", "max_uses", "uses", "fallback_destination_id", "auto_created", "sender_action", "scrub", "subject_tag", "tag_headers"}
+
+func (a fakeAlias) values() []interface{} {
+	fallback := pgtype.Int4{Status: pgtype.Null}
+	if a.FallbackDestinationID > 0 {
+		fallback = pgtype.Int4{Int: int32(a.FallbackDestinationID), Status: pgtype.Present}
+	}
+	return []interface{}{a.ID, a.AccountID, a.DomainID, a.Rule, int(a.RuleType), a.Priority, a.CatchAll, int(a.State), a.RejectMessage, a.MaxUses, a.Uses, fallback, a.AutoCreated, int(a.SenderAction), a.Scrub, a.SubjectTag, a.TagHeaders}
+}
+
+type fakeDestination struct {
+	ID        int
+	AccountID int
+	Address   string
+	AliasID   int
+}
+
+type fakeReturnPath struct {
+	AccountID int
+	AliasID   int
+	ReturnTo  string
+}
+
+// fakeDB answers the queries smtp.Server makes from fixtures,
+// queries are recognised by the tables they touch. It stands in
+// for the database so the pipeline can run, it is not a model of
+// it and the SQL it is given is never checked
+type fakeDB struct {
+	sync.Mutex
+
+	owners       map[int]string
+	domains      []fakeDomain
+	aliases      []fakeAlias
+	destinations []fakeDestination
+	dkimKeys     map[int][]byte
+	suppressions map[string]string
+
+	returnPaths    map[uuid.UUID]fakeReturnPath
+	reverseAliases map[uuid.UUID]account.ReverseAlias
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		owners:         make(map[int]string),
+		dkimKeys:       make(map[int][]byte),
+		suppressions:   make(map[string]string),
+		returnPaths:    make(map[uuid.UUID]fakeReturnPath),
+		reverseAliases: make(map[uuid.UUID]account.ReverseAlias),
+	}
+}
+
+func normalise(sql string) string {
+	return strings.ToLower(strings.Join(strings.Fields(sql), " "))
+}
+
+func (db *fakeDB) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	q := normalise(sql)
+
+	switch {
+	case strings.HasPrefix(q, "insert into return_paths"):
+		db.returnPaths[args[0].(uuid.UUID)] = fakeReturnPath{
+			AccountID: args[1].(int),
+			AliasID:   args[2].(int),
+			ReturnTo:  args[3].(string),
+		}
+		return pgconn.CommandTag("INSERT 0 1"), nil
+
+	case strings.HasPrefix(q, "update return_paths"):
+		return pgconn.CommandTag("UPDATE 1"), nil
+
+	case strings.HasPrefix(q, "update reverse_aliases"):
+		r, ok := db.reverseAliases[args[0].(uuid.UUID)]
+		if !ok {
+			return pgconn.CommandTag("UPDATE 0"), nil
+		}
+		r.LastUsedAt = pgtype.Timestamp{Time: time.Now(), Status: pgtype.Present}
+		db.reverseAliases[r.ID] = r
+		return pgconn.CommandTag("UPDATE 1"), nil
+
+	case strings.HasPrefix(q, "update aliases set expired_at"):
+		for idx := range db.aliases {
+			if db.aliases[idx].ID == args[0].(int) && !db.aliases[idx].Expired {
+				db.aliases[idx].Expired = true
+				return pgconn.CommandTag("UPDATE 1"), nil
+			}
+		}
+		return pgconn.CommandTag("UPDATE 0"), nil
+	}
+
+	return nil, fmt.Errorf("fakeDB: unexpected exec: %s", q)
+}
+
+func (db *fakeDB) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	db.Lock()
+	defer db.Unlock()
+
+	q := normalise(sql)
+
+	switch {
+	case strings.Contains(q, "select return_to from return_paths"):
+		rp, ok := db.returnPaths[args[0].(uuid.UUID)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []interface{}{rp.ReturnTo}}
+
+	case strings.Contains(q, "select alias_id from return_paths"):
+		rp, ok := db.returnPaths[args[0].(uuid.UUID)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []interface{}{rp.AliasID}}
+
+	case strings.Contains(q, "from dkim_keys"):
+		key, ok := db.dkimKeys[args[0].(int)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []interface{}{key}}
+
+	case strings.Contains(q, "ip_pool"):
+		return fakeRow{values: []interface{}{""}}
+
+	case strings.HasPrefix(q, "select expired_at is not null"):
+		for _, a := range db.aliases {
+			if a.ID == args[0].(int) {
+				return fakeRow{values: []interface{}{a.Expired || (a.MaxUses > 0 && a.Uses >= a.MaxUses)}}
+			}
+		}
+		return fakeRow{err: pgx.ErrNoRows}
+
+	case strings.HasPrefix(q, "update aliases set uses"):
+		for idx := range db.aliases {
+			a := &db.aliases[idx]
+			if a.ID != args[0].(int) || a.Expired {
+				continue
+			}
+			a.Uses++
+			a.Expired = a.MaxUses > 0 && a.Uses >= a.MaxUses
+			return fakeRow{values: []interface{}{a.Uses}}
+		}
+		return fakeRow{err: pgx.ErrNoRows}
+
+	case strings.HasPrefix(q, "insert into reverse_aliases"):
+		for id, r := range db.reverseAliases {
+			if r.AliasID == args[2].(int) && r.Correspondent == args[4].(string) {
+				r.AliasAddress = args[3].(string)
+				db.reverseAliases[id] = r
+				return fakeRow{values: []interface{}{id}}
+			}
+		}
+		r := account.ReverseAlias{
+			ID:            uuid.New(),
+			AccountID:     args[0].(int),
+			DomainID:      args[1].(int),
+			AliasID:       args[2].(int),
+			AliasAddress:  args[3].(string),
+			Correspondent: args[4].(string),
+			CreatedAt:     time.Now(),
+		}
+		db.reverseAliases[r.ID] = r
+		return fakeRow{values: []interface{}{r.ID}}
+
+	case strings.Contains(q, "select email from accounts"):
+		owner, ok := db.owners[args[0].(int)]
+		if !ok {
+			return fakeRow{err: pgx.ErrNoRows}
+		}
+		return fakeRow{values: []interface{}{owner}}
+	}
+
+	return fakeRow{err: fmt.Errorf("fakeDB: unexpected query row: %s", q)}
+}
+
+func (db *fakeDB) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	q := normalise(sql)
+
+	rows := &fakeRows{}
+
+	switch {
+	case strings.HasPrefix(q, "with a as ( insert into aliases"):
+		// auto created alias
+		rows.columns = fakeAliasColumns
+
+		for _, a := range db.aliases {
+			if a.DomainID == args[1].(int) && a.Rule == args[2].(string) {
+				rows.values = append(rows.values, a.values())
+				return rows, nil
+			}
+		}
+
+		a := fakeAlias{
+			ID:          len(db.aliases) + 1,
+			AccountID:   args[0].(int),
+			DomainID:    args[1].(int),
+			Rule:        args[2].(string),
+			RuleType:    args[3].(account.RuleType),
+			AutoCreated: true,
+		}
+		db.aliases = append(db.aliases, a)
+
+		for _, d := range db.domains {
+			if d.ID != a.DomainID {
+				continue
+			}
+			for _, id := range d.DefaultDestinations {
+				for _, dest := range db.destinations {
+					if dest.ID == id {
+						dest.AliasID = a.ID
+						db.destinations = append(db.destinations, dest)
+						break
+					}
+				}
+			}
+		}
+
+		rows.values = append(rows.values, a.values())
+
+	case strings.Contains(q, "from domains"):
+		rows.columns = []string{"id", "account_id", "name", "auto_create_rule", "reverse_aliases"}
+		for _, d := range db.domains {
+			if d.Name == args[0].(string) {
+				rows.values = append(rows.values, []interface{}{d.ID, d.AccountID, d.Name, d.AutoCreateRule, d.ReverseAliases})
+			}
+		}
+
+	case strings.Contains(q, "from reverse_aliases"):
+		rows.columns = []string{"id", "account_id", "domain_id", "alias_id", "alias_address", "correspondent", "created_at", "last_used_at"}
+		r, ok := db.reverseAliases[args[0].(uuid.UUID)]
+		if ok && r.DomainID == args[1].(int) {
+			rows.values = append(rows.values, []interface{}{r.ID, r.AccountID, r.DomainID, r.AliasID, r.AliasAddress, r.Correspondent, r.CreatedAt, r.LastUsedAt})
+		}
+
+	case strings.Contains(q, "from aliases as a"):
+		rows.columns = fakeAliasColumns
+		for _, d := range db.domains {
+			if d.Name != args[0].(string) {
+				continue
+			}
+			for _, a := range db.aliases {
+				if a.DomainID == d.ID {
+					rows.values = append(rows.values, a.values())
+				}
+			}
+		}
+
+	case strings.Contains(q, "from sender_rules"):
+		rows.columns = []string{"id", "alias_id", "pattern", "pattern_type", "allow"}
+		for _, a := range db.aliases {
+			if a.ID != args[0].(int) {
+				continue
+			}
+			for _, r := range a.SenderRules {
+				rows.values = append(rows.values, []interface{}{r.ID, a.ID, r.Pattern, int(r.PatternType), r.Allow})
+			}
+		}
+
+	case strings.Contains(q, "join default_destinations"):
+		rows.columns = []string{"id", "account_id", "address"}
+		for _, d := range db.domains {
+			if d.ID != args[0].(int) {
+				continue
+			}
+			for _, id := range d.DefaultDestinations {
+				for _, dest := range db.destinations {
+					if dest.ID == id {
+						rows.values = append(rows.values, []interface{}{dest.ID, dest.AccountID, dest.Address})
+						break
+					}
+				}
+			}
+		}
+
+	case strings.Contains(q, "from destinations as d"):
+		rows.columns = []string{"id", "account_id", "address"}
+		for _, d := range db.destinations {
+			if d.AliasID == args[0].(int) {
+				rows.values = append(rows.values, []interface{}{d.ID, d.AccountID, d.Address})
+			}
+		}
+
+	case strings.Contains(q, "from destinations where"):
+		rows.columns = []string{"id", "account_id", "address"}
+		for _, d := range db.destinations {
+			if d.ID == int(args[0].(int32)) && d.AccountID == args[1].(int) {
+				rows.values = append(rows.values, []interface{}{d.ID, d.AccountID, d.Address})
+			}
+		}
+
+	case strings.HasPrefix(q, "update held_emails"):
+		// nothing is held by the relay
+		rows.columns = []string{"id", "email"}
+
+	case strings.Contains(q, "from suppressions"):
+		rows.columns = []string{"address", "reason"}
+		for address, reason := range db.suppressions {
+			rows.values = append(rows.values, []interface{}{address, reason})
+		}
+
+	default:
+		return nil, fmt.Errorf("fakeDB: unexpected query: %s", q)
+	}
+
+	return rows, nil
+}
+
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	return scanValues(r.values, dest)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]interface{}
+	idx     int
+}
+
+func (r *fakeRows) Close()                        {}
+func (r *fakeRows) Err() error                    { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag { return nil }
+func (r *fakeRows) RawValues() [][]byte           { return nil }
+
+func (r *fakeRows) FieldDescriptions() []pgproto3.FieldDescription {
+	fields := make([]pgproto3.FieldDescription, 0, len(r.columns))
+	for _, column := range r.columns {
+		fields = append(fields, pgproto3.FieldDescription{Name: []byte(column)})
+	}
+	return fields
+}
+
+func (r *fakeRows) Next() bool {
+	if r.idx >= len(r.values) {
+		return false
+	}
+	r.idx++
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	return scanValues(r.values[r.idx-1], dest)
+}
+
+func (r *fakeRows) Values() ([]interface{}, error) {
+	return r.values[r.idx-1], nil
+}
+
+// assign values to dest pointers, converting where needed
+func scanValues(values, dest []interface{}) error {
+	if len(values) != len(dest) {
+		return fmt.Errorf("fakeDB: %d values for %d destinations", len(values), len(dest))
+	}
+
+	for idx, v := range values {
+		if dest[idx] == nil || v == nil {
+			continue
+		}
+
+		d := reflect.ValueOf(dest[idx])
+		if d.Kind() != reflect.Ptr {
+			return fmt.Errorf("fakeDB: destination %d is not a pointer", idx)
+		}
+
+		val := reflect.ValueOf(v)
+		if !val.Type().ConvertibleTo(d.Elem().Type()) {
+			return fmt.Errorf("fakeDB: can not scan %T in to %s", v, d.Elem().Type())
+		}
+
+		d.Elem().Set(val.Convert(d.Elem().Type()))
+	}
+
+	return nil
+}
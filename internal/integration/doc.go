@@ -0,0 +1,12 @@
+// Package integration runs the relay, sender and logger pipeline
+// end to end inside go test. smtp.Server listens on ephemeral ports
+// with stubbed spam, dns and database dependencies, the sender
+// delivers to a local fake MX and everything is connected with an
+// in memory queue
+//
+// This is a queue and SMTP harness only. The fake database returns
+// fixtures for the queries the relay makes and does not check their
+// SQL, so it proves nothing about queries, constraints or row level
+// security. Those are tested against Postgres with the schema loaded,
+// see internal/pgtest
+package integration
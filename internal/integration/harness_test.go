@@ -0,0 +1,373 @@
+package integration
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/Teamwork/spamc"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/jawr/mxax/internal/logger"
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/sender"
+	"github.com/jawr/mxax/internal/smtp"
+)
+
+// how long to wait for anything to come out of the pipeline
+const waitTimeout = time.Second * 10
+
+// fixtures used by every harness
+const (
+	testDomain      = "example.com"
+	testAlias       = "jess@example.com"
+	testDestination = "jess@dest.test"
+	testOwner       = "owner@owner.test"
+	testServerName  = "mx.test"
+	testClientName  = "client.example.net"
+)
+
+// harness runs smtp.Server and sender.Sender connected by an
+// in memory queue, delivering to a fake MX
+type harness struct {
+	t *testing.T
+
+	db     *fakeDB
+	queue  *mq.Memory
+	server *smtp.Server
+	mx     *fakeMX
+
+	// address of the relay listener
+	relayAddr string
+
+	// dkim key of testDomain
+	dkimKey *rsa.PrivateKey
+
+	// spf.Result every sender gets, spf.Pass until stored
+	spfResult atomic.Value
+
+	entries chan logger.Entry
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:       t,
+		db:      newFakeDB(),
+		queue:   mq.NewMemory(),
+		entries: make(chan logger.Entry, 100),
+	}
+
+	var err error
+	h.dkimKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+
+	h.db.owners[1] = testOwner
+	h.db.domains = append(h.db.domains, fakeDomain{ID: 1, AccountID: 1, Name: testDomain})
+	h.db.aliases = append(h.db.aliases, fakeAlias{ID: 1, AccountID: 1, DomainID: 1, Rule: "jess"})
+	h.db.destinations = append(h.db.destinations, fakeDestination{ID: 1, AccountID: 1, Address: testDestination, AliasID: 1})
+	h.db.dkimKeys[1] = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(h.dkimKey),
+	})
+
+	if err := h.queue.Declare("logs", "bounces", "tlsrpt"); err != nil {
+		t.Fatalf("Declare: %s", err)
+	}
+
+	if err := h.queue.Declare(smtp.QueueLevel(smtp.QueueLevelStraw).Lanes()...); err != nil {
+		t.Fatalf("Declare: %s", err)
+	}
+
+	h.startMX()
+	h.startServer()
+	h.startSender()
+	h.startLogger()
+
+	t.Cleanup(func() {
+		h.queue.Close()
+	})
+
+	return h
+}
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	return l
+}
+
+func (h *harness) startServer() {
+	server, err := smtp.NewServer(h.db, h.queue, h.queue, nil)
+	if err != nil {
+		h.t.Fatalf("NewServer: %s", err)
+	}
+
+	server.Spam = stubSpam{}
+	server.CheckSPF = func(ip net.IP, helo, sender string) (spf.Result, error) {
+		if result, ok := h.spfResult.Load().(spf.Result); ok {
+			return result, nil
+		}
+		return spf.Pass, nil
+	}
+	server.LookupAddr = func(addr string) ([]string, error) {
+		return []string{testClientName + "."}, nil
+	}
+
+	relay := listen(h.t)
+	submission := listen(h.t)
+
+	go server.Serve(testServerName, relay, submission)
+
+	h.t.Cleanup(server.Close)
+
+	h.server = server
+	h.relayAddr = relay.Addr().String()
+}
+
+func (h *harness) startSender() {
+	level := smtp.QueueLevel(smtp.QueueLevelStraw)
+
+	var lanes []sender.Lane
+	for _, priority := range smtp.Priorities {
+		emails, err := h.queue.Consume(level.Lane(priority), "sender", 10)
+		if err != nil {
+			h.t.Fatalf("Consume %s: %s", level.Lane(priority), err)
+		}
+
+		lanes = append(lanes, sender.Lane{
+			Priority: priority,
+			Weight:   sender.DefaultLaneWeights[priority],
+			Messages: emails,
+		})
+	}
+
+	throttle := sender.NewThrottle(sender.Limit{Concurrency: 10}, nil)
+	pool := sender.NewPool(time.Minute, 100)
+
+	ips := sender.NewIPPools(nil, sender.Health{})
+	ips.Add(sender.DefaultIPPool, &sender.IP{
+		Addr:   "127.0.0.1",
+		Rdns:   "sender.test",
+		Dialer: net.Dialer{Timeout: time.Second * 5},
+		Family: sender.FamilyIPv4,
+	})
+
+	families := sender.NewFamilyPreferences(sender.Families{sender.FamilyIPv4}, nil)
+
+	sndr, err := sender.NewSender(h.queue, lanes, nil, throttle, pool, ips, families)
+	if err != nil {
+		h.t.Fatalf("NewSender: %s", err)
+	}
+
+	// every destination is handled by the fake MX
+	_, port, _ := net.SplitHostPort(h.mx.addr)
+	sndr.Port = port
+	sndr.LookupMX = func(name string) ([]*net.MX, error) {
+		return []*net.MX{{Host: "127.0.0.1", Pref: 10}}, nil
+	}
+	sndr.LookupIP = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("127.0.0.1")}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(cancel)
+
+	go sndr.Run(ctx)
+	sndr.Start()
+}
+
+// startLogger captures log entries rather than storing them
+func (h *harness) startLogger() {
+	msgs, err := h.queue.Consume("logs", "logger", 10)
+	if err != nil {
+		h.t.Fatalf("Consume logs: %s", err)
+	}
+
+	go func() {
+		for msg := range msgs {
+			var entry logger.Entry
+			if err := json.Unmarshal(msg.Body, &entry); err != nil {
+				h.t.Errorf("Unmarshal entry: %s", err)
+			}
+			msg.Ack()
+
+			h.entries <- entry
+		}
+	}()
+}
+
+// send a message to the relay as testClientName
+func (h *harness) send(from, to, message string) error {
+	c, err := gosmtp.Dial(h.relayAddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello(testClientName); err != nil {
+		return err
+	}
+
+	if err := c.Mail(from, nil); err != nil {
+		return err
+	}
+
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, strings.ReplaceAll(message, "\n", "\r\n")); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// waitDelivery waits for the next message delivered to the fake MX
+func (h *harness) waitDelivery() delivery {
+	select {
+	case d := <-h.mx.deliveries:
+		return d
+	case <-time.After(waitTimeout):
+		h.t.Fatal("timed out waiting for delivery")
+	}
+	return delivery{}
+}
+
+// waitEntry waits for a log entry matching fn, skipping others
+func (h *harness) waitEntry(fn func(logger.Entry) bool) logger.Entry {
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case e := <-h.entries:
+			if fn(e) {
+				return e
+			}
+		case <-timeout:
+			h.t.Fatal("timed out waiting for log entry")
+			return logger.Entry{}
+		}
+	}
+}
+
+// lookupTXT serves the dkim record of testDomain
+func (h *harness) lookupTXT(domain string) ([]string, error) {
+	if domain != "mxax._domainkey."+testDomain {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&h.dkimKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(public)}, nil
+}
+
+// stubSpam passes every message
+type stubSpam struct{}
+
+func (stubSpam) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (stubSpam) Check(ctx context.Context, msg io.Reader, hdr spamc.Header) (*spamc.ResponseCheck, error) {
+	return &spamc.ResponseCheck{}, nil
+}
+
+// delivery is a message received by the fake MX
+type delivery struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// fakeMX accepts everything
+type fakeMX struct {
+	addr       string
+	deliveries chan delivery
+}
+
+func (h *harness) startMX() {
+	h.mx = &fakeMX{
+		deliveries: make(chan delivery, 100),
+	}
+
+	server := gosmtp.NewServer(h.mx)
+	server.Domain = "fake.mx"
+	server.AllowInsecureAuth = true
+
+	l := listen(h.t)
+	h.mx.addr = l.Addr().String()
+
+	go server.Serve(l)
+
+	h.t.Cleanup(server.Close)
+}
+
+func (mx *fakeMX) Login(state *gosmtp.ConnectionState, username, password string) (gosmtp.Session, error) {
+	return nil, gosmtp.ErrAuthUnsupported
+}
+
+func (mx *fakeMX) AnonymousLogin(state *gosmtp.ConnectionState) (gosmtp.Session, error) {
+	return &fakeMXSession{mx: mx}, nil
+}
+
+type fakeMXSession struct {
+	mx *fakeMX
+	d  delivery
+}
+
+func (s *fakeMXSession) Mail(from string, opts gosmtp.MailOptions) error {
+	s.d.From = from
+	return nil
+}
+
+func (s *fakeMXSession) Rcpt(to string) error {
+	s.d.To = append(s.d.To, to)
+	return nil
+}
+
+func (s *fakeMXSession) Data(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.d.Data = b
+	s.mx.deliveries <- s.d
+
+	return nil
+}
+
+func (s *fakeMXSession) Reset() {
+	s.d = delivery{}
+}
+
+func (s *fakeMXSession) Logout() error {
+	return nil
+}
@@ -0,0 +1,683 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"blitiri.com.ar/go/spf"
+	"github.com/emersion/go-msgauth/dkim"
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/jawr/mxax/internal/logger"
+	"github.com/jhillyerd/enmime"
+)
+
+const testMessage = `From: Alice <alice@sender.test>
+To: <jess@example.com>
+Subject: Hello
+Message-ID: <hello@sender.test>
+
+Hello Jess
+`
+
+func TestRelayForward(t *testing.T) {
+	h := newHarness(t)
+
+	if err := h.send("alice@sender.test", testAlias, testMessage); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+
+	if len(d.To) != 1 || d.To[0] != testDestination {
+		t.Fatalf("expected delivery to %s, got %v", testDestination, d.To)
+	}
+
+	// the envelope sender is rewritten to a return path
+	if !strings.HasPrefix(d.From, "jess=") || !strings.HasSuffix(d.From, "@"+testDomain) {
+		t.Fatalf("expected a return path for %s, got '%s'", testDomain, d.From)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if got := msg.Header.Get("Return-Path"); got != "<"+d.From+">" {
+		t.Errorf("expected Return-Path <%s>, got '%s'", d.From, got)
+	}
+
+	received := msg.Header.Get("Received")
+	for _, want := range []string{
+		"from " + testClientName,
+		"[127.0.0.1]",
+		"by " + testServerName + " with ESMTP",
+		"for <" + testDestination + ">",
+	} {
+		if !strings.Contains(received, want) {
+			t.Errorf("expected Received to contain '%s', got '%s'", want, received)
+		}
+	}
+
+	if got := msg.Header.Get("Subject"); got != "Hello" {
+		t.Errorf("expected the original Subject, got '%s'", got)
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(d.Data), &dkim.VerifyOptions{
+		LookupTXT: h.lookupTXT,
+	})
+	if err != nil {
+		t.Fatalf("dkim.Verify: %s", err)
+	}
+
+	if len(verifications) != 1 {
+		t.Fatalf("expected 1 dkim signature, got %d", len(verifications))
+	}
+
+	if v := verifications[0]; v.Err != nil || v.Domain != testDomain {
+		t.Errorf("expected a valid signature from %s, got %s: %v", testDomain, v.Domain, v.Err)
+	}
+
+	entry := h.waitEntry(func(e logger.Entry) bool {
+		return e.Etype == logger.EntryTypeSend
+	})
+
+	if entry.ToEmail != testDestination || entry.AliasID != 1 || entry.DestinationID != 1 || entry.DomainID != 1 {
+		t.Errorf("unexpected send entry: %+v", entry)
+	}
+}
+
+func TestRelayUnknownRecipient(t *testing.T) {
+	h := newHarness(t)
+
+	err := h.send("alice@sender.test", "nobody@"+testDomain, testMessage)
+
+	smtpErr, ok := err.(*gosmtp.SMTPError)
+	if !ok || smtpErr.Code != 550 {
+		t.Fatalf("expected a 550, got %v", err)
+	}
+
+	entry := h.waitEntry(func(e logger.Entry) bool {
+		return e.Etype == logger.EntryTypeReject
+	})
+
+	if entry.ViaEmail != "nobody@"+testDomain || entry.DomainID != 1 {
+		t.Errorf("unexpected reject entry: %+v", entry)
+	}
+}
+
+func TestRelaySuppressed(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.suppressions[testDestination] = "Bounced"
+	h.db.aliases[0].MaxUses = 1
+
+	// accepted so the sender does not retry, and dropped
+	if err := h.send("alice@sender.test", testAlias, testMessage); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	entry := h.waitEntry(func(e logger.Entry) bool {
+		return e.Etype == logger.EntryTypeDrop
+	})
+
+	if entry.ViaEmail != testAlias || entry.AliasID == 0 {
+		t.Errorf("unexpected drop entry: %+v", entry)
+	}
+
+	// dropped mail does not use up a burner
+	h.db.Lock()
+	defer h.db.Unlock()
+	if a := h.db.aliases[0]; a.Uses != 0 || a.Expired {
+		t.Errorf("expected the burner to be unused, got %d uses", a.Uses)
+	}
+}
+
+func TestReturnedDSN(t *testing.T) {
+	h := newHarness(t)
+
+	if err := h.send("alice@sender.test", testAlias, testMessage); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	forward := h.waitDelivery()
+
+	h.waitEntry(func(e logger.Entry) bool {
+		return e.Etype == logger.EntryTypeSend
+	})
+
+	// the destination accepted and then bounced the forward
+	report := fmt.Sprintf(`From: Mail Delivery System <MAILER-DAEMON@dest.test>
+To: <%s>
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="BOUNDARY"
+
+--BOUNDARY
+Content-Type: text/plain
+
+Your message could not be delivered.
+
+--BOUNDARY
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.dest.test
+
+Final-Recipient: rfc822; %s
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 no such user
+
+--BOUNDARY--
+`, forward.From, testDestination)
+
+	if err := h.send("", forward.From, report); err != nil {
+		t.Fatalf("send report: %s", err)
+	}
+
+	entry := h.waitEntry(func(e logger.Entry) bool {
+		return e.Etype == logger.EntryTypeBounce
+	})
+
+	if entry.DestinationID != 1 || entry.ToEmail != testDestination || !entry.Permanent {
+		t.Errorf("unexpected bounce entry: %+v", entry)
+	}
+
+	if !strings.Contains(entry.Status, "5.1.1") {
+		t.Errorf("expected the status in the bounce, got '%s'", entry.Status)
+	}
+
+	// the owner is told rather than the original sender
+	notice := h.waitDelivery()
+
+	if len(notice.To) != 1 || notice.To[0] != testOwner {
+		t.Fatalf("expected a notice to %s, got %v", testOwner, notice.To)
+	}
+
+	if !bytes.Contains(notice.Data, []byte(testDestination)) {
+		t.Errorf("expected the notice to mention %s", testDestination)
+	}
+}
+
+func TestRelayCatchAllLast(t *testing.T) {
+	h := newHarness(t)
+
+	// a catch all ahead of jess in priority is still checked last
+	h.db.aliases = append(h.db.aliases, fakeAlias{ID: 2, AccountID: 1, DomainID: 1, CatchAll: true})
+	h.db.destinations = append(h.db.destinations, fakeDestination{ID: 2, AccountID: 1, Address: "everything@dest.test", AliasID: 2})
+	h.db.aliases[0].Priority = 1
+
+	for _, tc := range []struct {
+		to   string
+		want string
+	}{
+		{testAlias, testDestination},
+		{"anyone@" + testDomain, "everything@dest.test"},
+	} {
+		if err := h.send("alice@sender.test", tc.to, testMessage); err != nil {
+			t.Fatalf("send to %s: %s", tc.to, err)
+		}
+
+		d := h.waitDelivery()
+
+		if len(d.To) != 1 || d.To[0] != tc.want {
+			t.Errorf("expected %s to be delivered to %s, got %v", tc.to, tc.want, d.To)
+		}
+	}
+}
+
+func TestRelaySubaddress(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.aliases[0].RuleType = account.RuleTypeSubaddress
+
+	if err := h.send("alice@sender.test", "jess+shop@"+testDomain, testMessage); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+
+	if len(d.To) != 1 || d.To[0] != testDestination {
+		t.Errorf("expected delivery to %s, got %v", testDestination, d.To)
+	}
+
+	if !bytes.Contains(d.Data, []byte("X-Mxax-Subaddress: shop")) {
+		t.Error("expected an X-Mxax-Subaddress header")
+	}
+}
+
+func TestRelayAliasState(t *testing.T) {
+	for _, tc := range []struct {
+		state   account.AliasState
+		message string
+		want    string
+	}{
+		{account.AliasStateDisabled, "", "unknown recipient"},
+		{account.AliasStateReject, "this address has been retired", "this address has been retired"},
+	} {
+		h := newHarness(t)
+
+		// a disabled alias must not fall through to the catch all
+		h.db.aliases = append(h.db.aliases, fakeAlias{ID: 2, AccountID: 1, DomainID: 1, CatchAll: true})
+		h.db.aliases[0].State = tc.state
+		h.db.aliases[0].RejectMessage = tc.message
+
+		err := h.send("alice@sender.test", testAlias, testMessage)
+
+		smtpErr, ok := err.(*gosmtp.SMTPError)
+		if !ok || smtpErr.Code != 550 {
+			t.Fatalf("%s: expected a 550, got %v", tc.state, err)
+		}
+
+		if !strings.HasPrefix(smtpErr.Message, tc.want) {
+			t.Errorf("%s: expected '%s', got '%s'", tc.state, tc.want, smtpErr.Message)
+		}
+	}
+}
+
+func TestRelayBurner(t *testing.T) {
+	const fallback = "fallback@dest.test"
+
+	for _, withFallback := range []bool{true, false} {
+		h := newHarness(t)
+
+		h.db.destinations = append(h.db.destinations, fakeDestination{ID: 2, AccountID: 1, Address: fallback})
+		h.db.aliases[0].MaxUses = 2
+		if withFallback {
+			h.db.aliases[0].FallbackDestinationID = 2
+		}
+
+		for idx := 0; idx < 2; idx++ {
+			if err := h.send("alice@sender.test", testAlias, testMessage); err != nil {
+				t.Fatalf("send %d: %s", idx, err)
+			}
+
+			d := h.waitDelivery()
+			if len(d.To) != 1 || d.To[0] != testDestination {
+				t.Errorf("expected delivery to %s, got %v", testDestination, d.To)
+			}
+		}
+
+		if !h.db.aliases[0].Expired {
+			t.Error("expected the alias to have expired")
+		}
+
+		err := h.send("alice@sender.test", testAlias, testMessage)
+
+		if !withFallback {
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != 550 {
+				t.Fatalf("expected a 550 once expired, got %v", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("send once expired: %s", err)
+		}
+
+		d := h.waitDelivery()
+		if len(d.To) != 1 || d.To[0] != fallback {
+			t.Errorf("expected delivery to %s once expired, got %v", fallback, d.To)
+		}
+	}
+}
+
+func TestRelayAutoCreate(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.domains[0].AutoCreateRule = "*.shop"
+	h.db.domains[0].DefaultDestinations = []int{1}
+
+	if err := h.send("alice@sender.test", "acme.shop@"+testDomain, testMessage); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+	if len(d.To) != 1 || d.To[0] != testDestination {
+		t.Errorf("expected delivery to %s, got %v", testDestination, d.To)
+	}
+
+	h.db.Lock()
+	created := h.db.aliases[len(h.db.aliases)-1]
+	h.db.Unlock()
+
+	if !created.AutoCreated || created.Rule != "acme.shop" || created.RuleType != account.RuleTypeExact {
+		t.Errorf("expected an auto created alias for acme.shop, got %+v", created)
+	}
+
+	// anything not matching the rule is still unknown
+	err := h.send("alice@sender.test", "acme@"+testDomain, testMessage)
+
+	smtpErr, ok := err.(*gosmtp.SMTPError)
+	if !ok || smtpErr.Code != 550 {
+		t.Fatalf("expected a 550, got %v", err)
+	}
+}
+
+func TestRelaySenderRules(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rules  []account.SenderRule
+		action account.SenderAction
+		from   string
+		want   string
+	}{
+		{
+			name:  "allowed domain",
+			rules: []account.SenderRule{{Pattern: "sender.test", PatternType: account.SenderRuleDomain, Allow: true}},
+			from:  "alice@mail.sender.test",
+			want:  "send",
+		},
+		{
+			name:  "not on the allow list",
+			rules: []account.SenderRule{{Pattern: "bob@sender.test", PatternType: account.SenderRuleAddress, Allow: true}},
+			from:  "alice@sender.test",
+			want:  "reject",
+		},
+		{
+			name:   "blocked and dropped",
+			rules:  []account.SenderRule{{Pattern: "alice@.*", PatternType: account.SenderRuleRegex}},
+			action: account.SenderActionDrop,
+			from:   "alice@sender.test",
+			want:   "drop",
+		},
+	} {
+		h := newHarness(t)
+
+		h.db.aliases[0].SenderRules = tc.rules
+		h.db.aliases[0].SenderAction = tc.action
+
+		err := h.send(tc.from, testAlias, testMessage)
+
+		switch tc.want {
+		case "send":
+			if err != nil {
+				t.Fatalf("%s: send: %s", tc.name, err)
+			}
+			h.waitDelivery()
+
+		case "reject":
+			smtpErr, ok := err.(*gosmtp.SMTPError)
+			if !ok || smtpErr.Code != 550 {
+				t.Fatalf("%s: expected a 550, got %v", tc.name, err)
+			}
+
+		case "drop":
+			if err != nil {
+				t.Fatalf("%s: expected the message to be accepted, got %s", tc.name, err)
+			}
+
+			entry := h.waitEntry(func(e logger.Entry) bool {
+				return e.Etype == logger.EntryTypeReject
+			})
+			if entry.Status != "Sender Refused" {
+				t.Errorf("%s: unexpected reject entry: %+v", tc.name, entry)
+			}
+
+			select {
+			case d := <-h.mx.deliveries:
+				t.Errorf("%s: expected the message to be dropped, delivered to %v", tc.name, d.To)
+			case <-time.After(time.Millisecond * 200):
+			}
+		}
+	}
+}
+
+func TestRelayReverseAlias(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.domains[0].ReverseAliases = true
+
+	message := strings.Replace(testMessage, "Subject:", "Reply-To: Alice <alice@reply.sender.test>\nSubject:", 1)
+
+	if err := h.send("alice@sender.test", testAlias, message); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if got := msg.Header.Get("Reply-To"); len(got) > 0 {
+		t.Errorf("expected Reply-To to be removed, got '%s'", got)
+	}
+
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 {
+		t.Fatalf("expected a single From, got %v (%v)", from, err)
+	}
+
+	// replies go to the reply to address of the correspondent
+	reverse := from[0].Address
+	if !strings.HasPrefix(reverse, "alice_at_reply.sender.test=") || !strings.HasSuffix(reverse, "@"+testDomain) {
+		t.Fatalf("expected a reverse alias at %s, got '%s'", testDomain, reverse)
+	}
+
+	// nobody but a destination can reply through it
+	err = h.send("mallory@evil.test", reverse, testMessage)
+	if smtpErr, ok := err.(*gosmtp.SMTPError); !ok || smtpErr.Code != 550 {
+		t.Fatalf("expected a 550 for a stranger, got %v", err)
+	}
+
+	reply := fmt.Sprintf(`Received: from laptop (laptop.dest.test [192.0.2.1])
+From: Jess <%s>
+Sender: <%s>
+To: <%s>
+Message-ID: <reply@dest.test>
+X-Originating-IP: 192.0.2.1
+Subject: Re: Hello
+In-Reply-To: <hello@sender.test>
+References: <hello@sender.test>
+ <earlier@dest.test>
+
+Hello Alice
+`, testDestination, testDestination, reverse)
+
+	// the destination's address alone is not enough
+	h.spfResult.Store(spf.None)
+
+	err = h.send(testDestination, reverse, reply)
+	if smtpErr, ok := err.(*gosmtp.SMTPError); !ok || smtpErr.Code != 550 {
+		t.Fatalf("expected a 550 without spf, got %v", err)
+	}
+
+	h.spfResult.Store(spf.Pass)
+
+	if err := h.send(testDestination, reverse, reply); err != nil {
+		t.Fatalf("send reply: %s", err)
+	}
+
+	d = h.waitDelivery()
+
+	if len(d.To) != 1 || d.To[0] != "alice@reply.sender.test" {
+		t.Fatalf("expected the reply to go to the correspondent, got %v", d.To)
+	}
+
+	if d.From != testAlias {
+		t.Errorf("expected the reply to be sent from %s, got '%s'", testAlias, d.From)
+	}
+
+	if bytes.Contains(d.Data, []byte("dest.test")) || bytes.Contains(d.Data, []byte("192.0.2.1")) {
+		t.Errorf("expected the destination to be stripped, got:\n%s", d.Data)
+	}
+
+	msg, err = mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if got := msg.Header.Get("From"); got != "<"+testAlias+">" {
+		t.Errorf("expected From <%s>, got '%s'", testAlias, got)
+	}
+
+	if got := msg.Header.Get("Subject"); got != "Re: Hello" {
+		t.Errorf("expected the original Subject, got '%s'", got)
+	}
+
+	if got := msg.Header.Get("Message-ID"); !strings.HasSuffix(got, "@"+testDomain+">") {
+		t.Errorf("expected a Message-ID on %s, got '%s'", testDomain, got)
+	}
+
+	if got := msg.Header.Get("References"); got != "<hello@sender.test>" {
+		t.Errorf("expected References of the correspondent only, got '%s'", got)
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(d.Data), &dkim.VerifyOptions{
+		LookupTXT: h.lookupTXT,
+	})
+	if err != nil || len(verifications) != 1 {
+		t.Fatalf("expected 1 dkim signature, got %d (%v)", len(verifications), err)
+	}
+
+	if v := verifications[0]; v.Err != nil || v.Domain != testDomain {
+		t.Errorf("expected a valid signature from %s, got %s: %v", testDomain, v.Domain, v.Err)
+	}
+}
+
+func TestRelayScrub(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.aliases[0].Scrub = true
+
+	message := `Received: from laptop.sender.test (laptop.sender.test [192.0.2.7])
+X-Originating-IP: [192.0.2.7]
+X-Mailer: Sender Mail 1.0
+From: Alice <alice@sender.test>
+To: <jess@example.com>
+Subject: Hello
+Message-ID: <hello@sender.test>
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Hello Jess
+--b1
+Content-Type: text/html; charset=utf-8
+
+<p>Hello Jess, <a href="https://www.google.com/url?q=https%3A%2F%2Fshop.test%2Fsale%3Fa%3D1%26b%3D2&amp;sa=D">sale</a></p>
+<img src="https://track.test/open.gif?id=42" width="1" height="1" alt="">
+<img src="https://shop.test/logo.png" width="120" height="40">
+--b1--
+`
+
+	if err := h.send("alice@sender.test", testAlias, message); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+
+	for _, leak := range []string{"192.0.2.7", "laptop.sender.test", "Sender Mail", "track.test", "www.google.com"} {
+		if bytes.Contains(d.Data, []byte(leak)) {
+			t.Errorf("expected '%s' to be scrubbed, got:\n%s", leak, d.Data)
+		}
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if received := msg.Header["Received"]; len(received) != 1 || !strings.Contains(received[0], "by "+testServerName) {
+		t.Errorf("expected only our Received header, got %v", received)
+	}
+
+	env, err := enmime.ReadEnvelope(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadEnvelope: %s", err)
+	}
+
+	if !strings.Contains(env.HTML, `href="https://shop.test/sale?a=1&amp;b=2"`) {
+		t.Errorf("expected the redirect to be unwrapped, got:\n%s", env.HTML)
+	}
+
+	if !strings.Contains(env.HTML, "https://shop.test/logo.png") {
+		t.Errorf("expected other images to be kept, got:\n%s", env.HTML)
+	}
+
+	if strings.TrimSpace(env.Text) != "Hello Jess" {
+		t.Errorf("expected the text part to be kept, got '%s'", env.Text)
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(d.Data), &dkim.VerifyOptions{
+		LookupTXT: h.lookupTXT,
+	})
+	if err != nil || len(verifications) != 1 {
+		t.Fatalf("expected 1 dkim signature, got %d (%v)", len(verifications), err)
+	}
+
+	if v := verifications[0]; v.Err != nil || v.Domain != testDomain {
+		t.Errorf("expected a valid signature from %s, got %s: %v", testDomain, v.Domain, v.Err)
+	}
+}
+
+func TestRelayTagging(t *testing.T) {
+	h := newHarness(t)
+
+	h.db.aliases[0].SubjectTag = "[shop]"
+	h.db.aliases[0].TagHeaders = true
+
+	// headers sent to us are replaced rather than trusted
+	message := "X-MXAX-Alias: boss@example.com\n" + testMessage
+
+	if err := h.send("alice@sender.test", testAlias, message); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d := h.waitDelivery()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if got := msg.Header.Get("Subject"); got != "[shop] Hello" {
+		t.Errorf("expected a tagged Subject, got '%s'", got)
+	}
+
+	if got := msg.Header["X-Mxax-Alias"]; len(got) != 1 || got[0] != testAlias {
+		t.Errorf("expected X-MXAX-Alias %s, got %v", testAlias, got)
+	}
+
+	if got := msg.Header.Get("X-MXAX-Domain"); got != testDomain {
+		t.Errorf("expected X-MXAX-Domain %s, got '%s'", testDomain, got)
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(d.Data), &dkim.VerifyOptions{
+		LookupTXT: h.lookupTXT,
+	})
+	if err != nil || len(verifications) != 1 {
+		t.Fatalf("expected 1 dkim signature, got %d (%v)", len(verifications), err)
+	}
+
+	if v := verifications[0]; v.Err != nil || v.Domain != testDomain {
+		t.Errorf("expected a valid signature from %s, got %s: %v", testDomain, v.Domain, v.Err)
+	}
+
+	// replies already carrying the tag are left alone
+	reply := strings.Replace(testMessage, "Subject: Hello", "Subject: Re: [shop] Hello", 1)
+
+	if err := h.send("alice@sender.test", testAlias, reply); err != nil {
+		t.Fatalf("send: %s", err)
+	}
+
+	d = h.waitDelivery()
+
+	msg, err = mail.ReadMessage(bytes.NewReader(d.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	if got := msg.Header.Get("Subject"); got != "Re: [shop] Hello" {
+		t.Errorf("expected the Subject to be tagged once, got '%s'", got)
+	}
+}
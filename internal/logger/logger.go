@@ -12,6 +12,13 @@ const (
 	EntryTypeSend EntryType = iota
 	EntryTypeReject
 	EntryTypeBounce
+	EntryTypeComplaint
+	// a destination has reported that delivery is delayed, it
+	// is not a failure
+	EntryTypeDelay
+	// accepted but not forwarded as every destination is
+	// suppressed
+	EntryTypeDrop
 )
 
 func (e EntryType) String() string {
@@ -22,6 +29,12 @@ func (e EntryType) String() string {
 		return "REJ"
 	case EntryTypeBounce:
 		return "BNC"
+	case EntryTypeComplaint:
+		return "CMP"
+	case EntryTypeDelay:
+		return "DLY"
+	case EntryTypeDrop:
+		return "DRP"
 	default:
 		return "Unknown"
 	}
@@ -48,6 +61,9 @@ type Entry struct {
 	Status string
 	Bounce string
 
+	// set when a bounce was a permanent failure
+	Permanent bool
+
 	QueueLevel int
 
 	// actual email message
@@ -0,0 +1,217 @@
+package mq
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/isayme/go-amqp-reconnect/rabbitmq"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// AMQP is a Queue backed by RabbitMQ, publishing goes through
+// the default exchange so queues are addressed by name
+type AMQP struct {
+	conn *rabbitmq.Connection
+
+	publisher *rabbitmq.Channel
+
+	sync.Mutex
+	consumers []*rabbitmq.Channel
+}
+
+// DialAMQP connects to the RabbitMQ server at url
+func DialAMQP(url string) (*AMQP, error) {
+	conn, err := rabbitmq.Dial(url)
+	if err != nil {
+		return nil, errors.WithMessage(err, "rabbitmq.Dial")
+	}
+
+	publisher, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, errors.WithMessage(err, "publisher.Channel")
+	}
+
+	q := &AMQP{
+		conn:      conn,
+		publisher: publisher,
+	}
+
+	return q, nil
+}
+
+func (q *AMQP) Declare(queues ...string) error {
+	for _, queue := range queues {
+		_, err := q.publisher.QueueDeclare(
+			queue,
+			true,  // durable
+			false, // autoDelete
+			false, // exclusive
+			false, // noWait
+			nil,
+		)
+		if err != nil {
+			return errors.WithMessagef(err, "QueueDeclare '%s'", queue)
+		}
+	}
+
+	return nil
+}
+
+func (q *AMQP) Publish(queue string, msg Message) error {
+	return q.publish(queue, msg, "")
+}
+
+// PublishDelayed parks msg on a queue per delay whose messages
+// expire back on to queue. A queue per delay is used as expired
+// messages are only removed from the head of a queue
+func (q *AMQP) PublishDelayed(queue string, msg Message, delay time.Duration) error {
+	ms := delay.Milliseconds()
+	if ms <= 0 {
+		return q.Publish(queue, msg)
+	}
+
+	delayed := fmt.Sprintf("%s.delay.%d", queue, ms)
+
+	_, err := q.publisher.QueueDeclare(
+		delayed,
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false, // noWait
+		amqp.Table{
+			"x-message-ttl":             ms,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+			// remove the queue once it has been idle for a while
+			"x-expires": ms*2 + time.Minute.Milliseconds(),
+		},
+	)
+	if err != nil {
+		return errors.WithMessagef(err, "QueueDeclare '%s'", delayed)
+	}
+
+	return q.publish(delayed, msg, strconv.FormatInt(ms, 10))
+}
+
+func (q *AMQP) publish(queue string, msg Message, expiration string) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	err := q.publisher.Publish(
+		"",
+		queue,
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			Headers:     amqp.Table(msg.Headers),
+			Timestamp:   msg.Timestamp,
+			ContentType: msg.ContentType,
+			Expiration:  expiration,
+			Body:        msg.Body,
+		},
+	)
+	if err != nil {
+		return errors.WithMessagef(err, "Publish '%s'", queue)
+	}
+
+	return nil
+}
+
+// Consume opens a channel per consumer so that prefetch
+// applies to each individually
+func (q *AMQP) Consume(queue, name string, prefetch int) (<-chan Message, error) {
+	ch, err := q.conn.Channel()
+	if err != nil {
+		return nil, errors.WithMessage(err, "subscriber.Channel")
+	}
+
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, errors.WithMessage(err, "Qos")
+	}
+
+	deliveries, err := ch.Consume(
+		queue,
+		name,
+		false, // autoack
+		false, // exclusive
+		false, // nolocal
+		false, // nowait
+		nil,
+	)
+	if err != nil {
+		ch.Close()
+		return nil, errors.WithMessage(err, "ch.Consume")
+	}
+
+	q.Lock()
+	q.consumers = append(q.consumers, ch)
+	q.Unlock()
+
+	msgs := make(chan Message)
+
+	go func() {
+		defer close(msgs)
+		for delivery := range deliveries {
+			msgs <- fromDelivery(queue, delivery)
+		}
+	}()
+
+	return msgs, nil
+}
+
+func (q *AMQP) Get(queue string) (Message, bool, error) {
+	delivery, ok, err := q.publisher.Get(queue, false)
+	if err != nil {
+		return Message{}, false, errors.WithMessagef(err, "Get '%s'", queue)
+	}
+
+	if !ok {
+		return Message{}, false, nil
+	}
+
+	return fromDelivery(queue, delivery), true, nil
+}
+
+func (q *AMQP) Purge(queue string) (int, error) {
+	count, err := q.publisher.QueuePurge(queue, false)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "QueuePurge '%s'", queue)
+	}
+
+	return count, nil
+}
+
+func (q *AMQP) Close() error {
+	q.Lock()
+	for _, ch := range q.consumers {
+		ch.Close()
+	}
+	q.consumers = nil
+	q.Unlock()
+
+	q.publisher.Close()
+
+	return q.conn.Close()
+}
+
+func fromDelivery(queue string, delivery amqp.Delivery) Message {
+	return Message{
+		Queue:       queue,
+		Headers:     Headers(delivery.Headers),
+		Timestamp:   delivery.Timestamp,
+		ContentType: delivery.ContentType,
+		Body:        delivery.Body,
+		ack: func() error {
+			return delivery.Ack(false)
+		},
+		nack: func(requeue bool) error {
+			return delivery.Nack(false, requeue)
+		},
+	}
+}
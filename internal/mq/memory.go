@@ -0,0 +1,256 @@
+package mq
+
+import (
+	"sync"
+	"time"
+)
+
+// Memory is an in process Queue, messages are lost when the
+// process exits so it is only suitable for tests and development
+type Memory struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	queues map[string]*memoryQueue
+	timers map[*time.Timer]struct{}
+
+	closed bool
+	done   chan struct{}
+}
+
+type memoryQueue struct {
+	ready []Message
+}
+
+// NewMemory creates an empty in process Queue
+func NewMemory() *Memory {
+	q := &Memory{
+		queues: make(map[string]*memoryQueue),
+		timers: make(map[*time.Timer]struct{}),
+		done:   make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.Mutex)
+
+	return q
+}
+
+func (q *Memory) Declare(queues ...string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, queue := range queues {
+		q.get(queue)
+	}
+
+	return nil
+}
+
+// get or create queue, the lock must be held
+func (q *Memory) get(queue string) *memoryQueue {
+	state, ok := q.queues[queue]
+	if !ok {
+		state = &memoryQueue{}
+		q.queues[queue] = state
+	}
+	return state
+}
+
+// Publish copies msg on to queue, queues are created on demand
+func (q *Memory) Publish(queue string, msg Message) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	headers := make(Headers, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	state := q.get(queue)
+	state.ready = append(state.ready, Message{
+		Queue:       queue,
+		Headers:     headers,
+		Timestamp:   msg.Timestamp,
+		ContentType: msg.ContentType,
+		Body:        append([]byte(nil), msg.Body...),
+	})
+
+	q.cond.Broadcast()
+
+	return nil
+}
+
+func (q *Memory) PublishDelayed(queue string, msg Message, delay time.Duration) error {
+	if delay <= 0 {
+		return q.Publish(queue, msg)
+	}
+
+	// copy now as the caller may reuse the body
+	msg.Body = append([]byte(nil), msg.Body...)
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	// the lock is held until t is set, so the timer can always
+	// remove itself once fired
+	var t *time.Timer
+	t = time.AfterFunc(delay, func() {
+		q.Lock()
+		delete(q.timers, t)
+		q.Unlock()
+
+		q.Publish(queue, msg)
+	})
+	q.timers[t] = struct{}{}
+
+	return nil
+}
+
+func (q *Memory) Consume(queue, name string, prefetch int) (<-chan Message, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return nil, ErrClosed
+	}
+
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	msgs := make(chan Message)
+	unacked := 0
+
+	go func() {
+		defer close(msgs)
+
+		for {
+			q.Lock()
+			state := q.get(queue)
+			for !q.closed && (len(state.ready) == 0 || unacked >= prefetch) {
+				q.cond.Wait()
+			}
+
+			if q.closed {
+				q.Unlock()
+				return
+			}
+
+			msg := q.take(state, func() {
+				unacked--
+			})
+			unacked++
+			q.Unlock()
+
+			select {
+			case msgs <- msg:
+			case <-q.done:
+				return
+			}
+		}
+	}()
+
+	return msgs, nil
+}
+
+func (q *Memory) Get(queue string) (Message, bool, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return Message{}, false, ErrClosed
+	}
+
+	state := q.get(queue)
+	if len(state.ready) == 0 {
+		return Message{}, false, nil
+	}
+
+	return q.take(state, func() {}), true, nil
+}
+
+// take the next ready message from state, settled is called with
+// the lock held once the message is acked or nacked
+func (q *Memory) take(state *memoryQueue, settled func()) Message {
+	msg := state.ready[0]
+	state.ready = state.ready[1:]
+
+	var once sync.Once
+
+	settle := func(requeue bool) error {
+		once.Do(func() {
+			q.Lock()
+			defer q.Unlock()
+
+			settled()
+
+			if requeue && !q.closed {
+				state.ready = append([]Message{msg}, state.ready...)
+			}
+
+			q.cond.Broadcast()
+		})
+		return nil
+	}
+
+	msg.ack = func() error {
+		return settle(false)
+	}
+	msg.nack = func(requeue bool) error {
+		return settle(requeue)
+	}
+
+	return msg
+}
+
+func (q *Memory) Purge(queue string) (int, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	state := q.get(queue)
+	count := len(state.ready)
+	state.ready = nil
+
+	return count, nil
+}
+
+// Len returns the number of ready messages on queue
+func (q *Memory) Len(queue string) int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.get(queue).ready)
+}
+
+// Close stops all consumers, pending delayed messages are dropped
+func (q *Memory) Close() error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	q.closed = true
+	close(q.done)
+
+	for t := range q.timers {
+		t.Stop()
+	}
+	q.timers = make(map[*time.Timer]struct{})
+
+	q.cond.Broadcast()
+
+	return nil
+}
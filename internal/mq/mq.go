@@ -0,0 +1,82 @@
+package mq
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrClosed is returned when using a closed Queue
+var ErrClosed = errors.New("queue closed")
+
+// Headers attached to a Message, values should be strings,
+// integers or times so they survive every implementation
+type Headers map[string]interface{}
+
+// Message is published to and consumed from a named queue
+type Message struct {
+	// the queue the message was consumed from
+	Queue string
+
+	Headers     Headers
+	Timestamp   time.Time
+	ContentType string
+	Body        []byte
+
+	// set by the implementation on consumed messages
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// Ack marks a consumed message as handled
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+// Nack marks a consumed message as failed, if requeue is set the
+// message is delivered again, otherwise it is dropped
+func (m *Message) Nack(requeue bool) error {
+	if m.nack == nil {
+		return nil
+	}
+	return m.nack(requeue)
+}
+
+// Publisher publishes messages on to named queues
+type Publisher interface {
+	Publish(queue string, msg Message) error
+
+	// PublishDelayed publishes msg on to queue once delay has
+	// passed, used to redeliver a message later without holding
+	// on to it
+	PublishDelayed(queue string, msg Message, delay time.Duration) error
+}
+
+// Consumer consumes messages from named queues
+type Consumer interface {
+	// Consume delivers messages from queue until the Consumer is
+	// closed, at most prefetch messages are unacked at a time
+	Consume(queue, name string, prefetch int) (<-chan Message, error)
+
+	// Get a single message from queue, ok is false if the queue
+	// is empty. The message must be acked or nacked
+	Get(queue string) (msg Message, ok bool, err error)
+
+	// Purge removes all ready messages from queue
+	Purge(queue string) (int, error)
+}
+
+// Queue is a message queue that can be published to and
+// consumed from
+type Queue interface {
+	Publisher
+	Consumer
+
+	// Declare makes sure queues exist
+	Declare(queues ...string) error
+
+	Close() error
+}
@@ -0,0 +1,110 @@
+// Package pgtest runs tests against a real Postgres loaded with
+// the schema in schema/*.sql. Tests are skipped unless
+// MXAX_TEST_DB_URL is set
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// schema files in the order they are loaded
+var schemaFiles = []string{"account.sql", "logger.sql"}
+
+// Connect to MXAX_TEST_DB_URL with the schema loaded in to a
+// fresh postgres schema that is dropped when the test ends. The
+// connection is as the admin user so row level security only
+// applies if the test sets it up
+func Connect(t *testing.T, url string) *pgxpool.Pool {
+	t.Helper()
+
+	if len(url) == 0 {
+		t.Skip("MXAX_TEST_DB_URL is not set")
+	}
+
+	ctx := context.Background()
+
+	rand.Seed(time.Now().UnixNano())
+	schema := fmt.Sprintf("mxax_test_%d", rand.Int31())
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("ParseConfig: %s", err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+
+	db, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("ConnectConfig: %s", err)
+	}
+
+	if _, err := db.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		db.Close()
+		t.Fatalf("CREATE SCHEMA: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if _, err := db.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("DROP SCHEMA: %s", err)
+		}
+		db.Close()
+	})
+
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Join(filepath.Dir(file), "..", "..", "schema")
+
+	for _, name := range schemaFiles {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile: %s", err)
+		}
+
+		for _, stmt := range statements(string(b)) {
+			if _, err := db.Exec(ctx, stmt); err != nil && !optional(stmt) {
+				t.Fatalf("%s: %s\n%s", name, err, stmt)
+			}
+		}
+	}
+
+	return db
+}
+
+// statements splits a schema file on the ; ending each statement,
+// comments are removed first
+func statements(sql string) []string {
+	var b strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		if idx := strings.Index(line, "--"); idx >= 0 {
+			line = line[:idx]
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(b.String(), ";") {
+		if stmt = strings.TrimSpace(stmt); len(stmt) > 0 {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	return stmts
+}
+
+// optional statements are run by psql against an existing
+// database and fail against a fresh one, or need timescale
+func optional(stmt string) bool {
+	stmt = strings.ToLower(stmt)
+	return strings.HasPrefix(stmt, "drop ") ||
+		strings.Contains(stmt, "timescaledb") ||
+		strings.Contains(stmt, "create_hypertable")
+}
@@ -4,12 +4,25 @@ import (
 	"bytes"
 	"encoding/json"
 	"log"
+	"net/textproto"
 	"time"
 
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/smtp"
-	"github.com/streadway/amqp"
+	"github.com/pkg/errors"
 )
 
+// IsPermanent checks if err is a 5xx reply, meaning the
+// destination will never accept the email
+func IsPermanent(err error) bool {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return false
+	}
+
+	return protoErr.Code >= 500
+}
+
 func (s *Sender) publishBounce(email *smtp.Email) {
 	b := s.bufferPool.Get().(*bytes.Buffer)
 	defer s.bufferPool.Put(b)
@@ -20,19 +33,13 @@ func (s *Sender) publishBounce(email *smtp.Email) {
 		return
 	}
 
-	msg := amqp.Publishing{
+	msg := mq.Message{
 		Timestamp:   time.Now(),
 		ContentType: "application/json",
 		Body:        b.Bytes(),
 	}
 
-	err := s.publisher.Publish(
-		"",
-		"bounces",
-		false, // mandatory
-		false, // immediate
-		msg,
-	)
+	err := s.publisher.Publish("bounces", msg)
 
 	if err != nil {
 		log.Printf("Error publish bounce: %s", err)
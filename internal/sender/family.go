@@ -0,0 +1,157 @@
+package sender
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// address families an IP can send over
+const (
+	FamilyIPv4 = 4
+	FamilyIPv6 = 6
+)
+
+// Families is an ordered preference of address families, the
+// first is tried first and the rest are fallbacks
+type Families []int
+
+// ParseFamilies parses a comma separated preference i.e. 6,4
+func ParseFamilies(s string) (Families, error) {
+	var families Families
+
+	for _, part := range strings.Split(s, ",") {
+		switch strings.TrimSpace(part) {
+		case "4":
+			families = append(families, FamilyIPv4)
+		case "6":
+			families = append(families, FamilyIPv6)
+		default:
+			return nil, errors.Errorf("bad family: '%s'", part)
+		}
+	}
+
+	return families, nil
+}
+
+// ParseFamilyPreference parses a preference for a provider in the
+// form of provider=families i.e. google.com=6,4
+func ParseFamilyPreference(s string) (string, Families, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, errors.Errorf("bad family preference: '%s'", s)
+	}
+
+	families, err := ParseFamilies(parts[1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return strings.ToLower(parts[0]), families, nil
+}
+
+// After returns the families that are fallbacks for family
+func (f Families) After(family int) Families {
+	for idx := range f {
+		if f[idx] == family {
+			return f[idx+1:]
+		}
+	}
+	return nil
+}
+
+// FamilyOf returns the address family of ip
+func FamilyOf(ip net.IP) int {
+	if ip.To4() != nil {
+		return FamilyIPv4
+	}
+	return FamilyIPv6
+}
+
+// FamilyPreferences picks the order in which address families are
+// tried for a destination. A provider is matched the same way as
+// the throttle, by destination domain or primary MX suffix
+type FamilyPreferences struct {
+	defaults  Families
+	providers map[string]Families
+}
+
+func NewFamilyPreferences(defaults Families, providers map[string]Families) *FamilyPreferences {
+	if providers == nil {
+		providers = make(map[string]Families)
+	}
+
+	return &FamilyPreferences{
+		defaults:  defaults,
+		providers: providers,
+	}
+}
+
+// Get the preferred families for domain
+func (fp *FamilyPreferences) Get(domain string, mxs []*net.MX) Families {
+	domain = strings.ToLower(domain)
+
+	if families, ok := fp.providers[domain]; ok {
+		return families
+	}
+
+	if len(mxs) > 0 {
+		host := strings.ToLower(strings.TrimSuffix(mxs[0].Host, "."))
+		for key, families := range fp.providers {
+			if host == key || strings.HasSuffix(host, "."+key) {
+				return families
+			}
+		}
+	}
+
+	return fp.defaults
+}
+
+// destinationFamilies filters preferred down to the families the
+// destination MXs can actually be reached over
+func (s *Sender) destinationFamilies(preferred Families, mxs []*net.MX) Families {
+	reachable := make(map[int]bool)
+
+	for _, mx := range mxs {
+		for _, ip := range s.getMXIPs(mx.Host) {
+			reachable[FamilyOf(ip)] = true
+		}
+	}
+
+	var families Families
+	for _, family := range preferred {
+		if reachable[family] {
+			families = append(families, family)
+		}
+	}
+
+	return families
+}
+
+// cached lookup of A and AAAA records for an MX host
+func (s *Sender) getMXIPs(host string) []net.IP {
+	if ips, ok := s.cache.Get("mxips", host); ok {
+		return ips.([]net.IP)
+	}
+
+	ips, err := s.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+
+	s.cache.Set("mxips", host, ips)
+
+	return ips
+}
+
+// isDialError checks if err happened while connecting, rather
+// than as a reply from the destination
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+
+	return opErr.Op == "dial"
+}
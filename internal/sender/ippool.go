@@ -0,0 +1,360 @@
+package sender
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultIPPool is used for any email without a pool or
+// with a pool that does not exist
+const DefaultIPPool = "default"
+
+// ErrNoIP is returned when every ip in a pool is either out
+// of rotation or has hit its daily cap
+var ErrNoIP = errors.New("no ip available")
+
+// IP is an outbound source address and the rdns it
+// identifies itself as
+type IP struct {
+	Addr   string
+	Rdns   string
+	Dialer net.Dialer
+
+	// FamilyIPv4 or FamilyIPv6
+	Family int
+
+	// when this ip started warming up, zero if it is warm
+	WarmupStart time.Time
+
+	// sends for the current day, UTC, only used when the
+	// pools have no Counter
+	day  time.Time
+	sent int
+
+	// outcomes since windowStart, used to pull the
+	// ip out of rotation
+	windowStart time.Time
+	attempts    int
+	bounces     int
+	deferrals   int
+
+	// out of rotation until
+	disabledUntil time.Time
+}
+
+func (ip *IP) printf(format string, args ...interface{}) {
+	format = fmt.Sprintf("%s :: %s", ip.Rdns, format)
+	log.Printf(format, args...)
+}
+
+// Warmup is a schedule of daily send caps, one for each week
+// since an ip started warming up. Once an ip has been through
+// the schedule it is no longer capped
+type Warmup []int
+
+// ParseWarmup parses a comma separated list of daily caps
+// i.e. 50,200,1000,5000
+func ParseWarmup(s string) (Warmup, error) {
+	var warmup Warmup
+
+	if len(s) == 0 {
+		return warmup, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		c, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "cap '%s'", part)
+		}
+
+		if c < 1 {
+			return nil, errors.Errorf("cap must be at least 1: '%s'", part)
+		}
+
+		warmup = append(warmup, c)
+	}
+
+	return warmup, nil
+}
+
+// Cap returns the daily cap for an ip that started warming up
+// at start, or -1 if it is uncapped
+func (w Warmup) Cap(start, now time.Time) int {
+	if start.IsZero() || len(w) == 0 {
+		return -1
+	}
+
+	week := int(now.Sub(start) / (time.Hour * 24 * 7))
+	if week < 0 {
+		week = 0
+	}
+
+	if week >= len(w) {
+		return -1
+	}
+
+	return w[week]
+}
+
+// Health controls when an ip is pulled out of rotation
+type Health struct {
+	// rates over a window that pull an ip, 0 disables
+	MaxBounceRate   float64
+	MaxDeferralRate float64
+
+	// minimum attempts in a window before rates are checked
+	MinAttempts int
+
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// IPPools holds named pools of outbound ips and picks which
+// ip an email is sent from
+type IPPools struct {
+	sync.Mutex
+
+	pools map[string][]*IP
+
+	// round robin position per pool
+	next map[string]int
+
+	warmup Warmup
+	health Health
+
+	// shared daily sends of ips that are warming up, when nil
+	// they are counted per process. Replaceable before use
+	Counter WarmupCounter
+}
+
+func NewIPPools(warmup Warmup, health Health) *IPPools {
+	return &IPPools{
+		pools:  make(map[string][]*IP),
+		next:   make(map[string]int),
+		warmup: warmup,
+		health: health,
+	}
+}
+
+// Add ip to the named pool
+func (p *IPPools) Add(pool string, ip *IP) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.pools[pool] = append(p.pools[pool], ip)
+}
+
+// Pick the next ip in the pool that is in rotation and under its
+// daily cap, count is the number of recipients being sent to and
+// is reserved against the cap until it is refunded. Ips are tried
+// in order of families, if none are given any family will do
+func (p *IPPools) Pick(pool string, count int, families Families) (*IP, error) {
+	if len(families) == 0 {
+		return p.pick(pool, count, 0)
+	}
+
+	for _, family := range families {
+		if ip, err := p.pick(pool, count, family); err == nil {
+			return ip, nil
+		}
+	}
+
+	return nil, ErrNoIP
+}
+
+// candidate is an ip that is in rotation along with its
+// position in the pool and daily cap, -1 if uncapped
+type candidate struct {
+	ip  *IP
+	idx int
+	c   int
+}
+
+// candidates of family from pool in round robin order, 0
+// matches any family
+func (p *IPPools) candidates(pool string, family int, now time.Time) (string, []candidate) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.pools[pool]; !ok {
+		pool = DefaultIPPool
+	}
+
+	ips := p.pools[pool]
+
+	var candidates []candidate
+	for i := range ips {
+		idx := (p.next[pool] + i) % len(ips)
+		ip := ips[idx]
+
+		if family > 0 && ip.Family != family {
+			continue
+		}
+
+		if now.Before(ip.disabledUntil) {
+			continue
+		}
+
+		candidates = append(candidates, candidate{ip, idx, p.warmup.Cap(ip.WarmupStart, now)})
+	}
+
+	return pool, candidates
+}
+
+// pick an ip of family from pool, reservations are made without
+// holding the lock as the Counter may be slow
+func (p *IPPools) pick(pool string, count, family int) (*IP, error) {
+	now := time.Now()
+	today := now.UTC().Truncate(time.Hour * 24)
+
+	pool, candidates := p.candidates(pool, family, now)
+
+	for _, c := range candidates {
+		if c.c >= 0 && !p.reserve(c.ip, today, count, c.c) {
+			continue
+		}
+
+		p.Lock()
+		p.next[pool] = c.idx + 1
+		p.Unlock()
+
+		return c.ip, nil
+	}
+
+	return nil, ErrNoIP
+}
+
+// reserve count sends from ip against its daily cap c
+func (p *IPPools) reserve(ip *IP, today time.Time, count, c int) bool {
+	if p.Counter != nil {
+		ok, err := p.Counter.Reserve(ip.Addr, today, count, c)
+		if err != nil {
+			// hold back rather than risk going over the cap
+			ip.printf("WARMUP :: %s :: Reserve: %s", ip.Addr, err)
+			return false
+		}
+		return ok
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if !ip.day.Equal(today) {
+		ip.day = today
+		ip.sent = 0
+	}
+
+	if ip.sent+count > c {
+		return false
+	}
+
+	ip.sent += count
+
+	return true
+}
+
+// Refund count sends reserved by Pick that were not made, i.e.
+// they were deferred or sent from another ip. Refunds are against
+// the current day
+func (p *IPPools) Refund(ip *IP, count int) {
+	now := time.Now()
+
+	if p.warmup.Cap(ip.WarmupStart, now) < 0 || count < 1 {
+		return
+	}
+
+	today := now.UTC().Truncate(time.Hour * 24)
+
+	if p.Counter != nil {
+		if err := p.Counter.Refund(ip.Addr, today, count); err != nil {
+			ip.printf("WARMUP :: %s :: Refund: %s", ip.Addr, err)
+		}
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if !ip.day.Equal(today) {
+		return
+	}
+
+	ip.sent -= count
+	if ip.sent < 0 {
+		ip.sent = 0
+	}
+}
+
+// Record the outcome of delivering to a single recipient from ip.
+// Permanent failures count as bounces and temporary failures as
+// deferrals, anything other than a reply is ignored
+func (p *IPPools) Record(ip *IP, err error) {
+	var code int
+	if err != nil {
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) {
+			return
+		}
+		code = protoErr.Code
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(ip.windowStart) > p.health.Window {
+		ip.windowStart = now
+		ip.attempts = 0
+		ip.bounces = 0
+		ip.deferrals = 0
+	}
+
+	ip.attempts++
+
+	switch {
+	case code >= 500:
+		ip.bounces++
+	case code >= 400:
+		ip.deferrals++
+	}
+
+	if ip.attempts < p.health.MinAttempts {
+		return
+	}
+
+	bounceRate := float64(ip.bounces) / float64(ip.attempts)
+	deferralRate := float64(ip.deferrals) / float64(ip.attempts)
+
+	bounced := p.health.MaxBounceRate > 0 && bounceRate > p.health.MaxBounceRate
+	deferred := p.health.MaxDeferralRate > 0 && deferralRate > p.health.MaxDeferralRate
+
+	if !bounced && !deferred {
+		return
+	}
+
+	ip.disabledUntil = now.Add(p.health.Cooldown)
+
+	ip.printf(
+		"OUT OF ROTATION :: %s until %s [bounce rate: %.2f] [deferral rate: %.2f] [attempts: %d]",
+		ip.Addr,
+		ip.disabledUntil.Format(time.RFC3339),
+		bounceRate,
+		deferralRate,
+		ip.attempts,
+	)
+
+	// start afresh when it comes back
+	ip.windowStart = ip.disabledUntil
+	ip.attempts = 0
+	ip.bounces = 0
+	ip.deferrals = 0
+}
@@ -0,0 +1,160 @@
+package sender
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseWarmup(t *testing.T) {
+	good := map[string]Warmup{
+		"":            nil,
+		"50,200,1000": {50, 200, 1000},
+		" 50, 200 ":   {50, 200},
+	}
+
+	for s, want := range good {
+		got, err := ParseWarmup(s)
+		if err != nil {
+			t.Errorf("'%s': %s", s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("'%s': expected %v, got %v", s, want, got)
+		}
+	}
+
+	for _, s := range []string{"50,0", "50,lots", "50,,200"} {
+		if _, err := ParseWarmup(s); err == nil {
+			t.Errorf("'%s': expected an error", s)
+		}
+	}
+}
+
+func TestWarmupCap(t *testing.T) {
+	warmup := Warmup{50, 200}
+	now := time.Date(2020, 11, 15, 12, 0, 0, 0, time.UTC)
+	week := time.Hour * 24 * 7
+
+	for _, tc := range []struct {
+		name   string
+		warmup Warmup
+		start  time.Time
+		want   int
+	}{
+		{"warm", warmup, time.Time{}, -1},
+		{"no schedule", nil, now, -1},
+		{"first week", warmup, now.Add(-time.Hour), 50},
+		{"second week", warmup, now.Add(-week - time.Hour), 200},
+		{"through the schedule", warmup, now.Add(-week * 2), -1},
+		{"starts later", warmup, now.Add(week), 50},
+	} {
+		if got := tc.warmup.Cap(tc.start, now); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+// counts reservations in memory like the db would
+type fakeWarmupCounter struct {
+	sent map[string]int
+	err  error
+
+	// set if the pools were locked during a reservation
+	pools  *IPPools
+	locked bool
+}
+
+func (f *fakeWarmupCounter) Reserve(addr string, day time.Time, count, c int) (bool, error) {
+	if f.pools != nil {
+		if f.pools.TryLock() {
+			f.pools.Unlock()
+		} else {
+			f.locked = true
+		}
+	}
+
+	if f.err != nil {
+		return false, f.err
+	}
+
+	key := addr + day.Format("2006-01-02")
+	if f.sent[key]+count > c {
+		return false, nil
+	}
+
+	f.sent[key] += count
+	return true, nil
+}
+
+func (f *fakeWarmupCounter) Refund(addr string, day time.Time, count int) error {
+	f.sent[addr+day.Format("2006-01-02")] -= count
+	return nil
+}
+
+func TestIPPoolsWarmup(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		counter WarmupCounter
+		// recipients of each pick and if an ip is expected
+		counts []int
+		want   []bool
+	}{
+		{"in memory", nil, []int{6, 4, 1}, []bool{true, true, false}},
+		{"over the cap", nil, []int{8, 3, 2}, []bool{true, false, true}},
+		{"shared", &fakeWarmupCounter{sent: map[string]int{}}, []int{6, 4, 1}, []bool{true, true, false}},
+		{"counter error", &fakeWarmupCounter{err: errors.New("db down")}, []int{1}, []bool{false}},
+	} {
+		pools := NewIPPools(Warmup{10}, Health{})
+		pools.Counter = tc.counter
+		pools.Add(DefaultIPPool, &IP{Addr: "192.0.2.1", WarmupStart: time.Now()})
+
+		for idx, count := range tc.counts {
+			_, err := pools.Pick(DefaultIPPool, count, nil)
+			if got := err == nil; got != tc.want[idx] {
+				t.Errorf("%s: %d: expected an ip %t, got %v", tc.name, idx, tc.want[idx], err)
+			}
+		}
+	}
+}
+
+func TestIPPoolsRefund(t *testing.T) {
+	for _, counter := range []WarmupCounter{nil, &fakeWarmupCounter{sent: map[string]int{}}} {
+		pools := NewIPPools(Warmup{10}, Health{})
+		pools.Counter = counter
+		pools.Add(DefaultIPPool, &IP{Addr: "192.0.2.1", WarmupStart: time.Now()})
+
+		ip, err := pools.Pick(DefaultIPPool, 10, nil)
+		if err != nil {
+			t.Fatalf("%T: %s", counter, err)
+		}
+
+		if _, err := pools.Pick(DefaultIPPool, 1, nil); err != ErrNoIP {
+			t.Fatalf("%T: expected the cap to be reached, got %v", counter, err)
+		}
+
+		// deferred sends can go out again today
+		pools.Refund(ip, 4)
+
+		if _, err := pools.Pick(DefaultIPPool, 4, nil); err != nil {
+			t.Errorf("%T: expected the refund to be available, got %v", counter, err)
+		}
+	}
+}
+
+func TestIPPoolsReserveUnlocked(t *testing.T) {
+	pools := NewIPPools(Warmup{10}, Health{})
+	counter := &fakeWarmupCounter{sent: map[string]int{}, pools: pools}
+	pools.Counter = counter
+	pools.Add(DefaultIPPool, &IP{Addr: "192.0.2.1", WarmupStart: time.Now()})
+
+	if _, err := pools.Pick(DefaultIPPool, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if counter.locked {
+		t.Fatal("expected the pools to be unlocked while reserving")
+	}
+}
@@ -0,0 +1,159 @@
+package sender
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/smtp"
+	"github.com/pkg/errors"
+)
+
+// DefaultLaneWeights are used for lanes without their own weight
+var DefaultLaneWeights = map[smtp.Priority]int{
+	smtp.PrioritySystem:     8,
+	smtp.PrioritySubmission: 4,
+	smtp.PriorityRelay:      1,
+}
+
+// Lane is a queue of emails of a single priority, lanes are
+// consumed in proportion to their weight
+type Lane struct {
+	Priority smtp.Priority
+	Weight   int
+	Messages <-chan mq.Message
+}
+
+// ParseLaneWeight parses a weight in the form of priority=weight
+// i.e. system=8
+func ParseLaneWeight(s string) (smtp.Priority, int, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("bad lane weight: '%s'", s)
+	}
+
+	priority, err := smtp.ParsePriority(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	weight, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, errors.WithMessagef(err, "weight '%s'", parts[1])
+	}
+
+	if weight < 1 {
+		return 0, 0, errors.Errorf("weight must be at least 1: '%s'", s)
+	}
+
+	return priority, weight, nil
+}
+
+// lanes picks which lane to take the next email from using
+// smooth weighted round robin, lanes with nothing waiting are
+// skipped so a quiet lane never holds up the others
+type lanes struct {
+	lanes   []Lane
+	current []int
+	total   int
+
+	// used to block on every lane when none are ready
+	cases []reflect.SelectCase
+}
+
+func newLanes(l []Lane) *lanes {
+	ls := &lanes{
+		lanes:   l,
+		current: make([]int, len(l)),
+		cases:   make([]reflect.SelectCase, len(l)+1),
+	}
+
+	for idx := range l {
+		if ls.lanes[idx].Weight < 1 {
+			ls.lanes[idx].Weight = 1
+		}
+		if l[idx].Messages != nil {
+			ls.total += ls.lanes[idx].Weight
+		}
+
+		ls.cases[idx+1] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(l[idx].Messages),
+		}
+	}
+
+	return ls
+}
+
+// next blocks until an email is available, false is returned
+// once every lane has closed
+func (ls *lanes) next(ctx context.Context) (mq.Message, bool, error) {
+	order := make([]int, 0, len(ls.lanes))
+	for idx := range ls.lanes {
+		if ls.lanes[idx].Messages == nil {
+			continue
+		}
+		ls.current[idx] += ls.lanes[idx].Weight
+		order = append(order, idx)
+	}
+
+	if len(order) == 0 {
+		return mq.Message{}, false, nil
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return ls.current[order[i]] > ls.current[order[j]]
+	})
+
+	for {
+		// take from the most owed lane that has something waiting
+		for _, idx := range order {
+			select {
+			case msg, ok := <-ls.lanes[idx].Messages:
+				if !ok {
+					ls.close(idx)
+					continue
+				}
+				ls.current[idx] -= ls.total
+				return msg, true, nil
+			default:
+			}
+		}
+
+		if ls.total == 0 {
+			return mq.Message{}, false, nil
+		}
+
+		// nothing is waiting, take whatever arrives first
+		ls.cases[0] = reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(ctx.Done()),
+		}
+
+		chosen, value, ok := reflect.Select(ls.cases)
+		if chosen == 0 {
+			return mq.Message{}, false, ctx.Err()
+		}
+
+		idx := chosen - 1
+
+		if !ok {
+			ls.close(idx)
+			continue
+		}
+
+		ls.current[idx] -= ls.total
+		return value.Interface().(mq.Message), true, nil
+	}
+}
+
+// close stops a lane from being picked
+func (ls *lanes) close(idx int) {
+	ls.total -= ls.lanes[idx].Weight
+	ls.current[idx] = 0
+	ls.lanes[idx].Messages = nil
+	ls.cases[idx+1].Chan = reflect.ValueOf((<-chan mq.Message)(nil))
+}
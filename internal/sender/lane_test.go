@@ -0,0 +1,118 @@
+package sender
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/smtp"
+)
+
+func TestParseLaneWeight(t *testing.T) {
+	priority, weight, err := ParseLaneWeight("system=8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if priority != smtp.PrioritySystem || weight != 8 {
+		t.Fatalf("expected system=8, got %s=%d", priority, weight)
+	}
+
+	if priority, _, _ := ParseLaneWeight("submission=2"); priority != smtp.PrioritySubmission {
+		t.Errorf("expected submission, got %s", priority)
+	}
+
+	if priority, _, _ := ParseLaneWeight("relay=1"); priority != smtp.PriorityRelay {
+		t.Errorf("expected relay, got %s", priority)
+	}
+
+	for _, s := range []string{"relay=0", "relay=many", "bulk=1", "relay"} {
+		if _, _, err := ParseLaneWeight(s); err == nil {
+			t.Errorf("'%s': expected an error", s)
+		}
+	}
+}
+
+// fill a lane with n messages, each body is the lane's priority
+func fillLane(priority smtp.Priority, weight, n int) Lane {
+	ch := make(chan mq.Message, n)
+	for i := 0; i < n; i++ {
+		ch <- mq.Message{Body: []byte(priority.String())}
+	}
+
+	return Lane{Priority: priority, Weight: weight, Messages: ch}
+}
+
+func TestLanesWeighting(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		lanes []Lane
+		take  int
+		want  map[string]int
+	}{
+		{
+			"weighted",
+			[]Lane{
+				fillLane(smtp.PrioritySystem, 8, 100),
+				fillLane(smtp.PrioritySubmission, 4, 100),
+				fillLane(smtp.PriorityRelay, 1, 100),
+			},
+			26,
+			map[string]int{"system": 16, "submission": 8, "relay": 2},
+		},
+		{
+			"quiet lane is skipped",
+			[]Lane{
+				fillLane(smtp.PrioritySystem, 8, 0),
+				fillLane(smtp.PriorityRelay, 1, 10),
+			},
+			10,
+			map[string]int{"relay": 10},
+		},
+		{
+			"empty lane is drained",
+			[]Lane{
+				fillLane(smtp.PrioritySystem, 8, 2),
+				fillLane(smtp.PriorityRelay, 1, 10),
+			},
+			10,
+			map[string]int{"system": 2, "relay": 8},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ls := newLanes(tc.lanes)
+
+			got := make(map[string]int)
+			for i := 0; i < tc.take; i++ {
+				msg, ok, err := ls.next(context.Background())
+				if err != nil || !ok {
+					t.Fatalf("next: %t %v", ok, err)
+				}
+				got[string(msg.Body)]++
+			}
+
+			for priority, n := range tc.want {
+				if got[priority] != n {
+					t.Errorf("expected %d from %s, got %v", n, priority, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLanesClosed(t *testing.T) {
+	ch := make(chan mq.Message, 1)
+	ch <- mq.Message{}
+	close(ch)
+
+	ls := newLanes([]Lane{{Priority: smtp.PriorityRelay, Weight: 1, Messages: ch}})
+
+	if _, ok, err := ls.next(context.Background()); !ok || err != nil {
+		t.Fatalf("expected the buffered message, got %t %v", ok, err)
+	}
+
+	if _, ok, err := ls.next(context.Background()); ok || err != nil {
+		t.Fatalf("expected every lane to be closed, got %t %v", ok, err)
+	}
+}
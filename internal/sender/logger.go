@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/jawr/mxax/internal/logger"
-	"github.com/streadway/amqp"
+	"github.com/jawr/mxax/internal/mq"
 )
 
 func (s *Sender) publishLogEntry(entry logger.Entry) {
@@ -22,19 +22,13 @@ func (s *Sender) publishLogEntry(entry logger.Entry) {
 		return
 	}
 
-	msg := amqp.Publishing{
+	msg := mq.Message{
 		Timestamp:   time.Now(),
 		ContentType: "application/json",
 		Body:        b.Bytes(),
 	}
 
-	err := s.publisher.Publish(
-		"",
-		"logs",
-		false, // mandatory
-		false, // immediate
-		msg,
-	)
+	err := s.publisher.Publish("logs", msg)
 	if err != nil {
 		log.Printf("Error publish entry: %s", err)
 		return
@@ -0,0 +1,217 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	smtpclient "github.com/jawr/mxax/internal/smtp/client"
+	"github.com/pkg/errors"
+)
+
+// session is an established, greeted and if possible
+// encrypted connection to a destination MX
+type session struct {
+	key string
+
+	conn   net.Conn
+	client *smtpclient.Client
+
+	pipelining bool
+
+	// number of messages sent over this session
+	messages int
+	lastUsed time.Time
+}
+
+// send a message to one or more recipients in a single transaction,
+// using pipelining if supported. The reply to each RCPT is returned
+// in order. If the transaction itself fails err is non-nil
+func (ss *session) send(from string, to []string, message []byte) (string, []error, error) {
+	if err := ss.conn.SetDeadline(time.Now().Add(SEND_DEADLINE)); err != nil {
+		return "", nil, errors.WithMessage(err, "SetDeadline")
+	}
+
+	var wc *smtpclient.DataCloser
+	var rcptErrs []error
+
+	if ss.pipelining {
+		errs, w, err := ss.client.Pipeline(from, to)
+		if err != nil {
+			return "", errs, errors.WithMessage(err, "Pipeline")
+		}
+		rcptErrs = errs
+		wc = w
+
+	} else {
+		if err := ss.client.Mail(from); err != nil {
+			return "", nil, errors.WithMessage(err, "Mail")
+		}
+
+		rcptErrs = make([]error, len(to))
+
+		var accepted int
+		for idx, rcpt := range to {
+			rcptErrs[idx] = ss.client.Rcpt(rcpt)
+			if rcptErrs[idx] == nil {
+				accepted++
+			}
+		}
+
+		if accepted == 0 {
+			return "", rcptErrs, errors.WithMessage(rcptErrs[0], "Rcpt")
+		}
+
+		w, err := ss.client.Data()
+		if err != nil {
+			return "", rcptErrs, errors.WithMessage(err, "Data")
+		}
+		wc = w
+	}
+
+	if _, err := wc.Write(message); err != nil {
+		return "", rcptErrs, errors.WithMessage(err, "Write")
+	}
+
+	_, reply, err := wc.Close()
+	if err != nil {
+		return "", rcptErrs, err
+	}
+
+	ss.messages++
+	ss.lastUsed = time.Now()
+
+	return reply, rcptErrs, nil
+}
+
+// close politely, errors are ignored as the session
+// is being discarded
+func (ss *session) close() {
+	ss.conn.SetDeadline(time.Now().Add(time.Second * 5))
+	if err := ss.client.Quit(); err != nil {
+		ss.client.Close()
+	}
+}
+
+// Pool keeps idle sessions per destination MX and source IP so
+// that consecutive emails to the same provider avoid the cost of
+// dialing, greeting and negotiating TLS
+type Pool struct {
+	sync.Mutex
+
+	// how long a session can sit idle before being closed
+	maxIdle time.Duration
+	// maximum messages sent over a single session
+	maxMessages int
+
+	idle map[string][]*session
+}
+
+func NewPool(maxIdle time.Duration, maxMessages int) *Pool {
+	return &Pool{
+		maxIdle:     maxIdle,
+		maxMessages: maxMessages,
+		idle:        make(map[string][]*session),
+	}
+}
+
+func poolKey(host string, dialer net.Dialer) string {
+	var local string
+	if dialer.LocalAddr != nil {
+		local = dialer.LocalAddr.String()
+	}
+	return local + ">" + host
+}
+
+// Get returns an idle session for key that has been RSET and is
+// ready for a new transaction, or nil if there are none
+func (p *Pool) Get(key string) *session {
+	for {
+		p.Lock()
+		all := p.idle[key]
+		if len(all) == 0 {
+			p.Unlock()
+			return nil
+		}
+
+		// take the most recently used
+		ss := all[len(all)-1]
+		p.idle[key] = all[:len(all)-1]
+		p.Unlock()
+
+		if time.Since(ss.lastUsed) > p.maxIdle {
+			go ss.close()
+			continue
+		}
+
+		// make sure the session is still alive and clean
+		ss.conn.SetDeadline(time.Now().Add(SEND_DEADLINE))
+		if err := ss.client.Reset(); err != nil {
+			ss.client.Close()
+			continue
+		}
+
+		return ss
+	}
+}
+
+// Put returns a session to the pool after a successful
+// transaction, closing it if it has reached its limits
+func (p *Pool) Put(ss *session) {
+	if p.maxIdle <= 0 || ss.messages >= p.maxMessages {
+		go ss.close()
+		return
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.idle[ss.key] = append(p.idle[ss.key], ss)
+}
+
+// Run closes sessions that have been idle for too long and
+// closes all sessions when ctx is done
+func (p *Pool) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.reap(0)
+			return ctx.Err()
+
+		case <-ticker.C:
+			p.reap(p.maxIdle)
+		}
+	}
+}
+
+// close any sessions idle longer than maxIdle
+func (p *Pool) reap(maxIdle time.Duration) {
+	var expired []*session
+
+	p.Lock()
+	for key, all := range p.idle {
+		keep := all[:0]
+		for _, ss := range all {
+			if time.Since(ss.lastUsed) >= maxIdle {
+				expired = append(expired, ss)
+				continue
+			}
+			keep = append(keep, ss)
+		}
+
+		if len(keep) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = keep
+		}
+	}
+	p.Unlock()
+
+	for _, ss := range expired {
+		ss.close()
+	}
+}
@@ -0,0 +1,36 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/smtp"
+)
+
+// publishRequeue puts an email back on its queue, used when only
+// some of its recipients were deferred
+func (s *Sender) publishRequeue(email *smtp.Email, delay time.Duration) {
+	b := s.bufferPool.Get().(*bytes.Buffer)
+	defer s.bufferPool.Put(b)
+	b.Reset()
+
+	if err := json.NewEncoder(b).Encode(email); err != nil {
+		log.Printf("Error publish requeue encode: %s", err)
+		return
+	}
+
+	msg := mq.Message{
+		Timestamp:   time.Now(),
+		ContentType: "application/json",
+		Body:        b.Bytes(),
+	}
+
+	err := s.publisher.PublishDelayed(email.QueueLevel.Lane(email.Priority), msg, delay)
+	if err != nil {
+		log.Printf("Error publish requeue: %s", err)
+		return
+	}
+}
@@ -3,16 +3,25 @@ package sender
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
-	"net"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jawr/mxax/internal/deadletter"
 	"github.com/jawr/mxax/internal/logger"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/smtp"
+	"github.com/pkg/errors"
 )
 
-func (s *Sender) Run(ctx context.Context, dialer net.Dialer, rdns string) error {
+type printfFn func(format string, args ...interface{})
+
+// how long to wait before redelivering an email when no ip
+// is available
+const noIPDelay = time.Second * 30
+
+func (s *Sender) Run(ctx context.Context) error {
 
 	select {
 	case <-ctx.Done():
@@ -20,99 +29,320 @@ func (s *Sender) Run(ctx context.Context, dialer net.Dialer, rdns string) error
 	case <-s.wait:
 	}
 
-	printf := func(format string, args ...interface{}) {
-		format = fmt.Sprintf("%s :: %s", rdns, format)
-		log.Printf(format, args...)
-	}
+	log.Println("Start")
+
+	// deliveries run concurrently, bounded by Concurrency and
+	// paced per provider by the throttle. A slot is taken before
+	// picking a lane so that busy lanes queue up by weight
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
-	printf("Start")
+	concurrency := s.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
-	// TODO
-	// temp pace our deliveries, this will need much better logic
-	// to handle bounces
-	tick := time.Tick(time.Minute)
+	slots := make(chan struct{}, concurrency)
 
-	// reused variables that allow us to goto
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case slots <- struct{}{}:
+		}
 
-		case msg := <-s.emailSubscriber:
-			start := time.Now()
+		msg, ok, err := s.lanes.next(ctx)
+		if err != nil {
+			return err
+		}
 
-			email := s.emailPool.Get().(*smtp.Email)
-			email.Reset()
+		if !ok {
+			return errors.New("all lanes closed")
+		}
 
-			if err := json.Unmarshal(msg.Body, email); err != nil {
-				printf("Failed to unmarshal msg: %s", err)
-				// probably better to deadletter this
-				msg.Ack(false)
-				continue
-			}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.deliver(ctx, msg)
+		}()
+	}
+}
 
-			printf(
-				"TRY :: %s (%s -> %s -> %s)",
-				email.ID,
-				email.From,
-				email.Via,
-				email.To,
-			)
+func (s *Sender) deliver(ctx context.Context, msg mq.Message) {
+	start := time.Now()
 
-			email.Status, email.Error = s.sendEmail(rdns, dialer, email)
-			if email.Error != nil {
-				email.Status = email.Error.Error()
-				email.Etype = logger.EntryTypeBounce
+	email := s.emailPool.Get().(*smtp.Email)
+	defer s.emailPool.Put(email)
+	email.Reset()
 
-				s.publishBounce(email)
-			}
+	if err := json.Unmarshal(msg.Body, email); err != nil {
+		log.Printf("Failed to unmarshal msg: %s", err)
 
-			printf(
-				"%s :: %s (%s -> %s -> %s) [%s] [status: %s] [bounce: %s]",
-				email.Etype.String(),
-				email.ID,
-				email.From,
-				email.Via,
-				email.To,
-				time.Since(start),
-				email.Status,
-				email.Bounce,
-			)
-
-			entry := logger.Entry{
-				ID:            email.ID,
-				AccountID:     email.AccountID,
-				DomainID:      email.DomainID,
-				AliasID:       email.AliasID,
-				DestinationID: email.DestinationID,
-				FromEmail:     email.From,
-				ViaEmail:      email.Via,
-				ToEmail:       email.To,
-				Status:        email.Status,
-				Etype:         email.Etype,
-				QueueLevel:    int(email.QueueLevel),
-			}
+		err = deadletter.Poison(errors.WithMessage(err, "Unmarshal"))
+		if err := deadletter.Fail(s.publisher, msg.Queue, &msg, err); err != nil {
+			log.Printf("Failed to dead letter msg: %s", err)
+		}
+		return
+	}
 
-			if entry.Etype != logger.EntryTypeSend {
-				entry.Message = email.Message
-			}
+	if s.Sink != nil {
+		s.deliverSink(msg, email, start)
+		return
+	}
+
+	recipients := email.AllRecipients()
+
+	// work out who we are pacing against and which address
+	// families we can reach them over
+	key := email.To
+	var families Families
+	if parts := strings.Split(email.To, "@"); len(parts) == 2 {
+		mxs, _ := s.getDestinationMXs(parts[1])
+		key = s.throttle.Provider(parts[1], mxs)
+		families = s.destinationFamilies(s.families.Get(parts[1], mxs), mxs)
+	}
 
-			s.publishLogEntry(entry)
+	// pick the ip to send from, if the pool is exhausted have
+	// the email redelivered once it has had a chance to recover
+	ip, err := s.ips.Pick(email.IPPool, len(recipients), families)
+	if err != nil {
+		log.Printf("HOLD :: %s [pool: %s] [error: %s]", email.ID, email.IPPool, err)
 
-			if err := msg.Ack(false); err != nil {
-				printf("ERR :: %s :: ACK ERROR: %s", email.ID, err)
+		if err := s.publisher.PublishDelayed(msg.Queue, msg, noIPDelay); err != nil {
+			log.Printf("HOLD :: %s :: PublishDelayed: %s", email.ID, err)
+			msg.Nack(true)
+			return
+		}
+
+		msg.Ack()
+		return
+	}
+	var printf printfFn = ip.printf
+
+	if err := s.throttle.Acquire(ctx, key, email.Priority); err != nil {
+		s.ips.Refund(ip, len(recipients))
+		msg.Nack(true)
+		return
+	}
+
+	printf(
+		"TRY :: %s (%s -> %s -> %s) [recipients: %d] [provider: %s]",
+		email.ID,
+		email.From,
+		email.Via,
+		email.To,
+		len(recipients),
+		key,
+	)
+
+	reply, rcptErrs, err := s.sendEmail(ip.Rdns, ip.Dialer, email)
+
+	// unable to connect over this family, try the next one
+	if fallbacks := families.After(ip.Family); isDialError(err) && len(fallbacks) > 0 {
+		if fallback, ferr := s.ips.Pick(email.IPPool, len(recipients), fallbacks); ferr == nil {
+			printf("FALLBACK :: %s [from: %s] [to: %s] [error: %s]", email.ID, ip.Addr, fallback.Addr, err)
+
+			// nothing was sent from the first ip
+			s.ips.Refund(ip, len(recipients))
+
+			ip = fallback
+			printf = ip.printf
+
+			reply, rcptErrs, err = s.sendEmail(ip.Rdns, ip.Dialer, email)
+		}
+	}
+
+	// a throttled recipient counts against the provider even
+	// if the transaction went through for the others
+	throttleErr := err
+	if throttleErr == nil {
+		for _, rcptErr := range rcptErrs {
+			if IsThrottled(rcptErr) {
+				throttleErr = rcptErr
+				break
 			}
+		}
+	}
 
-			s.emailPool.Put(email)
+	s.throttle.Release(key, throttleErr)
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
+	// the provider has asked us to slow down, put the email
+	// back on the queue once it has backed off rather than
+	// bouncing it
+	if IsThrottled(err) {
+		s.ips.Record(ip, err)
+		s.ips.Refund(ip, len(recipients))
 
-			case <-tick:
+		printf(
+			"DEFER :: %s (%s -> %s -> %s) [%s] [provider: %s] [error: %s]",
+			email.ID,
+			email.From,
+			email.Via,
+			email.To,
+			time.Since(start),
+			key,
+			err,
+		)
+
+		if err := s.publisher.PublishDelayed(msg.Queue, msg, s.throttle.Backoff(key)); err != nil {
+			printf("ERR :: %s :: PublishDelayed: %s", email.ID, err)
+			if err := msg.Nack(true); err != nil {
+				printf("ERR :: %s :: NACK ERROR: %s", email.ID, err)
 			}
+			return
+		}
+
+		if err := msg.Ack(); err != nil {
+			printf("ERR :: %s :: ACK ERROR: %s", email.ID, err)
+		}
+		return
+	}
+
+	// each recipient is logged individually with its own reply
+	var deferred []smtp.Recipient
+
+	for idx, rcpt := range recipients {
+		rcptErr := err
+		if rcptErr == nil && idx < len(rcptErrs) {
+			rcptErr = rcptErrs[idx]
+		}
+
+		s.ips.Record(ip, rcptErr)
+
+		if IsThrottled(rcptErr) {
+			deferred = append(deferred, rcpt)
+			continue
+		}
+
+		single := *email
+		single.To = rcpt.To
+		single.DestinationID = rcpt.DestinationID
+		single.Recipients = nil
+		single.Status = reply
+		single.Error = rcptErr
+
+		s.logDelivery(&single, start, printf)
+	}
+
+	if len(deferred) > 0 {
+		s.ips.Refund(ip, len(deferred))
+
+		printf(
+			"DEFER :: %s (%s -> %s -> %s) [%s] [provider: %s] [recipients: %d]",
+			email.ID,
+			email.From,
+			email.Via,
+			deferred[0].To,
+			time.Since(start),
+			key,
+			len(deferred),
+		)
+
+		email.To = deferred[0].To
+		email.DestinationID = deferred[0].DestinationID
+		email.Recipients = nil
+		if len(deferred) > 1 {
+			email.Recipients = deferred
+		}
+
+		s.publishRequeue(email, s.throttle.Backoff(key))
+	}
+
+	if err := msg.Ack(); err != nil {
+		printf("ERR :: %s :: ACK ERROR: %s", email.ID, err)
+	}
+}
+
+// logDelivery publishes the outcome of delivering email to
+// a single recipient, bouncing it if it failed
+func (s *Sender) logDelivery(email *smtp.Email, start time.Time, printf printfFn) {
+	if email.Error != nil {
+		email.Status = email.Error.Error()
+		email.Etype = logger.EntryTypeBounce
+
+		s.publishBounce(email)
+	}
+
+	printf(
+		"%s :: %s (%s -> %s -> %s) [%s] [status: %s] [bounce: %s]",
+		email.Etype.String(),
+		email.ID,
+		email.From,
+		email.Via,
+		email.To,
+		time.Since(start),
+		email.Status,
+		email.Bounce,
+	)
+
+	entry := logger.Entry{
+		ID:            email.ID,
+		AccountID:     email.AccountID,
+		DomainID:      email.DomainID,
+		AliasID:       email.AliasID,
+		DestinationID: email.DestinationID,
+		FromEmail:     email.From,
+		ViaEmail:      email.Via,
+		ToEmail:       email.To,
+		Status:        email.Status,
+		Etype:         email.Etype,
+		QueueLevel:    int(email.QueueLevel),
+		Permanent:     IsPermanent(email.Error),
+	}
+
+	if entry.Etype != logger.EntryTypeSend {
+		entry.Message = email.Message
+	}
+
+	s.publishLogEntry(entry)
+}
+
+// deliverSink hands each recipient to the sink, they are logged
+// as if they had been sent to their MX
+func (s *Sender) deliverSink(msg mq.Message, email *smtp.Email, start time.Time) {
+	printf := func(format string, args ...interface{}) {
+		log.Printf("sink :: "+format, args...)
+	}
+
+	var deferred []smtp.Recipient
+
+	for _, rcpt := range email.AllRecipients() {
+		if err := s.Sink.Deliver(email, rcpt.To); err != nil {
+			printf("DEFER :: %s (%s -> %s -> %s) [error: %s]", email.ID, email.From, email.Via, rcpt.To, err)
+			deferred = append(deferred, rcpt)
+			continue
+		}
+
+		single := *email
+		single.To = rcpt.To
+		single.DestinationID = rcpt.DestinationID
+		single.Recipients = nil
+		single.Status = "sink"
+
+		s.logDelivery(&single, start, printf)
+	}
+
+	// the sink is unavailable, give it a chance to recover
+	if len(deferred) == len(email.AllRecipients()) {
+		if err := s.publisher.PublishDelayed(msg.Queue, msg, noIPDelay); err != nil {
+			printf("ERR :: %s :: PublishDelayed: %s", email.ID, err)
+			msg.Nack(true)
+			return
+		}
+
+	} else if len(deferred) > 0 {
+		email.To = deferred[0].To
+		email.DestinationID = deferred[0].DestinationID
+		email.Recipients = nil
+		if len(deferred) > 1 {
+			email.Recipients = deferred
 		}
+
+		s.publishRequeue(email, noIPDelay)
 	}
 
-	return nil
+	if err := msg.Ack(); err != nil {
+		printf("ERR :: %s :: ACK ERROR: %s", email.ID, err)
+	}
 }
@@ -9,101 +9,143 @@ import (
 
 	"github.com/jawr/mxax/internal/smtp"
 	smtpclient "github.com/jawr/mxax/internal/smtp/client"
+	"github.com/jawr/mxax/internal/tlsrpt"
 	"github.com/pkg/errors"
 )
 
 const SEND_DEADLINE = time.Second * 60
 
-func (s *Sender) sendEmail(rdns string, dialer net.Dialer, email *smtp.Email) (string, error) {
+// sendEmail delivers email to all of its recipients in a single
+// transaction. The returned errors hold the reply to each recipient's
+// RCPT, if err is non-nil the whole transaction failed
+func (s *Sender) sendEmail(rdns string, dialer net.Dialer, email *smtp.Email) (string, []error, error) {
 	parts := strings.Split(email.To, "@")
 	if len(parts) != 2 {
-		return "", errors.Errorf("bad destination: '%s'", email.To)
+		return "", nil, errors.Errorf("bad destination: '%s'", email.To)
 	}
 
 	destinationMXs, err := s.getDestinationMXs(parts[1])
 	if err != nil {
-		return "", errors.WithMessagef(err, "getDestinationMXs for '%s'", parts[1])
+		return "", nil, errors.WithMessagef(err, "getDestinationMXs for '%s'", parts[1])
 	}
 
 	if len(destinationMXs) == 0 {
-		return "", errors.Errorf("found no ddestination mxs for '%s'", parts[1])
+		return "", nil, errors.Errorf("found no ddestination mxs for '%s'", parts[1])
+	}
+
+	recipients := email.AllRecipients()
+	to := make([]string, 0, len(recipients))
+	for _, rcpt := range recipients {
+		to = append(to, rcpt.To)
+	}
+
+	returnPath := email.ReturnPath
+	if len(returnPath) == 0 {
+		returnPath = email.From
 	}
 
 	// TODO
 	// try until we hit an mx successfully
 	var dialErr error
 	for _, mx := range destinationMXs {
-		// reset err, if we hit a dial error, iterate to the next
-		dialErr = nil
-		conn, err := dialer.Dial("tcp", mx.Host+":25")
-		if err != nil {
-			dialErr = errors.WithMessagef(err, "dial '%s'", mx.Host)
-			continue
-		}
+		key := poolKey(mx.Host, dialer)
+
+		// reuse an idle session if we have one
+		ss := s.pool.Get(key)
+
+		if ss == nil {
+			// reset err, if we hit a dial error, iterate to the next
+			dialErr = nil
+			conn, err := dialer.Dial("tcp", net.JoinHostPort(mx.Host, s.Port))
+			if err != nil {
+				dialErr = errors.WithMessagef(err, "dial '%s'", mx.Host)
+				continue
+			}
 
-		if err := conn.SetDeadline(time.Now().Add(SEND_DEADLINE)); err != nil {
-			return "", errors.WithMessagef(err, "setDeadline: '%s'", mx.Host)
+			ss, err = s.newSession(rdns, conn, parts[1], mx.Host)
+			if err != nil {
+				conn.Close()
+				return "", nil, err
+			}
+			ss.key = key
 		}
 
-		client, err := smtpclient.NewClient(conn, mx.Host)
+		reply, rcptErrs, err := ss.send(returnPath, to, email.Message)
 		if err != nil {
-			return "", errors.WithMessagef(err, "newclient: '%s'", mx.Host)
+			ss.close()
+			return "", rcptErrs, err
 		}
 
-		if err := client.Hello(rdns); err != nil {
-			return "", errors.WithMessage(err, "Hello")
-		}
+		s.pool.Put(ss)
 
-		tlsConfig := &tls.Config{
-			ServerName: mx.Host,
-		}
+		return reply, rcptErrs, nil
+	}
 
-		if ok, _ := client.Extension("STARTTLS"); ok {
-			if err := client.StartTLS(tlsConfig); err != nil {
-				return "", errors.WithMessage(err, "StartTLS")
-			}
-		}
+	// check for any dial errors
+	if dialErr != nil {
+		return "", nil, dialErr
+	}
 
-		returnPath := email.ReturnPath
-		if len(returnPath) == 0 {
-			returnPath = email.From
-		}
+	return "", nil, errors.New("should never get here")
+}
 
-		if err := client.Mail(returnPath); err != nil {
-			return "", errors.WithMessage(err, "Mail")
-		}
+// greet and negotiate tls on a fresh connection
+func (s *Sender) newSession(rdns string, conn net.Conn, domain, host string) (*session, error) {
+	if err := conn.SetDeadline(time.Now().Add(SEND_DEADLINE)); err != nil {
+		return nil, errors.WithMessagef(err, "setDeadline: '%s'", host)
+	}
 
-		if err := client.Rcpt(email.To); err != nil {
-			return "", errors.WithMessage(err, "Rcpt")
-		}
+	client, err := smtpclient.NewClient(conn, host)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "newclient: '%s'", host)
+	}
 
-		wc, err := client.Data()
-		if err != nil {
-			return "", errors.WithMessage(err, "Data")
-		}
+	if err := client.Hello(rdns); err != nil {
+		return nil, errors.WithMessage(err, "Hello")
+	}
 
-		if _, err := wc.Write(email.Message); err != nil {
-			return "", errors.WithMessage(err, "Write")
-		}
+	tlsConfig := &tls.Config{
+		ServerName: host,
+	}
 
-		_, reply, err := wc.Close()
-		if err != nil {
-			return "", err
-		}
+	// record the outcome of tls negotiation for tls reporting
+	tlsResult := tlsrpt.Result{
+		PolicyDomain: domain,
+		ReceivingMX:  host,
+	}
 
-		if err := client.Quit(); err != nil {
-			return "", errors.WithMessage(err, "Quit")
-		}
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		tlsResult.SendingIP = addr.IP.String()
+	}
 
-		return reply, nil
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		tlsResult.ReceivingIP = addr.IP.String()
 	}
 
-	// check for any dial errors
-	if dialErr != nil {
-		return "", err
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			tlsResult.ResultType = tlsrpt.ResultTypeFromError(err)
+			tlsResult.Information = err.Error()
+			s.publishTLSResult(tlsResult)
+
+			return nil, errors.WithMessage(err, "StartTLS")
+		}
+	} else {
+		tlsResult.ResultType = tlsrpt.ResultTypeStartTLSNotSupported
+	}
+
+	s.publishTLSResult(tlsResult)
+
+	pipelining, _ := client.Extension("PIPELINING")
+
+	ss := &session{
+		conn:       conn,
+		client:     client,
+		pipelining: pipelining,
+		lastUsed:   time.Now(),
 	}
 
-	return "", errors.New("should never get here")
+	return ss, nil
 }
 
 func (s *Sender) getDestinationMXs(domain string) ([]*net.MX, error) {
@@ -111,7 +153,7 @@ func (s *Sender) getDestinationMXs(domain string) ([]*net.MX, error) {
 		return mxs.([]*net.MX), nil
 	}
 
-	mxs, err := net.LookupMX(domain)
+	mxs, err := s.LookupMX(domain)
 	if err != nil {
 		return nil, errors.WithMessage(err, "LookupMX")
 	}
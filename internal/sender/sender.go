@@ -2,22 +2,23 @@ package sender
 
 import (
 	"bytes"
+	"net"
 	"sync"
 
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
 	"github.com/jawr/mxax/internal/cache"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/smtp"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
 )
 
 type Sender struct {
 	wait chan struct{}
 
-	publisher *rabbitmq.Channel
+	publisher mq.Publisher
 
-	emailSubscriber  <-chan amqp.Delivery
-	bounceSubscriber <-chan amqp.Delivery
+	// emails are consumed from each lane by weight
+	lanes            *lanes
+	bounceSubscriber <-chan mq.Message
 
 	// pools
 	emailPool  sync.Pool
@@ -25,9 +26,35 @@ type Sender struct {
 
 	// multi purpose cache, strings are prefixed with namespace
 	cache *cache.Cache
+
+	// paces deliveries per destination provider
+	throttle *Throttle
+
+	// idle sessions per destination mx
+	pool *Pool
+
+	// outbound ips to send from
+	ips *IPPools
+
+	// preferred address families per destination
+	families *FamilyPreferences
+
+	// destination lookups and the port MXs are dialed
+	// on, replaceable before Run is called
+	LookupMX func(name string) ([]*net.MX, error)
+	LookupIP func(host string) ([]net.IP, error)
+	Port     string
+
+	// maximum number of emails being delivered at once, shared
+	// by every lane
+	Concurrency int
+
+	// when set emails are delivered to Sink rather than to
+	// their destination MX
+	Sink Sink
 }
 
-func NewSender(publisher *rabbitmq.Channel, emailSubscriber, bounceSubscriber <-chan amqp.Delivery) (*Sender, error) {
+func NewSender(publisher mq.Publisher, lanes []Lane, bounceSubscriber <-chan mq.Message, throttle *Throttle, pool *Pool, ips *IPPools, families *FamilyPreferences) (*Sender, error) {
 	cache, err := cache.NewCache()
 	if err != nil {
 		return nil, errors.WithMessage(err, "NewCache")
@@ -36,9 +63,17 @@ func NewSender(publisher *rabbitmq.Channel, emailSubscriber, bounceSubscriber <-
 	sender := &Sender{
 		wait:             make(chan struct{}, 0),
 		publisher:        publisher,
-		emailSubscriber:  emailSubscriber,
+		lanes:            newLanes(lanes),
 		bounceSubscriber: bounceSubscriber,
 		cache:            cache,
+		throttle:         throttle,
+		pool:             pool,
+		ips:              ips,
+		families:         families,
+		LookupMX:         net.LookupMX,
+		LookupIP:         net.LookupIP,
+		Port:             "25",
+		Concurrency:      10,
 		emailPool: sync.Pool{
 			New: func() interface{} {
 				return new(smtp.Email)
@@ -0,0 +1,233 @@
+package sender
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jawr/mxax/internal/smtp"
+	"github.com/pkg/errors"
+)
+
+// Sink takes the place of MX delivery, emails are kept
+// locally so the stack can run without sending real mail
+type Sink interface {
+	// Deliver email to a single recipient
+	Deliver(email *smtp.Email, to string) error
+}
+
+// ParseSink parses a sink in the form of maildir:dir, mbox:dir
+// or an http(s) url to POST each email to
+func ParseSink(s string) (Sink, error) {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+		return NewHTTPSink(s), nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, errors.Errorf("bad sink: '%s'", s)
+	}
+
+	switch parts[0] {
+	case "maildir":
+		return NewMaildirSink(parts[1]), nil
+	case "mbox":
+		return NewMboxSink(parts[1]), nil
+	}
+
+	return nil, errors.Errorf("bad sink type: '%s'", parts[0])
+}
+
+// sinkName turns a destination in to something safe to
+// use as a file name
+func sinkName(to string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(to))
+	if len(name) == 0 || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errors.Errorf("bad destination: '%s'", to)
+	}
+	return name, nil
+}
+
+// MaildirSink writes each email to a Maildir per destination
+// under dir
+type MaildirSink struct {
+	dir string
+
+	hostname string
+}
+
+func NewMaildirSink(dir string) *MaildirSink {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return &MaildirSink{
+		dir:      dir,
+		hostname: strings.NewReplacer("/", "_", ":", "_").Replace(hostname),
+	}
+}
+
+func (s *MaildirSink) Deliver(email *smtp.Email, to string) error {
+	name, err := sinkName(to)
+	if err != nil {
+		return err
+	}
+
+	maildir := filepath.Join(s.dir, name)
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(maildir, sub), 0755); err != nil {
+			return errors.WithMessagef(err, "MkdirAll '%s'", maildir)
+		}
+	}
+
+	// delivered to tmp and then moved in to new so readers
+	// never see a partial email
+	unique := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), email.ID, s.hostname)
+
+	tmp := filepath.Join(maildir, "tmp", unique)
+
+	if err := ioutil.WriteFile(tmp, sinkMessage(email, to), 0644); err != nil {
+		return errors.WithMessagef(err, "WriteFile '%s'", tmp)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(maildir, "new", unique)); err != nil {
+		os.Remove(tmp)
+		return errors.WithMessagef(err, "Rename '%s'", tmp)
+	}
+
+	return nil
+}
+
+// MboxSink appends each email to an mbox file per destination
+// under dir
+type MboxSink struct {
+	dir string
+
+	sync.Mutex
+}
+
+func NewMboxSink(dir string) *MboxSink {
+	return &MboxSink{
+		dir: dir,
+	}
+}
+
+func (s *MboxSink) Deliver(email *smtp.Email, to string) error {
+	name, err := sinkName(to)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return errors.WithMessagef(err, "MkdirAll '%s'", s.dir)
+	}
+
+	from := email.ReturnPath
+	if len(from) == 0 {
+		from = email.From
+	}
+	if len(from) == 0 {
+		from = "MAILER-DAEMON"
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From %s %s\n", from, time.Now().UTC().Format(time.ANSIC))
+
+	// mboxrd, quote any line that could be read as a separator
+	message := bytes.ReplaceAll(sinkMessage(email, to), []byte("\r\n"), []byte("\n"))
+	for _, line := range bytes.SplitAfter(message, []byte("\n")) {
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			b.WriteByte('>')
+		}
+		b.Write(line)
+	}
+
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+
+	path := filepath.Join(s.dir, name+".mbox")
+
+	s.Lock()
+	defer s.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.WithMessagef(err, "OpenFile '%s'", path)
+	}
+
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return errors.WithMessagef(err, "Write '%s'", path)
+	}
+
+	return f.Close()
+}
+
+// HTTPSink POSTs each email as message/rfc822 to a capture
+// endpoint, the envelope is passed in headers
+type HTTPSink struct {
+	url string
+
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url: url,
+		client: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+}
+
+func (s *HTTPSink) Deliver(email *smtp.Email, to string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(email.Message))
+	if err != nil {
+		return errors.WithMessage(err, "NewRequest")
+	}
+
+	req.Header.Set("Content-Type", "message/rfc822")
+	req.Header.Set("X-Mxax-ID", email.ID.String())
+	req.Header.Set("X-Mxax-Return-Path", email.ReturnPath)
+	req.Header.Set("X-Mxax-From", email.From)
+	req.Header.Set("X-Mxax-Via", email.Via)
+	req.Header.Set("X-Mxax-To", to)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.WithMessagef(err, "POST '%s'", s.url)
+	}
+	defer resp.Body.Close()
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("POST '%s' returned %s", s.url, resp.Status)
+	}
+
+	return nil
+}
+
+// sinkMessage prepends the headers a local delivery agent
+// would add
+func sinkMessage(email *smtp.Email, to string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Delivered-To: %s\r\n", to)
+	b.Write(email.Message)
+	return b.Bytes()
+}
@@ -0,0 +1,296 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jawr/mxax/internal/smtp"
+	"github.com/pkg/errors"
+)
+
+// maximum time we will back off from a provider that
+// keeps throttling us
+const maxBackoff = time.Minute * 30
+
+// Limit controls how we pace deliveries to a single
+// provider
+type Limit struct {
+	// maximum number of simultaneous sessions
+	Concurrency int
+	// minimum time between starting sessions
+	Interval time.Duration
+}
+
+// ParseLimit parses a limit in the form of
+// provider=concurrency,interval i.e. google.com=4,500ms
+func ParseLimit(s string) (string, Limit, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return "", Limit{}, errors.Errorf("bad limit: '%s'", s)
+	}
+
+	limit, err := parseLimitValue(parts[1])
+	if err != nil {
+		return "", Limit{}, err
+	}
+
+	return strings.ToLower(parts[0]), limit, nil
+}
+
+// parse concurrency,interval
+func parseLimitValue(s string) (Limit, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return Limit{}, errors.Errorf("bad limit value: '%s'", s)
+	}
+
+	concurrency, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Limit{}, errors.WithMessagef(err, "concurrency '%s'", parts[0])
+	}
+
+	if concurrency < 1 {
+		return Limit{}, errors.Errorf("concurrency must be at least 1: '%s'", s)
+	}
+
+	interval, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return Limit{}, errors.WithMessagef(err, "interval '%s'", parts[1])
+	}
+
+	if interval < 0 {
+		return Limit{}, errors.Errorf("interval can not be negative: '%s'", s)
+	}
+
+	return Limit{
+		Concurrency: concurrency,
+		Interval:    interval,
+	}, nil
+}
+
+// ParseDefaultLimit parses the limit applied to any provider
+// without its own limit, in the form of concurrency,interval
+func ParseDefaultLimit(s string) (Limit, error) {
+	return parseLimitValue(s)
+}
+
+// per provider state
+type provider struct {
+	limit Limit
+
+	active  int
+	next    time.Time
+	backoff time.Duration
+
+	// waiters per priority, lower priorities hold back
+	// while higher ones are waiting
+	waiting map[smtp.Priority]int
+}
+
+// outranked checks if anyone of a higher priority is waiting
+func (p *provider) outranked(priority smtp.Priority) bool {
+	for other, count := range p.waiting {
+		if other > priority && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Throttle paces deliveries per destination provider. A
+// provider is either a destination domain or a suffix
+// of the primary MX host, i.e. google.com covers every
+// domain hosted by gmail
+type Throttle struct {
+	sync.Mutex
+
+	defaultLimit Limit
+	limits       map[string]Limit
+
+	providers map[string]*provider
+
+	// signal waiters that a slot may be free
+	release chan struct{}
+}
+
+func NewThrottle(defaultLimit Limit, limits map[string]Limit) *Throttle {
+	if limits == nil {
+		limits = make(map[string]Limit)
+	}
+
+	return &Throttle{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		providers:    make(map[string]*provider),
+		release:      make(chan struct{}),
+	}
+}
+
+// Provider returns the key used to throttle deliveries to domain
+func (t *Throttle) Provider(domain string, mxs []*net.MX) string {
+	domain = strings.ToLower(domain)
+
+	if _, ok := t.limits[domain]; ok {
+		return domain
+	}
+
+	if len(mxs) > 0 {
+		host := strings.ToLower(strings.TrimSuffix(mxs[0].Host, "."))
+		for key := range t.limits {
+			if host == key || strings.HasSuffix(host, "."+key) {
+				return key
+			}
+		}
+	}
+
+	return domain
+}
+
+func (t *Throttle) get(key string) *provider {
+	p, ok := t.providers[key]
+	if !ok {
+		limit, ok := t.limits[key]
+		if !ok {
+			limit = t.defaultLimit
+		}
+
+		p = &provider{
+			limit:   limit,
+			waiting: make(map[smtp.Priority]int),
+		}
+
+		t.providers[key] = p
+	}
+
+	return p
+}
+
+// Acquire blocks until a delivery to the provider is allowed,
+// waiters of a higher priority go first
+func (t *Throttle) Acquire(ctx context.Context, key string, priority smtp.Priority) error {
+	t.Lock()
+	t.get(key).waiting[priority]++
+	t.Unlock()
+
+	defer func() {
+		t.Lock()
+		t.get(key).waiting[priority]--
+		// let lower priorities take the slot we were waiting on
+		close(t.release)
+		t.release = make(chan struct{})
+		t.Unlock()
+	}()
+
+	for {
+		t.Lock()
+		p := t.get(key)
+
+		concurrency := p.limit.Concurrency
+		if p.backoff > 0 {
+			// only probe a provider that is throttling us
+			concurrency = 1
+		}
+
+		wait := time.Until(p.next)
+
+		if p.active < concurrency && wait <= 0 && !p.outranked(priority) {
+			p.active++
+			p.next = time.Now().Add(p.limit.Interval + p.backoff)
+			t.Unlock()
+			return nil
+		}
+
+		release := t.release
+		t.Unlock()
+
+		// held back by concurrency or priority only, nothing
+		// changes until a slot is released
+		if wait <= 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-release:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// Backoff returns how long to hold off redelivering to a provider
+// that is throttling us, at least a second
+func (t *Throttle) Backoff(key string) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+
+	backoff := t.get(key).backoff
+	if backoff < time.Second {
+		backoff = time.Second
+	}
+
+	return backoff
+}
+
+// Release frees a slot for the provider. If err is a throttling
+// reply the provider is backed off, otherwise any backoff decays
+func (t *Throttle) Release(key string, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	p := t.get(key)
+	p.active--
+
+	if IsThrottled(err) {
+		if p.backoff == 0 {
+			p.backoff = p.limit.Interval
+			if p.backoff < time.Second {
+				p.backoff = time.Second
+			}
+		} else {
+			p.backoff *= 2
+		}
+
+		if p.backoff > maxBackoff {
+			p.backoff = maxBackoff
+		}
+
+		p.next = time.Now().Add(p.backoff)
+
+	} else if err == nil && p.backoff > 0 {
+		p.backoff /= 2
+		if p.backoff < time.Second {
+			p.backoff = 0
+		}
+	}
+
+	// wake any waiters
+	close(t.release)
+	t.release = make(chan struct{})
+}
+
+// IsThrottled checks if err is a 421 or 451 reply, which
+// providers use to signal that we are sending too fast
+func IsThrottled(err error) bool {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) {
+		return false
+	}
+
+	return protoErr.Code == 421 || protoErr.Code == 451
+}
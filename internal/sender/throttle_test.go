@@ -0,0 +1,166 @@
+package sender
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/jawr/mxax/internal/smtp"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		provider string
+		limit    Limit
+		err      bool
+	}{
+		{"Google.com=4,500ms", "google.com", Limit{4, time.Millisecond * 500}, false},
+		{"outlook.com=1,0s", "outlook.com", Limit{1, 0}, false},
+		{"outlook.com=0,1s", "", Limit{}, true},
+		{"outlook.com=1,-1s", "", Limit{}, true},
+		{"outlook.com=1,soon", "", Limit{}, true},
+		{"outlook.com=1", "", Limit{}, true},
+		{"outlook.com", "", Limit{}, true},
+	} {
+		provider, limit, err := ParseLimit(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("'%s': expected an error", tc.s)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("'%s': %s", tc.s, err)
+			continue
+		}
+
+		if provider != tc.provider || limit != tc.limit {
+			t.Errorf("'%s': expected %s %+v, got %s %+v", tc.s, tc.provider, tc.limit, provider, limit)
+		}
+	}
+}
+
+func TestThrottleProvider(t *testing.T) {
+	throttle := NewThrottle(Limit{1, 0}, map[string]Limit{"google.com": {4, 0}})
+
+	for _, tc := range []struct {
+		domain string
+		mx     string
+		want   string
+	}{
+		{"Google.com", "", "google.com"},
+		{"dest.test", "aspmx.l.google.com.", "google.com"},
+		{"dest.test", "mx.dest.test.", "dest.test"},
+		{"dest.test", "notgoogle.com.", "dest.test"},
+	} {
+		var mxs []*net.MX
+		if len(tc.mx) > 0 {
+			mxs = []*net.MX{{Host: tc.mx}}
+		}
+
+		if got := throttle.Provider(tc.domain, mxs); got != tc.want {
+			t.Errorf("%s (%s): expected '%s', got '%s'", tc.domain, tc.mx, tc.want, got)
+		}
+	}
+}
+
+// acquired reports if Acquire succeeds within wait
+func acquired(throttle *Throttle, priority smtp.Priority, wait time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	return throttle.Acquire(ctx, "dest.test", priority) == nil
+}
+
+func TestThrottleAcquire(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		limit Limit
+		// acquired without waiting, in order
+		want []bool
+	}{
+		{"concurrency", Limit{2, 0}, []bool{true, true, false}},
+		{"interval", Limit{4, time.Hour}, []bool{true, false}},
+	} {
+		throttle := NewThrottle(tc.limit, nil)
+
+		for idx, want := range tc.want {
+			if got := acquired(throttle, smtp.PriorityRelay, time.Millisecond*50); got != want {
+				t.Errorf("%s: %d: expected acquired %t, got %t", tc.name, idx, want, got)
+			}
+		}
+	}
+}
+
+func TestThrottleRelease(t *testing.T) {
+	throttle := NewThrottle(Limit{1, 0}, nil)
+
+	if !acquired(throttle, smtp.PriorityRelay, time.Millisecond*50) {
+		t.Fatal("expected the first acquire")
+	}
+
+	// a concurrency only limit waits on the release
+	done := make(chan bool)
+	go func() {
+		done <- acquired(throttle, smtp.PriorityRelay, time.Second*5)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	throttle.Release("dest.test", nil)
+
+	if !<-done {
+		t.Fatal("expected acquire once released")
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	throttle := NewThrottle(Limit{4, 0}, nil)
+
+	// release a connection that was never acquired through Acquire
+	release := func(err error) time.Duration {
+		throttle.Lock()
+		throttle.get("dest.test").active++
+		throttle.Unlock()
+
+		throttle.Release("dest.test", err)
+		return throttle.Backoff("dest.test")
+	}
+
+	if got := release(nil); got != time.Second {
+		t.Fatalf("expected the minimum backoff, got %s", got)
+	}
+
+	throttled := &textproto.Error{Code: 421, Msg: "slow down"}
+	release(throttled)
+	release(throttled)
+	if got := release(throttled); got != time.Second*4 {
+		t.Fatalf("expected backoff to double on each throttle, got %s", got)
+	}
+
+	if got := release(errors.New("connection refused")); got != time.Second*4 {
+		t.Fatalf("expected other errors to leave the backoff alone, got %s", got)
+	}
+
+	if got := release(nil); got != time.Second*2 {
+		t.Fatalf("expected a success to halve the backoff, got %s", got)
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	if !IsThrottled(&textproto.Error{Code: 421}) || !IsThrottled(&textproto.Error{Code: 451}) {
+		t.Error("expected 421 and 451 replies to be throttled")
+	}
+
+	if IsThrottled(&textproto.Error{Code: 450}) || IsThrottled(&textproto.Error{Code: 550}) {
+		t.Error("expected 450 and 550 replies not to be throttled")
+	}
+
+	if IsThrottled(errors.New("421 but not a reply")) || IsThrottled(nil) {
+		t.Error("expected only smtp replies to be throttled")
+	}
+}
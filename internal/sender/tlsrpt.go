@@ -0,0 +1,36 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/tlsrpt"
+)
+
+func (s *Sender) publishTLSResult(result tlsrpt.Result) {
+	b := s.bufferPool.Get().(*bytes.Buffer)
+	defer s.bufferPool.Put(b)
+	b.Reset()
+
+	result.Time = time.Now()
+
+	if err := json.NewEncoder(b).Encode(result); err != nil {
+		log.Printf("Error publish tls result encode: %s", err)
+		return
+	}
+
+	msg := mq.Message{
+		Timestamp:   time.Now(),
+		ContentType: "application/json",
+		Body:        b.Bytes(),
+	}
+
+	err := s.publisher.Publish("tlsrpt", msg)
+	if err != nil {
+		log.Printf("Error publish tls result: %s", err)
+		return
+	}
+}
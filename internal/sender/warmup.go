@@ -0,0 +1,84 @@
+package sender
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+// WarmupCounter counts the daily sends of ips that are warming
+// up, shared by every sender so that caps hold across processes
+type WarmupCounter interface {
+	// Reserve adds count to the sends of addr on day if that
+	// keeps it within c, reporting if it did
+	Reserve(addr string, day time.Time, count, c int) (bool, error)
+
+	// Refund takes count back off the sends of addr on day
+	Refund(addr string, day time.Time, count int) error
+}
+
+type warmupQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// DBWarmupCounter keeps warmup sends in the ip_warmup_sends table
+type DBWarmupCounter struct {
+	db warmupQuerier
+}
+
+func NewDBWarmupCounter(db warmupQuerier) *DBWarmupCounter {
+	return &DBWarmupCounter{db: db}
+}
+
+func (w *DBWarmupCounter) Reserve(addr string, day time.Time, count, c int) (bool, error) {
+	if count > c {
+		return false, nil
+	}
+
+	// the update only happens while under the cap, otherwise no
+	// row is returned
+	var sent int
+	err := w.db.QueryRow(
+		context.Background(),
+		`
+		INSERT INTO ip_warmup_sends (ip, day, sent) VALUES ($1, $2, $3)
+		ON CONFLICT (ip, day) DO UPDATE SET sent = ip_warmup_sends.sent + EXCLUDED.sent
+		WHERE ip_warmup_sends.sent + EXCLUDED.sent <= $4
+		RETURNING sent
+		`,
+		addr,
+		day,
+		count,
+		c,
+	).Scan(&sent)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithMessage(err, "Upsert")
+	}
+
+	return true, nil
+}
+
+func (w *DBWarmupCounter) Refund(addr string, day time.Time, count int) error {
+	_, err := w.db.Exec(
+		context.Background(),
+		`
+		UPDATE ip_warmup_sends SET sent = GREATEST(sent - $3, 0)
+		WHERE ip = $1 AND day = $2
+		`,
+		addr,
+		day,
+		count,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "Update")
+	}
+
+	return nil
+}
@@ -3,8 +3,8 @@ package smtp
 import (
 	"context"
 	"strings"
+	"time"
 
-	"github.com/georgysavva/scany/pgxscan"
 	"github.com/jawr/mxax/internal/account"
 	"github.com/pkg/errors"
 )
@@ -20,7 +20,7 @@ func (s *Server) detectAlias(email string) (account.Alias, error) {
 	}
 
 	if alias, ok := s.cache.Get("alias:match", email); ok {
-		return alias.(account.Alias), nil
+		return checkAliasState(alias.(account.Alias))
 	}
 
 	parts := strings.Split(email, "@")
@@ -38,25 +38,10 @@ func (s *Server) detectAlias(email string) (account.Alias, error) {
 
 	// search for domain in the database
 	var all []account.Alias
-	cacheAll, ok := s.cache.Get("aliases", domain)
+	cacheAll, ok := s.cache.Get("alias:domain", domain)
 
 	if !ok {
-		err := pgxscan.Select(
-			context.Background(),
-			s.db,
-			&all,
-			`
-				SELECT a.* 
-				FROM aliases AS a 
-					JOIN domains AS d ON a.domain_id = d.id 
-				WHERE d.name = $1 
-					AND a.deleted_at IS NULL 
-					AND d.deleted_at IS NULL 
-					AND d.verified_at IS NOT NULL
-				ORDER BY LENGTH(a.rule) DESC
-				`,
-			domain,
-		)
+		err := account.GetDomainAliases(context.Background(), s.db, &all, domain)
 		if err != nil {
 			s.cache.Set("alias:nxdomain", domain, struct{}{})
 			return account.Alias{}, err
@@ -68,16 +53,10 @@ func (s *Server) detectAlias(email string) (account.Alias, error) {
 		all = cacheAll.([]account.Alias)
 	}
 
-	// check for matches
-	for _, i := range all {
-		ok, err := i.Check(user)
-		if err != nil {
-			continue
-		}
-		if ok {
-			s.cache.Set("alias:match", email, i)
-			return i, nil
-		}
+	// check for matches, see account.MatchAlias for the order
+	if alias, ok := account.MatchAlias(all, user); ok {
+		s.cache.Set("alias:match", email, alias)
+		return checkAliasState(alias)
 	}
 
 	// no matches found, update nxmatch and return
@@ -85,3 +64,33 @@ func (s *Server) detectAlias(email string) (account.Alias, error) {
 
 	return account.Alias{}, errors.New("nxmatch")
 }
+
+// aliasRejected is returned by detectAlias when the matching
+// alias is not accepting mail
+type aliasRejected struct {
+	message string
+}
+
+func (e *aliasRejected) Error() string {
+	if len(e.message) > 0 {
+		return "alias rejected: " + e.message
+	}
+	return "alias not active"
+}
+
+// checkAliasState rejects mail to an alias that is disabled,
+// rejecting, outside of its active window or an expired burner
+// without a fallback
+func checkAliasState(alias account.Alias) (account.Alias, error) {
+	now := time.Now()
+
+	if ok, message := alias.Accepts(now); !ok {
+		return alias, &aliasRejected{message: message}
+	}
+
+	if alias.Expired(now) && !alias.HasFallback() {
+		return alias, &aliasRejected{}
+	}
+
+	return alias, nil
+}
@@ -3,7 +3,6 @@ package smtp
 import (
 	"context"
 	"log"
-	"strings"
 
 	"github.com/emersion/go-smtp"
 	"github.com/pkg/errors"
@@ -11,7 +10,7 @@ import (
 )
 
 func (s *Server) Login(state *smtp.ConnectionState, email, password string) (smtp.Session, error) {
-	if !strings.Contains(state.LocalAddr.String(), ":587") {
+	if !onPort(state, s.submissionPort) {
 		return nil, smtp.ErrAuthRequired
 	}
 
@@ -70,7 +69,7 @@ func (s *Server) Login(state *smtp.ConnectionState, email, password string) (smt
 }
 
 func (s *Server) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
-	if !strings.Contains(state.LocalAddr.String(), ":25") {
+	if !onPort(state, s.relayPort) {
 		return nil, smtp.ErrAuthRequired
 	}
 
@@ -0,0 +1,106 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgtype"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/pkg/errors"
+)
+
+// canAutoCreate reports if domain creates an alias for to, it
+// needs somewhere to forward to
+func (s *Server) canAutoCreate(domain account.Domain, to string) bool {
+	user := strings.SplitN(strings.ToLower(to), "@", 2)[0]
+	if !domain.AutoCreates(user) {
+		return false
+	}
+
+	destinations, err := s.getDefaultDestinations(domain.ID)
+	if err != nil {
+		log.Printf("canAutoCreate: %d: %s", domain.ID, err)
+		return false
+	}
+
+	return len(destinations) > 0
+}
+
+func (s *Server) getDefaultDestinations(domainID int) ([]account.Destination, error) {
+	key := fmt.Sprintf("%d", domainID)
+
+	if destinations, ok := s.cache.Get("default_destinations", key); ok {
+		return destinations.([]account.Destination), nil
+	}
+
+	var destinations []account.Destination
+	err := account.GetDefaultDestinations(context.Background(), s.db, &destinations, domainID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Set("default_destinations", key, destinations)
+
+	return destinations, nil
+}
+
+// autoCreateAlias creates an exact alias for to that forwards to
+// the domain's default destinations. If the alias already exists
+// it is returned, a deleted one rejects the mail so that deleting
+// an auto created alias stops it coming back
+func (s *Server) autoCreateAlias(domain account.Domain, to string) (account.Alias, error) {
+	to = strings.ToLower(to)
+	user := strings.SplitN(to, "@", 2)[0]
+
+	var alias account.Alias
+	err := pgxscan.Get(
+		context.Background(),
+		s.db,
+		&alias,
+		`
+		WITH a AS (
+			INSERT INTO aliases (account_id, domain_id, rule, rule_type, auto_created, priority)
+			VALUES (
+				$1,
+				$2,
+				$3,
+				$4,
+				TRUE,
+				(SELECT COALESCE(MAX(priority) + 1, 0) FROM aliases WHERE domain_id = $2 AND deleted_at IS NULL)
+			)
+			ON CONFLICT (domain_id, rule, rule_type) DO UPDATE SET rule = EXCLUDED.rule
+			RETURNING *
+		), ad AS (
+			INSERT INTO alias_destinations (account_id, alias_id, destination_id)
+			SELECT $1, a.id, dd.destination_id
+			FROM a, default_destinations AS dd
+			WHERE
+				dd.domain_id = $2
+				AND dd.deleted_at IS NULL
+				AND a.deleted_at IS NULL
+			ON CONFLICT (alias_id, destination_id) DO NOTHING
+		)
+		SELECT * FROM a
+		`,
+		domain.AccountID,
+		domain.ID,
+		user,
+		account.RuleTypeExact,
+	)
+	if err != nil {
+		return account.Alias{}, errors.WithMessage(err, "INSERT aliases")
+	}
+
+	if alias.DeletedAt.Status == pgtype.Present {
+		return alias, &aliasRejected{}
+	}
+
+	log.Printf("RLY - alias %d - Auto created '%s'", alias.ID, to)
+
+	s.cache.Set("alias:match", to, alias)
+
+	return alias, nil
+}
@@ -0,0 +1,84 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jawr/mxax/internal/account"
+	"github.com/jawr/mxax/internal/mq"
+	"github.com/jawr/mxax/internal/pgtest"
+)
+
+func TestAutoCreateAlias(t *testing.T) {
+	db := pgtest.Connect(t, os.Getenv("MXAX_TEST_DB_URL"))
+	ctx := context.Background()
+
+	var domain account.Domain
+	var destinationID int
+	err := db.QueryRow(
+		ctx,
+		`
+		WITH a AS (
+			INSERT INTO accounts (username, password, stripe_customer_id)
+			VALUES ('jess', '', 'cus_test')
+			RETURNING id
+		), d AS (
+			INSERT INTO domains (account_id, name, verify_code, expires_at)
+			SELECT id, 'example.com', 'verify', NOW() + INTERVAL '1 year' FROM a
+			RETURNING id, account_id
+		), dest AS (
+			INSERT INTO destinations (account_id, address)
+			SELECT id, 'jess@dest.test' FROM a
+			RETURNING id
+		), dd AS (
+			INSERT INTO default_destinations (account_id, domain_id, destination_id)
+			SELECT d.account_id, d.id, dest.id FROM d, dest
+		)
+		SELECT d.id, d.account_id, dest.id FROM d, dest
+		`,
+	).Scan(&domain.ID, &domain.AccountID, &destinationID)
+	if err != nil {
+		t.Fatalf("fixtures: %s", err)
+	}
+
+	server, err := NewServer(db, mq.NewMemory(), mq.NewMemory(), nil)
+	if err != nil {
+		t.Fatalf("NewServer: %s", err)
+	}
+
+	alias, err := server.autoCreateAlias(domain, "Shop@example.com")
+	if err != nil {
+		t.Fatalf("autoCreateAlias: %s", err)
+	}
+
+	if alias.AccountID != domain.AccountID || alias.Rule != "shop" || !alias.AutoCreated {
+		t.Fatalf("unexpected alias: %+v", alias)
+	}
+
+	// the account only sees the destination through its row
+	// level security policies
+	if _, err := db.Exec(ctx, "ALTER TABLE alias_destinations FORCE ROW LEVEL SECURITY"); err != nil {
+		t.Fatalf("FORCE ROW LEVEL SECURITY: %s", err)
+	}
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET LOCAL mxax.current_account_id TO %d", domain.AccountID)); err != nil {
+		t.Fatalf("SET LOCAL: %s", err)
+	}
+
+	var destinations []account.Destination
+	if err := account.GetAliasDestinations(ctx, tx, &destinations, alias.ID); err != nil {
+		t.Fatalf("GetAliasDestinations: %s", err)
+	}
+
+	if len(destinations) != 1 || destinations[0].ID != destinationID {
+		t.Fatalf("expected the default destination, got %+v", destinations)
+	}
+}
@@ -0,0 +1,196 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jawr/mxax/internal/dsn"
+	"github.com/jawr/mxax/internal/logger"
+	"github.com/pkg/errors"
+)
+
+// handleReturn deals with a message sent to one of our return paths.
+// Delivery status notifications are recorded against the original
+// log entry and then, depending on the failure, passed on to the
+// original sender, suppressed or reported to the account owner.
+// Anything else is passed on as is
+func (s *Server) handleReturn(session *SessionData) error {
+	report, err := dsn.Parse(bytes.NewReader(session.Message.Bytes()))
+	if err != nil {
+		log.Printf("RLY - %s - Not a DSN, passing on: %s", session.ID, err)
+		return s.passOnReturn(session, "Returned")
+	}
+
+	var aliasID int
+	err = s.db.QueryRow(
+		context.Background(),
+		"SELECT alias_id FROM return_paths WHERE id = $1",
+		session.ID,
+	).Scan(&aliasID)
+	if err != nil {
+		return errors.WithMessagef(err, "Select return_paths '%s'", session.ID)
+	}
+
+	destinations, err := s.getAliasDestinations(aliasID)
+	if err != nil {
+		return errors.WithMessage(err, "getAliasDestinations")
+	}
+
+	var passOn bool
+
+	for _, rcpt := range report.Recipients {
+		if !rcpt.Failed() && rcpt.Action != "delayed" {
+			continue
+		}
+
+		entry := logger.Entry{
+			ID:        session.ID,
+			AccountID: session.Domain.AccountID,
+			DomainID:  session.Domain.ID,
+			AliasID:   aliasID,
+			FromEmail: session.To,
+			ViaEmail:  session.Via,
+			ToEmail:   rcpt.FinalRecipient,
+			Etype:     logger.EntryTypeBounce,
+			Status:    fmt.Sprintf("%s %s: %s", strings.Title(rcpt.Action), rcpt.Status, rcpt.DiagnosticCode),
+			Message:   session.Message.Bytes(),
+			// only failures of the destination itself count
+			// towards suppression
+			Permanent: rcpt.MailboxProblem(),
+		}
+
+		// delays are worth seeing but are not bounces and must
+		// not count towards suppression
+		if !rcpt.Failed() {
+			entry.Etype = logger.EntryTypeDelay
+		}
+
+		for _, destination := range destinations {
+			if strings.EqualFold(destination.Address, rcpt.FinalRecipient) ||
+				strings.EqualFold(destination.Address, rcpt.OriginalRecipient) {
+				entry.DestinationID = destination.ID
+				entry.ToEmail = destination.Address
+			}
+		}
+
+		log.Printf(
+			"RLY - %s - DSN %s %s for '%s' (%s)",
+			session.ID,
+			rcpt.Action,
+			rcpt.Status,
+			entry.ToEmail,
+			rcpt.DiagnosticCode,
+		)
+
+		s.publishLogEntry(entry)
+
+		switch {
+		case rcpt.MailboxProblem():
+			// the sender has no business knowing about the
+			// destination, tell the owner instead
+			if err := s.notifyOwner(session, entry, rcpt); err != nil {
+				log.Printf("RLY - %s - notifyOwner: %s", session.ID, err)
+			}
+
+		case rcpt.Permanent():
+			// rejected for the message itself, i.e. policy
+			// or content, which the sender should hear about
+			passOn = true
+		}
+	}
+
+	if passOn {
+		return s.passOnReturn(session, "Returned")
+	}
+
+	return nil
+}
+
+// pass a returned message on to the original sender
+func (s *Server) passOnReturn(session *SessionData, bounce string) error {
+	return s.queueEmail(Email{
+		ID:        session.ID,
+		From:      session.From,
+		Via:       session.Via,
+		To:        session.To,
+		Message:   session.Message.Bytes(),
+		AccountID: session.Domain.AccountID,
+		DomainID:  session.Domain.ID,
+		AliasID:   session.Alias.ID,
+		Bounce:    bounce,
+	})
+}
+
+// notify the account owner that one of their destinations is
+// failing permanently
+func (s *Server) notifyOwner(session *SessionData, entry logger.Entry, rcpt dsn.Recipient) error {
+	var owner string
+	err := s.db.QueryRow(
+		context.Background(),
+		"SELECT email FROM accounts WHERE id = $1",
+		entry.AccountID,
+	).Scan(&owner)
+	if err != nil {
+		return errors.WithMessagef(err, "Select account %d", entry.AccountID)
+	}
+
+	// no point telling the owner at the address that is failing
+	if strings.EqualFold(owner, entry.ToEmail) {
+		return nil
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return errors.WithMessage(err, "NewRandom")
+	}
+
+	from := "postmaster@" + session.Domain.Name
+
+	message := s.bufferPool.Get().(*bytes.Buffer)
+	message.Reset()
+	defer s.bufferPool.Put(message)
+
+	fmt.Fprintf(message, "From: <%s>\r\n", from)
+	fmt.Fprintf(message, "To: <%s>\r\n", owner)
+	fmt.Fprintf(message, "Subject: Delivery to %s is failing\r\n", entry.ToEmail)
+	fmt.Fprintf(message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(message, "Message-ID: <%s@%s>\r\n", id, session.Domain.Name)
+	fmt.Fprintf(message, "Auto-Submitted: auto-generated\r\n")
+	fmt.Fprintf(message, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(message, "A message forwarded for %s to %s was returned as undeliverable.\r\n\r\n", session.Via, entry.ToEmail)
+	fmt.Fprintf(message, "Status: %s\r\n", rcpt.Status)
+	fmt.Fprintf(message, "Diagnostic: %s\r\n", rcpt.DiagnosticCode)
+	fmt.Fprintf(message, "Reported by: %s\r\n\r\n", reportedBy(rcpt))
+	fmt.Fprintf(message, "Repeated failures will suppress forwarding to this destination.\r\n")
+
+	signed := s.bufferPool.Get().(*bytes.Buffer)
+	signed.Reset()
+	defer s.bufferPool.Put(signed)
+
+	if err := s.dkimSignHandler(session, message, signed); err != nil {
+		return errors.WithMessage(err, "dkimSignHandler")
+	}
+
+	return s.queueEmail(Email{
+		ID:        id,
+		From:      from,
+		To:        owner,
+		Message:   signed.Bytes(),
+		AccountID: entry.AccountID,
+		DomainID:  entry.DomainID,
+		AliasID:   entry.AliasID,
+		Priority:  PrioritySystem,
+	})
+}
+
+func reportedBy(rcpt dsn.Recipient) string {
+	if len(rcpt.RemoteMTA) > 0 {
+		return rcpt.RemoteMTA
+	}
+	return "unknown"
+}
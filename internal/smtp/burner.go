@@ -0,0 +1,112 @@
+package smtp
+
+import (
+	"context"
+	"log"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/pkg/errors"
+)
+
+// aliasExpired checks if a burner alias has expired, either by
+// time or by uses. The first message to find it expired records
+// when
+func (s *Server) aliasExpired(alias account.Alias) (bool, error) {
+	var expired bool
+	err := s.db.QueryRow(
+		context.Background(),
+		`
+		SELECT
+			expired_at IS NOT NULL
+			OR COALESCE(expires_at <= NOW(), FALSE)
+			OR (max_uses > 0 AND uses >= max_uses)
+		FROM aliases
+		WHERE id = $1
+		`,
+		alias.ID,
+	).Scan(&expired)
+	if err != nil {
+		return false, errors.WithMessage(err, "SELECT aliases expired")
+	}
+
+	if !expired {
+		return false, nil
+	}
+
+	tag, err := s.db.Exec(
+		context.Background(),
+		"UPDATE aliases SET expired_at = NOW() WHERE id = $1 AND expired_at IS NULL",
+		alias.ID,
+	)
+	if err != nil {
+		return false, errors.WithMessage(err, "UPDATE aliases expired_at")
+	}
+
+	if tag.RowsAffected() > 0 {
+		log.Printf("RLY - alias %d - Expired at %s", alias.ID, alias.ExpiresAt.Time)
+	}
+
+	return true, nil
+}
+
+// useAlias counts a forwarded message against a burner alias,
+// expiring it once it reaches MaxUses. Only mail that has been
+// queued is counted
+func (s *Server) useAlias(alias account.Alias) error {
+	var uses int
+	err := s.db.QueryRow(
+		context.Background(),
+		`
+		UPDATE aliases SET
+			uses = uses + 1,
+			expired_at = CASE WHEN max_uses > 0 AND uses + 1 >= max_uses THEN NOW() END
+		WHERE
+			id = $1
+			AND expired_at IS NULL
+		RETURNING uses
+		`,
+		alias.ID,
+	).Scan(&uses)
+
+	// expired by another message in the meantime
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+
+	if err != nil {
+		return errors.WithMessage(err, "UPDATE aliases uses")
+	}
+
+	if alias.MaxUses > 0 && uses >= alias.MaxUses {
+		log.Printf("RLY - alias %d - Expired after %d messages", alias.ID, uses)
+	}
+
+	return nil
+}
+
+// getFallbackDestinations returns the destination expired mail
+// to alias is forwarded to, suppressed destinations are skipped
+func (s *Server) getFallbackDestinations(alias account.Alias) ([]account.Destination, error) {
+	var destinations []account.Destination
+	err := pgxscan.Select(
+		context.Background(),
+		s.db,
+		&destinations,
+		`
+		SELECT * FROM destinations
+		WHERE
+			id = $1
+			AND account_id = $2
+			AND deleted_at IS NULL
+		`,
+		alias.FallbackDestinationID.Int,
+		alias.AccountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.filterSuppressed(alias.ID, destinations)
+}
@@ -297,6 +297,95 @@ func (c *Client) Data() (*DataCloser, error) {
 	return &DataCloser{c, c.Text.DotWriter()}, nil
 }
 
+// Pipeline issues MAIL, one RCPT per address in to and DATA without
+// waiting for each reply, as per RFC 2920. Only servers that advertise
+// the PIPELINING extension support this function. The returned slice
+// holds the reply to each RCPT in order, a nil error meaning the
+// recipient was accepted. If no recipient was accepted, or MAIL or DATA
+// failed, a non-nil error is returned and the DataCloser is nil.
+func (c *Client) Pipeline(from string, to []string) ([]error, *DataCloser, error) {
+	if err := validateLine(from); err != nil {
+		return nil, nil, err
+	}
+	for _, rcpt := range to {
+		if err := validateLine(rcpt); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := c.hello(); err != nil {
+		return nil, nil, err
+	}
+	cmdStr := "MAIL FROM:<%s>"
+	if c.ext != nil {
+		if _, ok := c.ext["8BITMIME"]; ok {
+			cmdStr += " BODY=8BITMIME"
+		}
+	}
+
+	// send all commands
+	ids := make([]uint, 0, len(to)+2)
+	id, err := c.Text.Cmd(cmdStr, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids = append(ids, id)
+	for _, rcpt := range to {
+		id, err = c.Text.Cmd("RCPT TO:<%s>", rcpt)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+	}
+	id, err = c.Text.Cmd("DATA")
+	if err != nil {
+		return nil, nil, err
+	}
+	ids = append(ids, id)
+
+	// read replies in order
+	read := func(id uint, expectCode int) error {
+		c.Text.StartResponse(id)
+		defer c.Text.EndResponse(id)
+		_, _, err := c.Text.ReadResponse(expectCode)
+		return err
+	}
+
+	mailErr := read(ids[0], 250)
+
+	rcptErrs := make([]error, len(to))
+	var accepted int
+	for idx := range to {
+		rcptErrs[idx] = read(ids[idx+1], 25)
+		if rcptErrs[idx] == nil {
+			accepted++
+		}
+	}
+
+	dataErr := read(ids[len(ids)-1], 354)
+
+	if mailErr != nil || accepted == 0 {
+		// a misbehaving server accepted DATA with no valid
+		// transaction, end it with an empty message
+		if dataErr == nil {
+			w := &DataCloser{c, c.Text.DotWriter()}
+			w.Close()
+		}
+		if mailErr != nil {
+			return rcptErrs, nil, mailErr
+		}
+		if len(rcptErrs) > 0 {
+			return rcptErrs, nil, rcptErrs[0]
+		}
+		return rcptErrs, nil, errors.New("smtp: no recipients")
+	}
+
+	if dataErr != nil {
+		return rcptErrs, nil, dataErr
+	}
+
+	return rcptErrs, &DataCloser{c, c.Text.DotWriter()}, nil
+}
+
 var testHookStartTLS func(*tls.Config) // nil, except for tests
 
 // SendMail connects to the server at addr, switches to TLS if
@@ -10,17 +10,22 @@ import (
 	"io"
 
 	"github.com/emersion/go-msgauth/dkim"
+	"github.com/jawr/mxax/internal/account"
 	"github.com/pkg/errors"
 )
 
 func (s *Server) dkimSignHandler(session *SessionData, reader io.Reader, writer io.Writer) error {
-	key, err := s.getDkimPrivateKey(session.Domain.ID)
+	return s.dkimSign(session.Domain, reader, writer)
+}
+
+func (s *Server) dkimSign(domain account.Domain, reader io.Reader, writer io.Writer) error {
+	key, err := s.getDkimPrivateKey(domain.ID)
 	if err != nil {
 		return errors.WithMessage(err, "getDkimPrivateKey")
 	}
 
 	opts := dkim.SignOptions{
-		Domain:   session.Domain.Name,
+		Domain:   domain.Name,
 		Selector: "mxax",
 		Signer:   key,
 		Hash:     crypto.SHA256,
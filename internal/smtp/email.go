@@ -1,10 +1,18 @@
 package smtp
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jawr/mxax/internal/logger"
 )
 
+// Recipient is a single destination of an Email
+type Recipient struct {
+	To            string
+	DestinationID int
+}
+
 type Email struct {
 	ID         uuid.UUID
 	From       string
@@ -13,7 +21,19 @@ type Email struct {
 	To         string
 	Message    []byte
 
+	// when an email has several destinations at the same domain
+	// they are delivered in one transaction. Recipients then
+	// holds all of them, To and DestinationID the first
+	Recipients []Recipient
+
 	QueueLevel QueueLevel
+	Priority   Priority
+
+	// named pool of outbound ips to send from
+	IPPool string
+
+	// held until this time, zero queues straight away
+	NotBefore time.Time
 
 	// for metrics
 	AccountID     int
@@ -35,6 +55,7 @@ func (e *Email) Reset() {
 	e.Via = ""
 	e.To = ""
 	e.Message = nil
+	e.Recipients = nil
 	e.AccountID = 0
 	e.DomainID = 0
 	e.AliasID = 0
@@ -43,5 +64,22 @@ func (e *Email) Reset() {
 	e.Status = ""
 	e.Error = nil
 	e.QueueLevel = QueueLevelStraw
+	e.Priority = PriorityRelay
+	e.IPPool = ""
+	e.NotBefore = time.Time{}
 	e.Etype = logger.EntryTypeSend
 }
+
+// AllRecipients returns every recipient of the email
+func (e *Email) AllRecipients() []Recipient {
+	if len(e.Recipients) > 0 {
+		return e.Recipients
+	}
+
+	return []Recipient{
+		{
+			To:            e.To,
+			DestinationID: e.DestinationID,
+		},
+	}
+}
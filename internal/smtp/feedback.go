@@ -0,0 +1,122 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jawr/mxax/internal/arf"
+	"github.com/jawr/mxax/internal/logger"
+	"github.com/pkg/errors"
+)
+
+func (s *Server) isFeedbackAddress(to string) bool {
+	return s.feedbackAddresses[strings.ToLower(to)]
+}
+
+// handleFeedback parses a feedback loop report and records it as a
+// complaint against the account, alias and destination the original
+// was forwarded for
+func (s *Server) handleFeedback(session *SessionData) error {
+	report, err := arf.Parse(bytes.NewReader(session.Message.Bytes()))
+	if err != nil {
+		return errors.WithMessage(err, "arf.Parse")
+	}
+
+	// the recipient has marked it as not spam, nothing to do
+	if report.FeedbackType == "not-spam" {
+		return nil
+	}
+
+	id, err := s.findFeedbackOriginal(report)
+	if err != nil {
+		return errors.WithMessage(err, "findFeedbackOriginal")
+	}
+
+	entry := logger.Entry{
+		ID:      id,
+		Etype:   logger.EntryTypeComplaint,
+		Status:  fmt.Sprintf("Complaint: %s (%s)", report.FeedbackType, report.UserAgent),
+		Message: session.Message.Bytes(),
+	}
+
+	var returnTo string
+	err = s.db.QueryRow(
+		context.Background(),
+		`
+		SELECT rp.account_id, rp.alias_id, a.domain_id, rp.return_to
+		FROM return_paths AS rp
+			JOIN aliases AS a ON a.id = rp.alias_id
+		WHERE rp.id = $1
+		`,
+		id,
+	).Scan(&entry.AccountID, &entry.AliasID, &entry.DomainID, &returnTo)
+	if err != nil {
+		return errors.WithMessagef(err, "Select return_paths '%s'", id)
+	}
+
+	entry.FromEmail = returnTo
+	if report.Original != nil {
+		entry.ViaEmail = report.Original.Get("To")
+	}
+
+	// work out which destination complained, providers often
+	// redact it so fall back to the alias' only destination
+	destinations, err := s.getAliasDestinations(entry.AliasID)
+	if err != nil {
+		return errors.WithMessage(err, "getAliasDestinations")
+	}
+
+	for _, destination := range destinations {
+		for _, rcpt := range report.OriginalRcptTo {
+			if strings.EqualFold(destination.Address, rcpt) {
+				entry.DestinationID = destination.ID
+				entry.ToEmail = destination.Address
+			}
+		}
+	}
+
+	if entry.DestinationID == 0 && len(destinations) == 1 {
+		entry.DestinationID = destinations[0].ID
+		entry.ToEmail = destinations[0].Address
+	}
+
+	log.Printf(
+		"FBL - %s - %s complaint from '%s' for account %d alias %d destination '%s'",
+		id,
+		report.FeedbackType,
+		report.UserAgent,
+		entry.AccountID,
+		entry.AliasID,
+		entry.ToEmail,
+	)
+
+	s.publishLogEntry(entry)
+
+	return nil
+}
+
+// find the id of the original message from its return path. Only
+// our own return paths are trusted, anything else in a report can
+// be forged to suppress someone else's destination
+func (s *Server) findFeedbackOriginal(report *arf.Report) (uuid.UUID, error) {
+	candidates := []string{report.OriginalMailFrom}
+	if report.Original != nil {
+		candidates = append(candidates, strings.Trim(report.Original.Get("Return-Path"), "<> "))
+	}
+
+	for _, candidate := range candidates {
+		if len(candidate) == 0 {
+			continue
+		}
+
+		if id, err := parseReturnPath(candidate); err == nil {
+			return id, nil
+		}
+	}
+
+	return uuid.Nil, errors.New("no return path in report")
+}
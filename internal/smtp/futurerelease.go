@@ -0,0 +1,136 @@
+package smtp
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// header submission clients use to hold a message, takes the
+// parameters of RFC 4865 FUTURERELEASE i.e. HOLDFOR=3600 or
+// HOLDUNTIL=2020-11-01T09:00:00Z. The FUTURERELEASE MAIL parameter
+// itself needs a go-smtp that accepts extra MAIL parameters
+const futureReleaseHeader = "Future-Release"
+
+// longest a message can be held for
+const maxFutureRelease = time.Hour * 24 * 30
+
+// ParseFutureRelease parses a FUTURERELEASE parameter, returning
+// the time the message should be released at
+func ParseFutureRelease(value string, now time.Time) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+	if len(parts) != 2 {
+		return time.Time{}, errors.Errorf("bad future release: '%s'", value)
+	}
+
+	parts[1] = strings.TrimSpace(parts[1])
+
+	var release time.Time
+
+	switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+	case "HOLDFOR":
+		seconds, err := strconv.Atoi(parts[1])
+		if err != nil || seconds < 0 {
+			return time.Time{}, errors.Errorf("bad HOLDFOR: '%s'", parts[1])
+		}
+		release = now.Add(time.Duration(seconds) * time.Second)
+
+	case "HOLDUNTIL":
+		var err error
+		release, err = time.Parse(time.RFC3339, parts[1])
+		if err != nil {
+			return time.Time{}, errors.WithMessagef(err, "bad HOLDUNTIL: '%s'", parts[1])
+		}
+
+	default:
+		return time.Time{}, errors.Errorf("bad future release: '%s'", value)
+	}
+
+	if release.Sub(now) > maxFutureRelease {
+		return time.Time{}, errors.Errorf("can not hold for longer than %s", maxFutureRelease)
+	}
+
+	return release, nil
+}
+
+// FutureRelease looks for futureReleaseHeader in message, returning
+// the release time and the message with the header removed. A zero
+// time is returned if the message is not to be held
+func FutureRelease(message []byte, now time.Time) (time.Time, []byte, error) {
+	value, rest, ok := removeHeader(message, futureReleaseHeader)
+	if !ok {
+		return time.Time{}, message, nil
+	}
+
+	release, err := ParseFutureRelease(value, now)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	if !release.After(now) {
+		return time.Time{}, rest, nil
+	}
+
+	return release, rest, nil
+}
+
+// headerValue returns the unfolded value of the first header
+// called name in message
+func headerValue(message []byte, name string) string {
+	value, _, _ := removeHeader(message, name)
+	return value
+}
+
+// removeHeader removes the first header called name, along with
+// any folded lines, from the header section of message
+func removeHeader(message []byte, name string) (string, []byte, bool) {
+	prefix := []byte(strings.ToLower(name) + ":")
+
+	offset := 0
+	for offset < len(message) {
+		end := bytes.IndexByte(message[offset:], '\n')
+		if end < 0 {
+			end = len(message)
+		} else {
+			end += offset + 1
+		}
+
+		line := message[offset:end]
+
+		// end of the header section
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+
+		if !bytes.HasPrefix(bytes.ToLower(line), prefix) {
+			offset = end
+			continue
+		}
+
+		value := strings.TrimSpace(string(line[len(prefix):]))
+
+		// unfold continuation lines
+		for end < len(message) && (message[end] == ' ' || message[end] == '\t') {
+			next := bytes.IndexByte(message[end:], '\n')
+			if next < 0 {
+				next = len(message)
+			} else {
+				next += end + 1
+			}
+
+			value += " " + strings.TrimSpace(string(message[end:next]))
+			end = next
+		}
+
+		rest := make([]byte, 0, len(message)-(end-offset))
+		rest = append(rest, message[:offset]...)
+		rest = append(rest, message[end:]...)
+
+		return value, rest, true
+	}
+
+	return "", message, false
+}
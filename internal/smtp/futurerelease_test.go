@@ -0,0 +1,86 @@
+package smtp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFutureRelease(t *testing.T) {
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+
+	valid := map[string]time.Time{
+		"HOLDFOR=3600":                        now.Add(time.Hour),
+		" holdfor = 0 ":                       now,
+		"HOLDUNTIL=2020-11-02T09:00:00Z":      now.Add(time.Hour * 24),
+		"HOLDUNTIL=2020-11-02T10:00:00+01:00": now.Add(time.Hour * 24),
+	}
+
+	for value, want := range valid {
+		got, err := ParseFutureRelease(value, now)
+		if err != nil {
+			t.Errorf("'%s': %s", value, err)
+			continue
+		}
+
+		if !got.Equal(want) {
+			t.Errorf("'%s': expected %s, got %s", value, want, got)
+		}
+	}
+
+	invalid := []string{
+		"HOLDFOR=-1",
+		"HOLDFOR=soon",
+		"HOLDUNTIL=tomorrow",
+		// beyond the maximum hold
+		"HOLDFOR=31536000",
+		"HOLDFOREVER=1",
+		"3600",
+	}
+
+	for _, value := range invalid {
+		if got, err := ParseFutureRelease(value, now); err == nil {
+			t.Errorf("'%s': expected an error, got %s", value, got)
+		}
+	}
+}
+
+func TestFutureRelease(t *testing.T) {
+	now := time.Date(2020, 11, 1, 9, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name    string
+		message string
+		release time.Time
+		rest    string
+		err     bool
+	}{
+		{"not held", "Subject: Hi\r\n\r\nHello\r\n", time.Time{}, "Subject: Hi\r\n\r\nHello\r\n", false},
+		{"held", "Subject: Hi\r\nFuture-Release: HOLDFOR=60\r\n\r\nHello\r\n", now.Add(time.Minute), "Subject: Hi\r\n\r\nHello\r\n", false},
+		{"already due", "future-release: HOLDUNTIL=2020-10-01T00:00:00Z\r\nSubject: Hi\r\n\r\nHello\r\n", time.Time{}, "Subject: Hi\r\n\r\nHello\r\n", false},
+		{"body only", "Subject: Hi\r\n\r\nFuture-Release: HOLDFOR=60\r\n", time.Time{}, "Subject: Hi\r\n\r\nFuture-Release: HOLDFOR=60\r\n", false},
+		{"bad", "Future-Release: later\r\n\r\nHello\r\n", time.Time{}, "", true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			release, rest, err := FutureRelease([]byte(tc.message), now)
+			if tc.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !release.Equal(tc.release) {
+				t.Errorf("expected release %s, got %s", tc.release, release)
+			}
+
+			if string(rest) != tc.rest {
+				t.Errorf("expected %q, got %q", tc.rest, rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jawr/mxax/internal/account"
+	"github.com/pkg/errors"
+)
+
+// how often held emails are checked for release
+const releaseInterval = time.Second * 30
+
+// queueSigned dkim signs email for domain and queues it. Emails
+// that are held are stored unsigned and signed when released, so
+// that their signature is not days old by the time they are sent
+func (s *Server) queueSigned(domain account.Domain, email Email) error {
+	if email.NotBefore.After(time.Now()) {
+		return s.holdEmail(email)
+	}
+
+	signed := s.bufferPool.Get().(*bytes.Buffer)
+	signed.Reset()
+	defer s.bufferPool.Put(signed)
+
+	if err := s.dkimSign(domain, bytes.NewReader(email.Message), signed); err != nil {
+		return errors.WithMessage(err, "dkimSign")
+	}
+
+	email.Message = signed.Bytes()
+
+	return s.queueEmail(email)
+}
+
+// holdEmail stores email until its NotBefore time, it is
+// queued by releaseHeld
+func (s *Server) holdEmail(email Email) error {
+	b, err := json.Marshal(&email)
+	if err != nil {
+		return errors.WithMessage(err, "Marshal")
+	}
+
+	_, err = s.db.Exec(
+		context.Background(),
+		`
+		INSERT INTO held_emails (email_id, account_id, domain_id, from_email, to_email, subject, email, release_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`,
+		email.ID,
+		email.AccountID,
+		email.DomainID,
+		email.From,
+		email.To,
+		headerValue(email.Message, "Subject"),
+		b,
+		email.NotBefore,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "INSERT held_emails")
+	}
+
+	log.Printf("=== - %s - Held until %s", email.ID, email.NotBefore.Format(time.RFC3339))
+
+	return nil
+}
+
+// releaseHeld queues held emails once they are due until done
+// is closed
+func (s *Server) releaseHeld(done <-chan struct{}) {
+	ticker := time.NewTicker(releaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.releaseDue(); err != nil {
+			log.Printf("releaseHeld: %s", err)
+		}
+	}
+}
+
+// releaseDue claims due emails so that only one server releases
+// each of them, anything that fails to queue is unclaimed. Claims
+// older than account.HeldClaimTimeout are from a server that went
+// away before it finished and are claimed again
+func (s *Server) releaseDue() error {
+	rows, err := s.db.Query(
+		context.Background(),
+		`
+		UPDATE held_emails SET released_at = NOW()
+		WHERE id IN (
+			SELECT id FROM held_emails
+			WHERE
+				release_at <= NOW()
+				AND (released_at IS NULL OR released_at < NOW() - $1::INT * INTERVAL '1 second')
+			ORDER BY release_at
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, email
+		`,
+		int(account.HeldClaimTimeout.Seconds()),
+	)
+	if err != nil {
+		return errors.WithMessage(err, "UPDATE held_emails")
+	}
+
+	type held struct {
+		id    int
+		email []byte
+	}
+
+	var due []held
+	for rows.Next() {
+		var h held
+		if err := rows.Scan(&h.id, &h.email); err != nil {
+			rows.Close()
+			return errors.WithMessage(err, "Scan")
+		}
+		due = append(due, h)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return errors.WithMessage(err, "Rows")
+	}
+
+	for _, h := range due {
+		if err := s.releaseEmail(h.email); err != nil {
+			log.Printf("releaseDue: %d: %s", h.id, err)
+
+			_, err := s.db.Exec(
+				context.Background(),
+				"UPDATE held_emails SET released_at = NULL WHERE id = $1",
+				h.id,
+			)
+			if err != nil {
+				log.Printf("releaseDue: %d: unclaim: %s", h.id, err)
+			}
+			continue
+		}
+
+		_, err := s.db.Exec(
+			context.Background(),
+			"DELETE FROM held_emails WHERE id = $1",
+			h.id,
+		)
+		if err != nil {
+			log.Printf("releaseDue: %d: DELETE held_emails: %s", h.id, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) releaseEmail(b []byte) error {
+	var email Email
+	if err := json.Unmarshal(b, &email); err != nil {
+		return errors.WithMessage(err, "Unmarshal")
+	}
+
+	email.NotBefore = time.Time{}
+
+	// held emails are sent from their own domain, which must
+	// still be ours to sign for
+	domain, err := s.detectDomain(email.From)
+	if err != nil {
+		return errors.WithMessage(err, "detectDomain")
+	}
+
+	if domain.ID != email.DomainID {
+		return errors.Errorf("from '%s' is not on domain %d", email.From, email.DomainID)
+	}
+
+	if err := s.queueSigned(domain, email); err != nil {
+		return errors.WithMessage(err, "queueSigned")
+	}
+
+	log.Printf("=== - %s - Released", email.ID)
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// getIPPool returns the name of the outbound ip pool for a domain,
+// either assigned directly or through its account type. An empty
+// name means the default pool
+func (s *Server) getIPPool(domainID int) (string, error) {
+	key := fmt.Sprintf("%d", domainID)
+
+	if pool, ok := s.cache.Get("ippool", key); ok {
+		return pool.(string), nil
+	}
+
+	var pool string
+	err := s.db.QueryRow(
+		context.Background(),
+		`
+		SELECT COALESCE(dp.ip_pool, ap.ip_pool, '')
+		FROM domains AS d
+			JOIN accounts AS a ON a.id = d.account_id
+			LEFT JOIN domain_ip_pools AS dp ON dp.domain_id = d.id
+			LEFT JOIN account_type_ip_pools AS ap ON ap.account_type = a.account_type
+		WHERE d.id = $1
+		`,
+		domainID,
+	).Scan(&pool)
+	if err != nil {
+		return "", errors.WithMessage(err, "Select")
+	}
+
+	s.cache.Set("ippool", key, pool)
+
+	return pool, nil
+}
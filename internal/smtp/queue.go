@@ -6,8 +6,8 @@ import (
 	"log"
 	"time"
 
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
 )
 
 type QueueLevel int
@@ -37,7 +37,74 @@ var Queues = map[string]QueueLevel{
 	"emails.bricks": QueueLevelBricks,
 }
 
+// Priority decides which lane of a queue an email travels in,
+// higher priorities are consumed ahead of lower ones
+type Priority int
+
+const (
+	// forwarded by the relay
+	PriorityRelay Priority = iota
+	// sent by our users through submission
+	PrioritySubmission
+	// sent by us, i.e. verification and notices
+	PrioritySystem
+)
+
+// Priorities from highest to lowest
+var Priorities = []Priority{PrioritySystem, PrioritySubmission, PriorityRelay}
+
+func (p Priority) String() string {
+	switch p {
+	case PrioritySystem:
+		return "system"
+	case PrioritySubmission:
+		return "submission"
+	default:
+		return "relay"
+	}
+}
+
+// ParsePriority parses the name of a priority
+func ParsePriority(s string) (Priority, error) {
+	for _, p := range Priorities {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, errors.Errorf("bad priority: '%s'", s)
+}
+
+// Lane returns the queue emails of priority are published to,
+// relay traffic stays on the level's own queue
+func (l QueueLevel) Lane(priority Priority) string {
+	if priority == PriorityRelay {
+		return l.String()
+	}
+	return l.String() + "." + priority.String()
+}
+
+// Lanes returns every lane of the level, highest priority first
+func (l QueueLevel) Lanes() []string {
+	lanes := make([]string, 0, len(Priorities))
+	for _, p := range Priorities {
+		lanes = append(lanes, l.Lane(p))
+	}
+	return lanes
+}
+
 func (s *Server) queueEmail(email Email) error {
+	if email.NotBefore.After(time.Now()) {
+		return s.holdEmail(email)
+	}
+
+	if len(email.IPPool) == 0 && email.DomainID > 0 {
+		pool, err := s.getIPPool(email.DomainID)
+		if err != nil {
+			return errors.WithMessage(err, "getIPPool")
+		}
+		email.IPPool = pool
+	}
+
 	b := s.bufferPool.Get().(*bytes.Buffer)
 	defer s.bufferPool.Put(b)
 	b.Reset()
@@ -46,24 +113,20 @@ func (s *Server) queueEmail(email Email) error {
 		return errors.WithMessage(err, "Encode")
 	}
 
-	msg := amqp.Publishing{
+	msg := mq.Message{
 		Timestamp:   time.Now(),
 		ContentType: "application/json",
 		Body:        b.Bytes(),
 	}
 
-	err := s.emailPublisher.Publish(
-		"",
-		email.QueueLevel.String(),
-		false, // mandatory
-		false, // immediate
-		msg,
-	)
+	queue := email.QueueLevel.Lane(email.Priority)
+
+	err := s.emailPublisher.Publish(queue, msg)
 	if err != nil {
 		return errors.WithMessage(err, "Publish")
 	}
 
-	log.Printf("=== - %s - Queued to %s", email.ID, email.QueueLevel.String())
+	log.Printf("=== - %s - Queued to %s", email.ID, queue)
 
 	return nil
 }
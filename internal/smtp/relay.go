@@ -12,8 +12,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/georgysavva/scany/pgxscan"
 	"github.com/jawr/mxax/internal/account"
+	"github.com/jawr/mxax/internal/logger"
 	"github.com/jhillyerd/enmime"
 	"github.com/pkg/errors"
 )
@@ -54,18 +54,55 @@ func (s *Server) relay(session *SessionData) error {
 		)
 	}
 
-	returnPath, err := s.makeReturnPath(session)
-	if err != nil {
-		return errors.WithMessage(err, "makeReturnPath")
+	if session.autoCreate {
+		alias, err := s.autoCreateAlias(session.Domain, session.To)
+		if err != nil {
+			return err
+		}
+		session.Alias = alias
 	}
 
-	returnPathHeader := fmt.Sprintf(
-		"Return-Path: <%s>\r\n",
-		returnPath,
-	)
+	// burner aliases are forwarded to their fallback, or
+	// rejected, once expired
+	expired := false
+	if session.Alias.IsBurner() {
+		expired, err = s.aliasExpired(session.Alias)
+		if err != nil {
+			return errors.WithMessage(err, "aliasExpired")
+		}
+	}
+
+	if expired && !session.Alias.HasFallback() {
+		return &aliasRejected{}
+	}
 
 	// get alias' destinations to forward on to
-	destinations, err := s.getDestinations(session.Alias.ID)
+	var destinations []account.Destination
+	if expired {
+		destinations, err = s.getFallbackDestinations(session.Alias)
+	} else {
+		destinations, err = s.getDestinations(session.Alias.ID)
+	}
+
+	// retrying will not help, accept it and let the account
+	// see that it was dropped
+	if errors.Is(err, errSuppressed) {
+		log.Printf("RLY - %s - Dropped, %s", session.ID, err)
+
+		s.publishLogEntry(logger.Entry{
+			ID:        session.ID,
+			AccountID: session.Domain.AccountID,
+			DomainID:  session.Domain.ID,
+			AliasID:   session.Alias.ID,
+			FromEmail: session.From,
+			ViaEmail:  session.To,
+			Etype:     logger.EntryTypeDrop,
+			Status:    "Every destination is suppressed",
+		})
+
+		return nil
+	}
+
 	if err != nil {
 		return errors.WithMessage(err, "getDestinations")
 	}
@@ -95,16 +132,81 @@ func (s *Server) relay(session *SessionData) error {
 	// rewrite the session From as it is stored in return_paths
 	session.From = fromList[0].Address
 
-	for _, destination := range destinations {
+	// the message as forwarded, before our headers are added
+	body := session.Message.Bytes()
+
+	// replies come back through a reverse alias of the
+	// correspondent rather than going to them directly
+	if session.Domain.ReverseAliases {
+		correspondent := fromList[0]
+		if replyTo, err := env.AddressList("Reply-To"); err == nil && len(replyTo) > 0 {
+			correspondent = replyTo[0]
+		}
+
+		reverse, err := s.makeReverseAlias(session, correspondent.Address)
+		if err != nil {
+			return errors.WithMessage(err, "makeReverseAlias")
+		}
+
+		body = rewriteForward(body, correspondent, reverse.Address(session.Domain.Name))
+	}
+
+	// let destinations filter on the alias mail arrived at
+	if len(session.Alias.SubjectTag) > 0 {
+		body = tagSubject(body, session.Alias.SubjectTag)
+	}
+
+	var tagHeader string
+	if session.Alias.TagHeaders {
+		body, tagHeader = tagHeaders(body, session)
+	}
+
+	// upstream Received headers are scrubbed before ours is added
+	if session.Alias.Scrub {
+		body, err = scrub(body)
+		if err != nil {
+			log.Printf("RLY - %s - scrub: %s", session.ID, err)
+		}
+	}
+
+	message = bytes.NewReader(body)
+
+	returnPath, err := s.makeReturnPath(session)
+	if err != nil {
+		return errors.WithMessage(err, "makeReturnPath")
+	}
+
+	returnPathHeader := fmt.Sprintf(
+		"Return-Path: <%s>\r\n",
+		returnPath,
+	)
+
+	// let the destination filter on the +tag of a subaddress
+	var subaddressHeader string
+	user := strings.SplitN(session.To, "@", 2)[0]
+	if tag := session.Alias.Subaddress(user); len(tag) > 0 {
+		subaddressHeader = fmt.Sprintf("X-Mxax-Subaddress: %s\r\n", tag)
+	}
+
+	// destinations at the same domain share a single signed copy
+	// and are delivered in one transaction
+	for _, group := range groupDestinations(destinations) {
+		// the for clause is only given when there is a single
+		// recipient (RFC 5321 4.4)
+		var forClause string
+		if len(group) == 1 {
+			forClause = fmt.Sprintf(" for <%s>", group[0].Address)
+		}
+
 		receivedHeader := fmt.Sprintf(
-			"Received: from %s (%s [%s]) by %s with %s id %s for <%s>;%s\r\n\t%s\r\n",
+			"Received: from %s (%s [%s]) by %s with %s id %s%s;%s\r\n\t%s\r\n",
 			session.State.Hostname,
 			rdns,
 			remoteIP,
 			session.ServerName,
 			"ESMTP",
 			session.ID.String(),
-			destination.Address,
+			forClause,
 			tlsInfo,
 			time.Now().Format("Mon, 02 Jan 2006 15:04:05 -0700 (MST)"),
 		)
@@ -114,7 +216,15 @@ func (s *Server) relay(session *SessionData) error {
 			return errors.WithMessage(err, "unable to seek message")
 		}
 
-		log.Printf("RLY - %s - Send to %d '%s'", session.ID, destination.ID, destination.Address)
+		recipients := make([]Recipient, 0, len(group))
+		for _, destination := range group {
+			log.Printf("RLY - %s - Send to %d '%s'", session.ID, destination.ID, destination.Address)
+
+			recipients = append(recipients, Recipient{
+				To:            destination.Address,
+				DestinationID: destination.ID,
+			})
+		}
 
 		final := s.bufferPool.Get().(*bytes.Buffer)
 		final.Reset()
@@ -130,6 +240,14 @@ func (s *Server) relay(session *SessionData) error {
 			return errors.WithMessage(err, "WriteString receivedHeader")
 		}
 
+		if _, err := final.WriteString(subaddressHeader); err != nil {
+			return errors.WithMessage(err, "WriteString subaddressHeader")
+		}
+
+		if _, err := final.WriteString(tagHeader); err != nil {
+			return errors.WithMessage(err, "WriteString tagHeader")
+		}
+
 		// write the actual message
 		if _, err := final.ReadFrom(message); err != nil {
 			return errors.WithMessage(err, "ReadFrom Message")
@@ -143,34 +261,74 @@ func (s *Server) relay(session *SessionData) error {
 			return errors.WithMessage(err, "dkimSignHandler")
 		}
 
-		err = session.server.queueEmail(Email{
+		email := Email{
 			ID:            session.ID,
 			ReturnPath:    returnPath,
 			From:          session.From,
 			Via:           session.To,
-			To:            destination.Address,
+			To:            group[0].Address,
 			Message:       signed.Bytes(),
 			AccountID:     session.Domain.AccountID,
 			DomainID:      session.Domain.ID,
 			AliasID:       session.Alias.ID,
-			DestinationID: destination.ID,
-		})
+			DestinationID: group[0].ID,
+		}
+
+		if len(recipients) > 1 {
+			email.Recipients = recipients
+		}
+
+		err = session.server.queueEmail(email)
 		if err != nil {
 			return errors.Wrap(err, "queueEmail")
 		}
 
 	}
 
+	// the message is queued so failing here would only have it
+	// forwarded twice
+	if session.Alias.IsBurner() && !expired {
+		if err := s.useAlias(session.Alias); err != nil {
+			log.Printf("RLY - %s - useAlias: %s", session.ID, err)
+		}
+	}
+
 	return nil
 }
 
+// group destinations by their domain, keeping the
+// original order
+func groupDestinations(destinations []account.Destination) [][]account.Destination {
+	var groups [][]account.Destination
+	index := make(map[string]int)
+
+	for _, destination := range destinations {
+		domain := destination.Address
+		if idx := strings.LastIndex(domain, "@"); idx >= 0 {
+			domain = domain[idx+1:]
+		}
+		domain = strings.ToLower(domain)
+
+		idx, ok := index[domain]
+		if !ok {
+			idx = len(groups)
+			index[domain] = idx
+			groups = append(groups, nil)
+		}
+
+		groups[idx] = append(groups[idx], destination)
+	}
+
+	return groups
+}
+
 func (s *Server) getRDNS(ip string) (string, error) {
 	if v, ok := s.cache.Get("rdns", ip); ok {
 		return v.(string), nil
 	}
 
 	var rdns string
-	addr, err := net.LookupAddr(ip)
+	addr, err := s.LookupAddr(ip)
 	if err != nil {
 		if !strings.Contains(err.Error(), "no such host") {
 			return "", errors.WithMessagef(err, "LookupAddr '%s'", ip)
@@ -196,26 +354,60 @@ func (s *Server) getRDNS(ip string) (string, error) {
 	return rdns, nil
 }
 
+// getDestinations returns the alias' destinations, skipping
+// any that are suppressed
 func (s *Server) getDestinations(aliasID int) ([]account.Destination, error) {
+	destinations, err := s.getAliasDestinations(aliasID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.filterSuppressed(aliasID, destinations)
+}
+
+// errSuppressed is returned when every destination of an alias
+// is suppressed
+var errSuppressed = errors.New("every destination is suppressed")
+
+// filterSuppressed removes suppressed destinations, returning
+// errSuppressed if none are left
+func (s *Server) filterSuppressed(aliasID int, destinations []account.Destination) ([]account.Destination, error) {
+	if len(destinations) == 0 {
+		return destinations, nil
+	}
+
+	suppressed, err := s.getSuppressed(destinations[0].AccountID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "getSuppressed")
+	}
+
+	if len(suppressed) == 0 {
+		return destinations, nil
+	}
+
+	allowed := make([]account.Destination, 0, len(destinations))
+	for _, destination := range destinations {
+		if reason, ok := suppressed[strings.ToLower(destination.Address)]; ok {
+			log.Printf("RLY - alias %d - Skip suppressed %d '%s': %s", aliasID, destination.ID, destination.Address, reason)
+			continue
+		}
+		allowed = append(allowed, destination)
+	}
+
+	if len(allowed) == 0 {
+		return nil, errSuppressed
+	}
+
+	return allowed, nil
+}
+
+func (s *Server) getAliasDestinations(aliasID int) ([]account.Destination, error) {
 	if destinations, ok := s.cache.Get("destinations", fmt.Sprintf("%d", aliasID)); ok {
 		return destinations.([]account.Destination), nil
 	}
 
 	var destinations []account.Destination
-	err := pgxscan.Select(
-		context.Background(),
-		s.db,
-		&destinations,
-		`
-		SELECT d.* 
-		FROM destinations AS d 
-		JOIN alias_destinations AS ad ON d.id = ad.destination_id 
-		WHERE ad.alias_id = $1
-		AND ad.deleted_at IS NULL
-		AND d.deleted_at IS NULL
-		`,
-		aliasID,
-	)
+	err := account.GetAliasDestinations(context.Background(), s.db, &destinations, aliasID)
 	if err != nil {
 		return nil, err
 	}
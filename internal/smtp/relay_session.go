@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"blitiri.com.ar/go/spf"
-	"github.com/Teamwork/spamc"
 	"github.com/emersion/go-smtp"
 	"github.com/google/uuid"
 	"github.com/jawr/mxax/internal/account"
@@ -20,7 +19,7 @@ import (
 
 type RelaySession struct {
 	data  *SessionData
-	spamc *spamc.Client
+	spamc SpamChecker
 }
 
 // initialise a new inbound session
@@ -30,11 +29,7 @@ func (s *Server) newRelaySession(serverName string, state *smtp.ConnectionState)
 		return nil, err
 	}
 
-	sc := spamc.New("127.0.0.1:783", &net.Dialer{
-		Timeout: 20 * time.Second,
-	})
-
-	if err := sc.Ping(context.Background()); err != nil {
+	if err := s.Spam.Ping(context.Background()); err != nil {
 		return nil, err
 	}
 
@@ -46,7 +41,7 @@ func (s *Server) newRelaySession(serverName string, state *smtp.ConnectionState)
 			State:      state,
 			server:     s,
 		},
-		spamc: sc,
+		spamc: s.Spam,
 	}
 
 	return &session, nil
@@ -66,7 +61,7 @@ func (s *RelaySession) Mail(from string, opts smtp.MailOptions) error {
 	}
 
 	// spf check
-	result, _ := spf.CheckHostWithSender(
+	result, _ := s.data.server.CheckSPF(
 		tcpAddr.IP,
 		s.data.State.Hostname,
 		from,
@@ -98,11 +93,21 @@ func (s *RelaySession) Mail(from string, opts smtp.MailOptions) error {
 	// do we want to provide dbl checks here, i.e. spamhaus?
 
 	s.data.From = from
+	s.data.spf = result
 
 	return nil
 }
 
 func (s *RelaySession) Rcpt(to string) error {
+	// feedback loop reports are handled by us rather
+	// than relayed
+	if s.data.server.isFeedbackAddress(to) {
+		log.Printf("%s - Rcpt - To: '%s' - Feedback report", s, to)
+		s.data.To = to
+		s.data.feedback = true
+		return nil
+	}
+
 	// if no domain id then just drop
 	domain, err := s.data.server.detectDomain(to)
 	if err != nil {
@@ -126,10 +131,55 @@ func (s *RelaySession) Rcpt(to string) error {
 		s.data.returnPath = true
 		s.data.ID = oID
 
+	} else if reverse, err := s.data.server.detectReverseAlias(to, domain); err == nil {
+		// only the alias' destinations can reply through it, and
+		// only from somewhere their domain sends mail from
+		ok, err := s.data.server.canReply(reverse, s.data.From)
+		if ok && s.data.spf != spf.Pass {
+			log.Printf("%s - Rcpt - To: '%s' - Sender '%s' did not pass spf: %s", s, to, s.data.From, s.data.spf)
+			ok = false
+		}
+		if err != nil {
+			log.Printf("%s - Rcpt - To: '%s' - canReply error: %s", s, to, err)
+			return errors.Errorf("unable to check sender (%s)", s)
+		}
+
+		if !ok {
+			log.Printf("%s - Rcpt - To: '%s' - Sender '%s' can not reply through reverse alias", s, to, s.data.From)
+
+			s.data.server.publishLogEntry(logger.Entry{
+				AccountID: domain.AccountID,
+				DomainID:  domain.ID,
+				AliasID:   reverse.AliasID,
+				FromEmail: s.data.From,
+				ViaEmail:  to,
+				Etype:     logger.EntryTypeReject,
+			})
+
+			return &smtp.SMTPError{
+				Code:    550,
+				Message: fmt.Sprintf("unknown recipient (%s)", s),
+			}
+		}
+
+		log.Printf("%s - Rcpt - To: '%s' - Reply to '%s'", s, to, reverse.Correspondent)
+
+		s.data.reply = true
+		s.data.reverse = reverse
+		s.data.Alias.ID = reverse.AliasID
+
 	} else {
 
 		// otherwise check alias
 		alias, err := s.data.server.detectAlias(to)
+
+		var rejected *aliasRejected
+		if err != nil && !errors.As(err, &rejected) && s.data.server.canAutoCreate(domain, to) {
+			log.Printf("%s - Rcpt - To: '%s' - Auto create alias", s, to)
+			s.data.autoCreate = true
+			err = nil
+		}
+
 		if err != nil {
 			log.Printf("%s - Rcpt - To: '%s' - detectAlias error: %s", s, to, err)
 
@@ -142,13 +192,51 @@ func (s *RelaySession) Rcpt(to string) error {
 				ViaEmail:  to,
 				Etype:     logger.EntryTypeReject,
 			})
+			message := fmt.Sprintf("unknown recipient (%s)", s)
+
+			if rejected != nil && len(rejected.message) > 0 {
+				message = rejected.message
+			}
+
 			return &smtp.SMTPError{
 				Code:    550,
-				Message: fmt.Sprintf("unknown recipient (%s)", s),
+				Message: message,
 			}
 		}
 
 		s.data.Alias = alias
+
+		// only trusted senders get through a leaked alias
+		if !s.data.autoCreate {
+			allowed, err := s.data.server.senderAllowed(alias, s.data.From)
+			if err != nil {
+				log.Printf("%s - Rcpt - To: '%s' - senderAllowed error: %s", s, to, err)
+				return errors.Errorf("unable to check sender (%s)", s)
+			}
+
+			if !allowed {
+				log.Printf("%s - Rcpt - To: '%s' - Sender '%s' refused, %s", s, to, s.data.From, alias.SenderAction)
+
+				s.data.server.publishLogEntry(logger.Entry{
+					AccountID: domain.AccountID,
+					DomainID:  domain.ID,
+					AliasID:   alias.ID,
+					FromEmail: s.data.From,
+					ViaEmail:  to,
+					Etype:     logger.EntryTypeReject,
+					Status:    "Sender Refused",
+				})
+
+				if alias.SenderAction != account.SenderActionDrop {
+					return &smtp.SMTPError{
+						Code:    550,
+						Message: fmt.Sprintf("unknown recipient (%s)", s),
+					}
+				}
+
+				s.data.drop = true
+			}
+		}
 	}
 
 	s.data.Domain = domain
@@ -177,6 +265,21 @@ func (s *RelaySession) Data(r io.Reader) error {
 
 	log.Printf("%s - Data - read %d bytes in %s", s, n, time.Since(start))
 
+	// accepted only so the sender can not tell it was refused
+	if s.data.drop {
+		log.Printf("%s - Data - Dropped", s)
+		return nil
+	}
+
+	// reports contain the original message which is likely to
+	// be spam, so skip the spam check
+	if s.data.feedback {
+		if err := s.data.server.handleFeedback(s.data); err != nil {
+			log.Printf("%s - Data - handleFeedback: %s", s, err)
+		}
+		return nil
+	}
+
 	// check spamc
 	ctx := context.Background()
 
@@ -194,24 +297,38 @@ func (s *RelaySession) Data(r io.Reader) error {
 	}
 
 	if s.data.returnPath {
-		if err := s.data.server.queueEmail(Email{
-			ID:        s.data.ID,
-			From:      s.data.From,
-			Via:       s.data.Via,
-			To:        s.data.To,
-			Message:   s.data.Message.Bytes(),
-			AccountID: s.data.Domain.AccountID,
-			DomainID:  s.data.Domain.ID,
-			AliasID:   s.data.Alias.ID,
-			Bounce:    "Returned",
-		}); err != nil {
-			log.Printf("%s - Data - queueEmail: %s", s, err)
+		if err := s.data.server.handleReturn(s.data); err != nil {
+			log.Printf("%s - Data - handleReturn: %s", s, err)
+			return errors.Errorf("unable to relay this message (%s)", s)
+		}
+
+	} else if s.data.reply {
+		if err := s.data.server.reply(s.data, s.data.Message.Bytes(), time.Time{}); err != nil {
+			log.Printf("%s - Data - reply: %s", s, err)
 			return errors.Errorf("unable to relay this message (%s)", s)
 		}
 
 	} else {
 		if err := s.data.server.relay(s.data); err != nil {
 			log.Printf("%s - Data - relay: %s", s, err)
+
+			var rejected *aliasRejected
+			if errors.As(err, &rejected) {
+				s.data.server.publishLogEntry(logger.Entry{
+					AccountID: s.data.Domain.AccountID,
+					DomainID:  s.data.Domain.ID,
+					AliasID:   s.data.Alias.ID,
+					FromEmail: s.data.From,
+					ViaEmail:  s.data.To,
+					Etype:     logger.EntryTypeReject,
+				})
+
+				return &smtp.SMTPError{
+					Code:    550,
+					Message: fmt.Sprintf("unknown recipient (%s)", s),
+				}
+			}
+
 			return errors.Errorf("unable to relay this message (%s)", s)
 		}
 	}
@@ -222,11 +339,17 @@ func (s *RelaySession) Data(r io.Reader) error {
 func (s *RelaySession) Reset() {
 	log.Printf("%s - Reset - after %s", s, time.Since(s.data.start))
 	s.data.From = ""
+	s.data.spf = ""
 	s.data.To = ""
 	s.data.Message.Reset()
 	s.data.Alias = account.Alias{}
 	s.data.Domain = account.Domain{}
 	s.data.returnPath = false
+	s.data.feedback = false
+	s.data.autoCreate = false
+	s.data.drop = false
+	s.data.reply = false
+	s.data.reverse = account.ReverseAlias{}
 }
 
 func (s *RelaySession) Logout() error {
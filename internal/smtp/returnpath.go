@@ -15,25 +15,9 @@ func (s *Server) detectReturnPath(to string) (uuid.UUID, string, error) {
 		return uuid.Nil, "", errors.Errorf("nx cache hit for '%s'", to)
 	}
 
-	parts := strings.Split(to, "@")
-	if len(parts) != 2 {
-		return uuid.Nil, "", errors.Errorf("bad email: '%s'", to)
-	}
-
-	parts = strings.Split(parts[0], "=")
-	if len(parts) != 2 {
-		return uuid.Nil, "", errors.Errorf("not an mxax retun path: '%s'", to)
-	}
-
-	returnPath := parts[1]
-
-	// dirty check
-	id, err := uuid.Parse(returnPath)
+	id, err := parseReturnPath(to)
 	if err != nil {
-		return uuid.Nil, "", errors.WithMessagef(err, "Parse: '%s'", returnPath)
-	}
-	if id == uuid.Nil {
-		return uuid.Nil, "", errors.Errorf("Nil uuid for '%s'", returnPath)
+		return uuid.Nil, "", err
 	}
 
 	// check db
@@ -65,6 +49,33 @@ func (s *Server) detectReturnPath(to string) (uuid.UUID, string, error) {
 	return id, replyTo, nil
 }
 
+// parseReturnPath extracts the id from a return path in the
+// form of user=id@domain
+func parseReturnPath(to string) (uuid.UUID, error) {
+	parts := strings.Split(to, "@")
+	if len(parts) != 2 {
+		return uuid.Nil, errors.Errorf("bad email: '%s'", to)
+	}
+
+	parts = strings.Split(parts[0], "=")
+	if len(parts) != 2 {
+		return uuid.Nil, errors.Errorf("not an mxax retun path: '%s'", to)
+	}
+
+	returnPath := parts[1]
+
+	// dirty check
+	id, err := uuid.Parse(returnPath)
+	if err != nil {
+		return uuid.Nil, errors.WithMessagef(err, "Parse: '%s'", returnPath)
+	}
+	if id == uuid.Nil {
+		return uuid.Nil, errors.Errorf("Nil uuid for '%s'", returnPath)
+	}
+
+	return id, nil
+}
+
 func (s *Server) makeReturnPath(session *SessionData) (string, error) {
 	parts := strings.Split(strings.Replace(session.To, "=", "", -1), "@")
 
@@ -0,0 +1,242 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jawr/mxax/internal/account"
+	"github.com/pkg/errors"
+)
+
+// headers that identify the destination a reply was written
+// from, removed before it goes on to the correspondent
+var replyIdentityHeaders = []string{
+	"From",
+	"Sender",
+	"Reply-To",
+	"To",
+	"Cc",
+	"Bcc",
+	"Return-Path",
+	"Message-ID",
+	"Received",
+	"DKIM-Signature",
+	"ARC-Seal",
+	"ARC-Message-Signature",
+	"ARC-Authentication-Results",
+	"Authentication-Results",
+	"X-Originating-IP",
+	"X-Sender",
+	"X-Mxax-Subaddress",
+}
+
+// makeReverseAlias returns the reverse alias of correspondent
+// for the session's alias, creating it on first use
+func (s *Server) makeReverseAlias(session *SessionData, correspondent string) (account.ReverseAlias, error) {
+	correspondent = strings.ToLower(correspondent)
+	key := fmt.Sprintf("%d:%s:%s", session.Alias.ID, correspondent, strings.ToLower(session.To))
+
+	if r, ok := s.cache.Get("reverse_alias", key); ok {
+		return r.(account.ReverseAlias), nil
+	}
+
+	r := account.ReverseAlias{
+		AccountID:     session.Domain.AccountID,
+		DomainID:      session.Domain.ID,
+		AliasID:       session.Alias.ID,
+		AliasAddress:  session.To,
+		Correspondent: correspondent,
+	}
+
+	// replies go out from the address last written to
+	err := s.db.QueryRow(
+		context.Background(),
+		`
+		INSERT INTO reverse_aliases (account_id, domain_id, alias_id, alias_address, correspondent)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (alias_id, correspondent) DO UPDATE SET alias_address = EXCLUDED.alias_address
+		RETURNING id
+		`,
+		r.AccountID,
+		r.DomainID,
+		r.AliasID,
+		r.AliasAddress,
+		r.Correspondent,
+	).Scan(&r.ID)
+	if err != nil {
+		return account.ReverseAlias{}, errors.WithMessage(err, "Insert")
+	}
+
+	s.cache.Set("reverse_alias", key, r)
+
+	return r, nil
+}
+
+// detectReverseAlias finds the reverse alias to is for on domain
+func (s *Server) detectReverseAlias(to string, domain account.Domain) (account.ReverseAlias, error) {
+	id, err := parseReturnPath(to)
+	if err != nil {
+		return account.ReverseAlias{}, err
+	}
+
+	if r, ok := s.cache.Get("reverse_alias", id.String()); ok {
+		return r.(account.ReverseAlias), nil
+	}
+
+	var r account.ReverseAlias
+	err = pgxscan.Get(
+		context.Background(),
+		s.db,
+		&r,
+		"SELECT * FROM reverse_aliases WHERE id = $1 AND domain_id = $2",
+		id,
+		domain.ID,
+	)
+	if err != nil {
+		return account.ReverseAlias{}, errors.WithMessage(err, "Select")
+	}
+
+	s.cache.Set("reverse_alias", id.String(), r)
+
+	return r, nil
+}
+
+// canReply reports if sender is one of the destinations of the
+// reverse alias' alias, nobody else may send through it
+func (s *Server) canReply(r account.ReverseAlias, sender string) (bool, error) {
+	destinations, err := s.getAliasDestinations(r.AliasID)
+	if err != nil {
+		return false, errors.WithMessage(err, "getAliasDestinations")
+	}
+
+	for _, destination := range destinations {
+		if strings.EqualFold(destination.Address, sender) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// reply sends message, written to a reverse alias, on to the
+// correspondent from the alias address
+func (s *Server) reply(session *SessionData, message []byte, notBefore time.Time) error {
+	r := session.reverse
+
+	message = rewriteReply(message, session.ID, r.AliasAddress, r.Correspondent, session.From)
+
+	err := s.queueSigned(session.Domain, Email{
+		ID:        session.ID,
+		From:      r.AliasAddress,
+		Via:       session.To,
+		To:        r.Correspondent,
+		Message:   message,
+		AccountID: r.AccountID,
+		DomainID:  r.DomainID,
+		AliasID:   r.AliasID,
+		Priority:  PrioritySubmission,
+		NotBefore: notBefore,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "queueSigned")
+	}
+
+	_, err = s.db.Exec(
+		context.Background(),
+		"UPDATE reverse_aliases SET last_used_at = NOW() WHERE id = $1",
+		r.ID,
+	)
+	if err != nil {
+		return errors.WithMessage(err, "Update")
+	}
+
+	return nil
+}
+
+// rewriteForward shows a forwarded message as from the reverse
+// alias of its sender so replies come back through us
+func rewriteForward(message []byte, from *mail.Address, reverse string) []byte {
+	name := strings.Replace(from.Address, "@", " at ", 1)
+	if len(from.Name) > 0 {
+		name = fmt.Sprintf("%s - %s", from.Name, name)
+	}
+
+	header := fmt.Sprintf("From: %s\r\n", (&mail.Address{Name: name, Address: reverse}).String())
+
+	message = removeHeaders(message, "From", "Reply-To")
+
+	return append([]byte(header), message...)
+}
+
+// rewriteReply strips the destination, sender, from a reply and
+// addresses it from the alias to the correspondent with a message
+// id on the alias' domain
+func rewriteReply(message []byte, id uuid.UUID, from, to, sender string) []byte {
+	header := fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nMessage-ID: <%s@%s>\r\n",
+		(&mail.Address{Address: from}).String(),
+		(&mail.Address{Address: to}).String(),
+		id,
+		domainOf(from),
+	)
+
+	message = removeHeaders(message, replyIdentityHeaders...)
+
+	// threading headers are kept, less any ids the destination's
+	// own domain made
+	for _, name := range []string{"In-Reply-To", "References"} {
+		value, rest, ok := removeHeader(message, name)
+		if !ok {
+			continue
+		}
+		message = rest
+
+		if ids := filterMessageIDs(value, domainOf(sender)); len(ids) > 0 {
+			header += fmt.Sprintf("%s: %s\r\n", name, ids)
+		}
+	}
+
+	return append([]byte(header), message...)
+}
+
+// filterMessageIDs removes the message ids made on domain from a
+// list of them
+func filterMessageIDs(value, domain string) string {
+	var ids []string
+	for _, id := range strings.Fields(value) {
+		if len(domain) > 0 && strings.EqualFold(domainOf(strings.Trim(id, "<>")), domain) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return strings.Join(ids, " ")
+}
+
+// domainOf returns the part of address after the @
+func domainOf(address string) string {
+	if idx := strings.LastIndex(address, "@"); idx >= 0 {
+		return strings.ToLower(address[idx+1:])
+	}
+	return ""
+}
+
+// removeHeaders removes every header called any of names
+func removeHeaders(message []byte, names ...string) []byte {
+	for _, name := range names {
+		for {
+			var ok bool
+			_, message, ok = removeHeader(message, name)
+			if !ok {
+				break
+			}
+		}
+	}
+
+	return message
+}
@@ -0,0 +1,47 @@
+package smtp
+
+import "testing"
+
+func TestRemoveHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		message string
+		names   []string
+		want    string
+	}{
+		{
+			"every instance",
+			"Received: a\r\nSubject: Hi\r\nReceived: b\r\n\r\nHello\r\n",
+			[]string{"Received"},
+			"Subject: Hi\r\n\r\nHello\r\n",
+		},
+		{
+			"folded",
+			"References: <a@b>\r\n <c@d>\r\n\t<e@f>\r\nSubject: Hi\r\n\r\nHello\r\n",
+			[]string{"References"},
+			"Subject: Hi\r\n\r\nHello\r\n",
+		},
+		{
+			"case insensitive",
+			"x-mailer: mutt\r\nSubject: Hi\r\n\r\nHello\r\n",
+			[]string{"X-Mailer", "User-Agent"},
+			"Subject: Hi\r\n\r\nHello\r\n",
+		},
+		{
+			"prefix of another header",
+			"To: a@b\r\nTo-Do: list\r\n\r\nHello\r\n",
+			[]string{"To"},
+			"To-Do: list\r\n\r\nHello\r\n",
+		},
+		{
+			"body untouched",
+			"Subject: Hi\r\n\r\nReceived: in the body\r\n",
+			[]string{"Received"},
+			"Subject: Hi\r\n\r\nReceived: in the body\r\n",
+		},
+	} {
+		if got := string(removeHeaders([]byte(tc.message), tc.names...)); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
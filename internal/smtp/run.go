@@ -1,21 +1,73 @@
 package smtp
 
+import (
+	"net"
+
+	"github.com/emersion/go-smtp"
+	"github.com/pkg/errors"
+)
+
 func (s *Server) Run(domain string) error {
+	relay, err := net.Listen("tcp", s.relayServer.Addr)
+	if err != nil {
+		return errors.WithMessage(err, "Listen relay")
+	}
+
+	submission, err := net.Listen("tcp", s.submissionServer.Addr)
+	if err != nil {
+		relay.Close()
+		return errors.WithMessage(err, "Listen submission")
+	}
+
+	return s.Serve(domain, relay, submission)
+}
+
+// Serve accepts relay and submission connections on the
+// given listeners
+func (s *Server) Serve(domain string, relay, submission net.Listener) error {
 	// TODO
 	// add cancellation
 
 	s.relayServer.Domain = domain
 	s.submissionServer.Domain = domain
 
+	s.relayPort = listenerPort(relay)
+	s.submissionPort = listenerPort(submission)
+
 	errCh := make(chan error, 0)
 
+	go s.releaseHeld(s.done)
+
 	go func() {
-		errCh <- s.relayServer.ListenAndServe()
+		errCh <- s.relayServer.Serve(relay)
 	}()
 
 	go func() {
-		errCh <- s.submissionServer.ListenAndServe()
+		errCh <- s.submissionServer.Serve(submission)
 	}()
 
 	return <-errCh
 }
+
+// Close stops both servers and closes their connections
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.submissionServer.Close()
+	s.relayServer.Close()
+}
+
+func listenerPort(l net.Listener) string {
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+// onPort checks if a connection came in on port
+func onPort(state *smtp.ConnectionState, port string) bool {
+	_, local, err := net.SplitHostPort(state.LocalAddr.String())
+	if err != nil {
+		return false
+	}
+	return local == port
+}
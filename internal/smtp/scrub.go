@@ -0,0 +1,203 @@
+package smtp
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jhillyerd/enmime"
+	"github.com/pkg/errors"
+)
+
+// headers that identify or track the sender's side of a message,
+// removed from forwarded mail of aliases that scrub
+var scrubHeaders = []string{
+	"Received",
+	"X-Received",
+	"X-Originating-IP",
+	"X-Originating-Email",
+	"X-Sender-IP",
+	"X-Source-IP",
+	"X-Client-IP",
+	"X-Remote-IP",
+	"X-Forwarded-For",
+	"X-Mailer",
+	"User-Agent",
+	"X-Campaign",
+	"X-Campaign-ID",
+	"X-Mailgun-Variables",
+	"X-SMTPAPI",
+	"X-MC-Metadata",
+}
+
+// a link redirector whose target is held in a query parameter
+type redirector struct {
+	// a leading dot matches any sub domain
+	host  string
+	path  string
+	param string
+}
+
+// well known click tracking redirects that can be replaced by
+// their target
+var redirectors = []redirector{
+	{host: "www.google.com", path: "/url", param: "q"},
+	{host: "google.com", path: "/url", param: "q"},
+	{host: "l.facebook.com", path: "/l.php", param: "u"},
+	{host: "lm.facebook.com", path: "/l.php", param: "u"},
+	{host: "l.instagram.com", path: "/", param: "u"},
+	{host: "www.youtube.com", path: "/redirect", param: "q"},
+	{host: ".safelinks.protection.outlook.com", path: "/", param: "url"},
+	{host: "www.linkedin.com", path: "/redir/redirect", param: "url"},
+	{host: "slack-redir.net", path: "/link", param: "url"},
+	{host: "steamcommunity.com", path: "/linkfilter/", param: "url"},
+}
+
+var (
+	imgTagRegex    = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+	attrRegex      = regexp.MustCompile(`(?is)\b([a-z-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+	hrefRegex      = regexp.MustCompile(`(?is)(\bhref\s*=\s*)("[^"]*"|'[^']*')`)
+	hiddenRegex    = regexp.MustCompile(`(?i)display\s*:\s*none|visibility\s*:\s*hidden`)
+	styleSizeRegex = regexp.MustCompile(`(?i)(?:^|[;\s])(width|height)\s*:\s*(\d+)\s*(?:px)?\s*(?:;|$)`)
+)
+
+// scrub removes identifying headers from message, and tracking
+// pixels and click tracking redirects from its html parts. On
+// error message is returned with only its headers scrubbed
+func scrub(message []byte) ([]byte, error) {
+	message = removeHeaders(message, scrubHeaders...)
+
+	root, err := enmime.ReadParts(bytes.NewReader(message))
+	if err != nil {
+		return message, errors.WithMessage(err, "ReadParts")
+	}
+
+	parts := root.DepthMatchAll(func(p *enmime.Part) bool {
+		return p.ContentType == "text/html" && p.Disposition != "attachment"
+	})
+
+	changed := false
+	for _, part := range parts {
+		content := scrubHTML(part.Content)
+		if !bytes.Equal(content, part.Content) {
+			part.Content = content
+			changed = true
+		}
+	}
+
+	// leave the message as it came when there is nothing to
+	// rebuild
+	if !changed {
+		return message, nil
+	}
+
+	// text content has been decoded to utf-8
+	root.DepthMatchAll(func(p *enmime.Part) bool {
+		if strings.HasPrefix(p.ContentType, "text/") {
+			p.Charset = "utf-8"
+		}
+		return false
+	})
+
+	var rebuilt bytes.Buffer
+	if err := root.Encode(&rebuilt); err != nil {
+		return message, errors.WithMessage(err, "Encode")
+	}
+
+	return rebuilt.Bytes(), nil
+}
+
+// scrubHTML removes tracking pixels and unwraps click tracking
+// redirects
+func scrubHTML(content []byte) []byte {
+	content = imgTagRegex.ReplaceAllFunc(content, func(tag []byte) []byte {
+		if isTrackingPixel(tag) {
+			return nil
+		}
+		return tag
+	})
+
+	return hrefRegex.ReplaceAllFunc(content, func(attr []byte) []byte {
+		m := hrefRegex.FindSubmatch(attr)
+		quote := m[2][:1]
+		link := html.UnescapeString(string(m[2][1 : len(m[2])-1]))
+
+		target, ok := unwrapRedirect(link)
+		if !ok {
+			return attr
+		}
+
+		return []byte(string(m[1]) + string(quote) + html.EscapeString(target) + string(quote))
+	})
+}
+
+// isTrackingPixel reports if an img tag is sized 1x1 or smaller,
+// or hidden
+func isTrackingPixel(tag []byte) bool {
+	attrs := make(map[string]string)
+	for _, m := range attrRegex.FindAllSubmatch(tag, -1) {
+		value := string(m[2])
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
+			value = value[1 : len(value)-1]
+		}
+		attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(value)
+	}
+
+	// sizes in the style take precedence over attributes
+	if style, ok := attrs["style"]; ok {
+		if hiddenRegex.MatchString(style) {
+			return true
+		}
+		for _, m := range styleSizeRegex.FindAllStringSubmatch(style, -1) {
+			attrs[strings.ToLower(m[1])] = m[2]
+		}
+	}
+
+	width, wok := pixels(attrs["width"])
+	height, hok := pixels(attrs["height"])
+
+	return wok && hok && width <= 1 && height <= 1
+}
+
+// pixels parses a width or height attribute
+func pixels(value string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+	return n, err == nil
+}
+
+// unwrapRedirect returns the target of a known click tracking
+// redirect
+func unwrapRedirect(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	for _, r := range redirectors {
+		if strings.HasPrefix(r.host, ".") {
+			if !strings.HasSuffix(host, r.host) {
+				continue
+			}
+		} else if host != r.host {
+			continue
+		}
+
+		if !strings.HasPrefix(u.Path, r.path) && !(r.path == "/" && u.Path == "") {
+			continue
+		}
+
+		target, err := url.Parse(u.Query().Get(r.param))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+			return "", false
+		}
+
+		return target.String(), true
+	}
+
+	return "", false
+}
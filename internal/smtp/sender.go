@@ -0,0 +1,36 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jawr/mxax/internal/account"
+)
+
+// senderAllowed checks sender against the sender rules of alias
+func (s *Server) senderAllowed(alias account.Alias, sender string) (bool, error) {
+	rules, err := s.getSenderRules(alias.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return account.SenderAllowed(rules, sender), nil
+}
+
+func (s *Server) getSenderRules(aliasID int) ([]account.SenderRule, error) {
+	key := fmt.Sprintf("%d", aliasID)
+
+	if rules, ok := s.cache.Get("sender_rules", key); ok {
+		return rules.([]account.SenderRule), nil
+	}
+
+	var rules []account.SenderRule
+	err := account.GetSenderRules(context.Background(), s.db, &rules, aliasID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Set("sender_rules", key, rules)
+
+	return rules, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"time"
 
+	"blitiri.com.ar/go/spf"
 	"github.com/emersion/go-smtp"
 	"github.com/google/uuid"
 	"github.com/jawr/mxax/internal/account"
@@ -23,7 +24,9 @@ type SessionData struct {
 	ServerName string
 
 	// email
-	From    string
+	From string
+	// result of checking From against the sending ip
+	spf     spf.Result
 	Via     string
 	To      string
 	Message bytes.Buffer
@@ -34,4 +37,15 @@ type SessionData struct {
 
 	// internal flags
 	returnPath bool
+	feedback   bool
+
+	// no alias matched but the domain auto creates one
+	autoCreate bool
+
+	// the sender was refused by an alias that drops mail
+	drop bool
+
+	// a reply to a correspondent through a reverse alias
+	reply   bool
+	reverse account.ReverseAlias
 }
@@ -2,23 +2,45 @@ package smtp
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"io"
+	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
+	"blitiri.com.ar/go/spf"
+	"github.com/Teamwork/spamc"
 	"github.com/emersion/go-smtp"
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
-	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/jawr/mxax/internal/cache"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/pkg/errors"
 )
 
+// DB is the part of *pgxpool.Pool the Server uses
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// SpamChecker scores inbound messages, *spamc.Client
+// is the default
+type SpamChecker interface {
+	Ping(ctx context.Context) error
+	Check(ctx context.Context, msg io.Reader, hdr spamc.Header) (*spamc.ResponseCheck, error)
+}
+
 // Server will listen for smtp connections
 // and check them against various rules in the
 // database. Expected to have a load balancer
 // in front, i.e. HaProxy
 type Server struct {
-	db *pgxpool.Pool
+	db DB
 
 	// underlying smtp servers, one for :smtp (aka relay)
 	// one for :submission
@@ -26,43 +48,54 @@ type Server struct {
 	submissionServer *smtp.Server
 
 	// publishers
-	logPublisher   *rabbitmq.Channel
-	emailPublisher *rabbitmq.Channel
+	logPublisher   mq.Publisher
+	emailPublisher mq.Publisher
 
 	// bytes pool
 	bufferPool sync.Pool
 
 	// multi purpose cache, strings are prefixed with namespace
 	cache *cache.Cache
+
+	// addresses registered with providers to receive
+	// feedback loop reports
+	feedbackAddresses map[string]bool
+
+	// ports the underlying servers are listening on, used
+	// to tell them apart in the backend
+	relayPort      string
+	submissionPort string
+
+	// closed to stop releasing held emails
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// external checks, replaceable before Run is called
+	Spam       SpamChecker
+	CheckSPF   func(ip net.IP, helo, sender string) (spf.Result, error)
+	LookupAddr func(addr string) ([]string, error)
 }
 
 // Create a new Server, currently only handles inbound
-// connections
-func NewServer(db *pgxpool.Pool, logPublisher, emailPublisher *rabbitmq.Channel) (*Server, error) {
+// connections. STARTTLS is only offered if tlsConfig is set
+func NewServer(db DB, logPublisher, emailPublisher mq.Publisher, tlsConfig *tls.Config) (*Server, error) {
 	cache, err := cache.NewCache()
 	if err != nil {
 		return nil, errors.WithMessage(err, "NewCache")
 	}
 
-	// load our certificate for TLS
-	cert, err := tls.LoadX509KeyPair(
-		"/etc/letsencrypt/live/ehlo.mx.ax/fullchain.pem",
-		"/etc/letsencrypt/live/ehlo.mx.ax/privkey.pem",
-	)
-	if err != nil {
-		return nil, errors.WithMessage(err, "tls.LoadX509KeyPair")
-	}
-
-	tlsConfig := &tls.Config{
-		ServerName:   "ehlo.mx.ax",
-		Certificates: []tls.Certificate{cert},
-	}
-
 	server := &Server{
-		db:             db,
-		logPublisher:   logPublisher,
-		emailPublisher: emailPublisher,
-		cache:          cache,
+		db:                db,
+		logPublisher:      logPublisher,
+		emailPublisher:    emailPublisher,
+		cache:             cache,
+		feedbackAddresses: make(map[string]bool),
+		done:              make(chan struct{}),
+		Spam: spamc.New("127.0.0.1:783", &net.Dialer{
+			Timeout: 20 * time.Second,
+		}),
+		CheckSPF:   spf.CheckHostWithSender,
+		LookupAddr: net.LookupAddr,
 		bufferPool: sync.Pool{
 			New: func() interface{} {
 				return new(bytes.Buffer)
@@ -87,3 +120,14 @@ func NewServer(db *pgxpool.Pool, logPublisher, emailPublisher *rabbitmq.Channel)
 
 	return server, nil
 }
+
+// SetFeedbackAddresses sets the addresses registered with
+// providers to receive feedback loop reports, call before Run
+func (s *Server) SetFeedbackAddresses(addresses []string) {
+	s.feedbackAddresses = make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		if address = strings.TrimSpace(address); len(address) > 0 {
+			s.feedbackAddresses[strings.ToLower(address)] = true
+		}
+	}
+}
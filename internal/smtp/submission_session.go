@@ -1,7 +1,6 @@
 package smtp
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -90,6 +89,27 @@ func (s *SubmissionSession) Mail(from string, opts smtp.MailOptions) error {
 func (s *SubmissionSession) Rcpt(to string) error {
 	log.Printf("%s - Mail - To '%s'", s, to)
 
+	// replies through a reverse alias go out from the alias
+	if _, err := parseReturnPath(to); err == nil {
+		domain, err := s.data.server.detectDomain(to)
+		if err == nil && domain.AccountID == s.data.Domain.AccountID {
+			reverse, err := s.data.server.detectReverseAlias(to, domain)
+			if err != nil {
+				log.Printf("%s - Rcpt - To: '%s' - detectReverseAlias error: %s", s, to, err)
+				return &smtp.SMTPError{
+					Code:    550,
+					Message: fmt.Sprintf("unknown recipient (%s)", s),
+				}
+			}
+
+			log.Printf("%s - Rcpt - To: '%s' - Reply to '%s'", s, to, reverse.Correspondent)
+
+			s.data.reply = true
+			s.data.reverse = reverse
+			s.data.Domain = domain
+		}
+	}
+
 	s.data.To = to
 
 	return nil
@@ -104,27 +124,43 @@ func (s *SubmissionSession) Data(r io.Reader) error {
 		return errors.Errorf("can not read message (%s)", s)
 	}
 
-	// TODO
-	// do we need to add a return path?
+	// a held message is released at a future time
+	notBefore, message, err := FutureRelease(s.data.Message.Bytes(), time.Now())
+	if err != nil {
+		log.Printf("%s - Data - futureRelease: %s", s, err)
+		return &smtp.SMTPError{
+			Code:         501,
+			EnhancedCode: smtp.EnhancedCode{5, 5, 4},
+			Message:      fmt.Sprintf("invalid %s (%s)", futureReleaseHeader, s),
+		}
+	}
+
+	if s.data.reply {
+		if err := s.data.server.reply(s.data, message, notBefore); err != nil {
+			log.Printf("%s - Data - reply: %s", s, err)
+			return errors.Errorf("unable to send this message (%s)", s)
+		}
 
-	signed := s.data.server.bufferPool.Get().(*bytes.Buffer)
-	signed.Reset()
-	defer s.data.server.bufferPool.Put(signed)
+		log.Printf("%s - Data - read %d bytes in %s", s, n, time.Since(start))
 
-	if err := s.data.server.dkimSignHandler(s.data, &s.data.Message, signed); err != nil {
-		return errors.WithMessage(err, "dkimSignHandler")
+		return nil
 	}
 
-	err = s.data.server.queueEmail(Email{
+	// TODO
+	// do we need to add a return path?
+
+	err = s.data.server.queueSigned(s.data.Domain, Email{
 		ID:        s.data.ID,
 		From:      s.data.From,
 		To:        s.data.To,
-		Message:   signed.Bytes(),
+		Message:   message,
 		AccountID: s.data.Domain.AccountID,
 		DomainID:  s.data.Domain.ID,
+		Priority:  PrioritySubmission,
+		NotBefore: notBefore,
 	})
 	if err != nil {
-		return errors.Wrap(err, "queueEmailHandler")
+		return errors.Wrap(err, "queueSigned")
 	}
 
 	log.Printf("%s - Data - read %d bytes in %s", s, n, time.Since(start))
@@ -139,6 +175,8 @@ func (s *SubmissionSession) Reset() {
 	s.data.Message.Reset()
 	s.data.Alias = account.Alias{}
 	s.data.Domain = account.Domain{}
+	s.data.reply = false
+	s.data.reverse = account.ReverseAlias{}
 }
 
 func (s *SubmissionSession) Logout() error {
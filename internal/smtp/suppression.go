@@ -0,0 +1,52 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// getSuppressed returns the suppressed addresses for an account,
+// including the global list, mapped to the reason they were
+// suppressed
+func (s *Server) getSuppressed(accountID int) (map[string]string, error) {
+	key := fmt.Sprintf("%d", accountID)
+
+	if suppressed, ok := s.cache.Get("suppressed", key); ok {
+		return suppressed.(map[string]string), nil
+	}
+
+	rows, err := s.db.Query(
+		context.Background(),
+		`
+		SELECT address, reason
+		FROM suppressions
+		WHERE
+			suppressed_at IS NOT NULL
+			AND (account_id IS NULL OR account_id = $1)
+		`,
+		accountID,
+	)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Select")
+	}
+	defer rows.Close()
+
+	suppressed := make(map[string]string)
+	for rows.Next() {
+		var address, reason string
+		if err := rows.Scan(&address, &reason); err != nil {
+			return nil, errors.WithMessage(err, "Scan")
+		}
+		suppressed[address] = reason
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithMessage(err, "Rows")
+	}
+
+	s.cache.Set("suppressed", key, suppressed)
+
+	return suppressed, nil
+}
@@ -0,0 +1,38 @@
+package smtp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagSubject prefixes the Subject of message with tag, unless it
+// already carries it as replies do
+func tagSubject(message []byte, tag string) []byte {
+	subject, rest, _ := removeHeader(message, "Subject")
+	if strings.Contains(subject, tag) {
+		return message
+	}
+
+	if len(subject) > 0 {
+		subject = tag + " " + subject
+	} else {
+		subject = tag
+	}
+
+	return append([]byte(fmt.Sprintf("Subject: %s\r\n", subject)), rest...)
+}
+
+// tagHeaders returns the headers naming the alias address and
+// domain mail was received at, any sent to us are removed from
+// message so they can not be spoofed
+func tagHeaders(message []byte, session *SessionData) ([]byte, string) {
+	message = removeHeaders(message, "X-MXAX-Alias", "X-MXAX-Domain")
+
+	header := fmt.Sprintf(
+		"X-MXAX-Alias: %s\r\nX-MXAX-Domain: %s\r\n",
+		strings.ToLower(session.To),
+		session.Domain.Name,
+	)
+
+	return message, header
+}
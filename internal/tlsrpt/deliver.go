@@ -0,0 +1,119 @@
+package tlsrpt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const httpsTimeout = time.Second * 30
+
+// Post delivers a gzipped report to an https rua as
+// described in RFC 8460 section 5.4
+func Post(ctx context.Context, u *url.URL, gzipped []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, httpsTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(gzipped))
+	if err != nil {
+		return errors.WithMessage(err, "NewRequest")
+	}
+
+	req.Header.Set("Content-Type", "application/tlsrpt+gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "Do")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// BuildMessage creates the multipart/report email described in
+// RFC 8460 section 5.3, it is returned unsigned
+func (r Report) BuildMessage(from, to string, gzipped []byte) ([]byte, error) {
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+
+	// human readable part
+	text, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "CreatePart text")
+	}
+
+	fmt.Fprintf(
+		text,
+		"This is an aggregate TLS report from %s for %s covering %s to %s.\r\n",
+		r.OrganizationName,
+		r.Domain(),
+		r.DateRange.Start.Format(time.RFC3339),
+		r.DateRange.End.Format(time.RFC3339),
+	)
+
+	// report part
+	attachment, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {fmt.Sprintf(`application/tlsrpt+gzip; name="%s"`, r.Filename())},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf(`attachment; filename="%s"`, r.Filename())},
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "CreatePart report")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(gzipped)
+	for len(encoded) > 76 {
+		fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(attachment, "%s\r\n", encoded)
+
+	if err := mw.Close(); err != nil {
+		return nil, errors.WithMessage(err, "Close")
+	}
+
+	var message bytes.Buffer
+
+	headers := []string{
+		fmt.Sprintf("From: <%s>", from),
+		fmt.Sprintf("To: <%s>", to),
+		fmt.Sprintf("Date: %s", time.Now().Format(time.RFC1123Z)),
+		fmt.Sprintf("Message-ID: <%s@%s>", r.ReportID, r.OrganizationName),
+		fmt.Sprintf(
+			"Subject: Report Domain: %s Submitter: %s Report-ID: <%s>",
+			r.Domain(),
+			r.OrganizationName,
+			r.ReportID,
+		),
+		fmt.Sprintf("TLS-Report-Domain: %s", r.Domain()),
+		fmt.Sprintf("TLS-Report-Submitter: %s", r.OrganizationName),
+		"MIME-Version: 1.0",
+		fmt.Sprintf(`Content-Type: multipart/report; report-type="tlsrpt"; boundary="%s"`, mw.Boundary()),
+	}
+
+	for _, h := range headers {
+		message.WriteString(h + "\r\n")
+	}
+	message.WriteString("\r\n")
+
+	if _, err := message.ReadFrom(&body); err != nil {
+		return nil, errors.WithMessage(err, "ReadFrom")
+	}
+
+	return message.Bytes(), nil
+}
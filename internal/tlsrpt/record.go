@@ -0,0 +1,85 @@
+package tlsrpt
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Record is a parsed _smtp._tls TXT record, currently
+// only the rua field is used
+type Record struct {
+	Rua []*url.URL
+}
+
+// LookupRecord fetches and parses the TLSRPTv1 record
+// published for domain
+func LookupRecord(domain string) (*Record, error) {
+	txts, err := net.LookupTXT("_smtp._tls." + domain)
+	if err != nil {
+		return nil, errors.WithMessage(err, "LookupTXT")
+	}
+
+	var found []string
+	for _, txt := range txts {
+		if strings.HasPrefix(txt, "v=TLSRPTv1") {
+			found = append(found, txt)
+		}
+	}
+
+	// RFC 8460 3: multiple records must be treated as
+	// if no record was published
+	if len(found) != 1 {
+		return nil, errors.Errorf("expected 1 TLSRPTv1 record, found %d", len(found))
+	}
+
+	return ParseRecord(found[0])
+}
+
+// ParseRecord parses a TLSRPTv1 record, discarding any rua
+// uris that are not mailto or https
+func ParseRecord(txt string) (*Record, error) {
+	var record Record
+
+	fields := strings.Split(txt, ";")
+	for idx, field := range fields {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		if idx == 0 {
+			if key != "v" || value != "TLSRPTv1" {
+				return nil, errors.Errorf("bad version: '%s'", field)
+			}
+			continue
+		}
+
+		if key != "rua" {
+			continue
+		}
+
+		for _, raw := range strings.Split(value, ",") {
+			u, err := url.Parse(strings.TrimSpace(raw))
+			if err != nil {
+				continue
+			}
+
+			switch u.Scheme {
+			case "mailto", "https":
+				record.Rua = append(record.Rua, u)
+			}
+		}
+	}
+
+	if len(record.Rua) == 0 {
+		return nil, errors.New("no usable rua found")
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,198 @@
+package tlsrpt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// Report is an aggregate SMTP TLS report for a single
+// policy domain as defined in RFC 8460 section 4
+type Report struct {
+	OrganizationName string      `json:"organization-name"`
+	DateRange        DateRange   `json:"date-range"`
+	ContactInfo      string      `json:"contact-info"`
+	ReportID         string      `json:"report-id"`
+	Policies         []PolicySet `json:"policies"`
+}
+
+type DateRange struct {
+	Start time.Time `json:"start-datetime"`
+	End   time.Time `json:"end-datetime"`
+}
+
+type PolicySet struct {
+	Policy         Policy          `json:"policy"`
+	Summary        Summary         `json:"summary"`
+	FailureDetails []FailureDetail `json:"failure-details,omitempty"`
+}
+
+// Policy type is always no-policy-found as we do not
+// yet apply MTA-STS or DANE when sending
+type Policy struct {
+	PolicyType   string `json:"policy-type"`
+	PolicyDomain string `json:"policy-domain"`
+}
+
+type Summary struct {
+	TotalSuccessfulSessionCount int `json:"total-successful-session-count"`
+	TotalFailureSessionCount    int `json:"total-failure-session-count"`
+}
+
+type FailureDetail struct {
+	ResultType          ResultType `json:"result-type"`
+	SendingMTAIP        string     `json:"sending-mta-ip"`
+	ReceivingMXHostname string     `json:"receiving-mx-hostname"`
+	ReceivingIP         string     `json:"receiving-ip,omitempty"`
+	FailedSessionCount  int        `json:"failed-session-count"`
+	FailureReasonCode   string     `json:"failure-reason-code,omitempty"`
+}
+
+// Domain returns the policy domain this report is for
+func (r Report) Domain() string {
+	if len(r.Policies) == 0 {
+		return ""
+	}
+	return r.Policies[0].Policy.PolicyDomain
+}
+
+// Filename as recommended in RFC 8460 section 5.1
+func (r Report) Filename() string {
+	return fmt.Sprintf(
+		"%s!%s!%d!%d!%s.json.gz",
+		r.OrganizationName,
+		r.Domain(),
+		r.DateRange.Start.Unix(),
+		r.DateRange.End.Unix(),
+		r.ReportID,
+	)
+}
+
+// Gzip encodes the report as gzipped json
+func (r Report) Gzip() ([]byte, error) {
+	var b bytes.Buffer
+
+	gz := gzip.NewWriter(&b)
+
+	if err := json.NewEncoder(gz).Encode(&r); err != nil {
+		return nil, errors.WithMessage(err, "Encode")
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, errors.WithMessage(err, "Close")
+	}
+
+	return b.Bytes(), nil
+}
+
+// aggregated row from tls_results
+type resultCount struct {
+	PolicyDomain string
+	SendingIP    string
+	ReceivingMX  string
+	ReceivingIP  string
+	ResultType   ResultType
+	Information  string
+	Count        int
+}
+
+// GetReports aggregates all recorded results between start and end
+// in to one Report per policy domain
+func GetReports(ctx context.Context, db *pgxpool.Pool, organisation, contact string, start, end time.Time) ([]Report, error) {
+	var counts []resultCount
+	err := pgxscan.Select(
+		ctx,
+		db,
+		&counts,
+		`
+		SELECT
+			policy_domain,
+			sending_ip,
+			receiving_mx,
+			receiving_ip,
+			result_type,
+			MAX(information) AS information,
+			COUNT(*) AS count
+		FROM tls_results
+		WHERE
+			time >= $1
+			AND time < $2
+		GROUP BY 1, 2, 3, 4, 5
+		ORDER BY 1
+		`,
+		start,
+		end,
+	)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Select tls_results")
+	}
+
+	byDomain := make(map[string]*Report)
+
+	for _, c := range counts {
+		report, ok := byDomain[c.PolicyDomain]
+		if !ok {
+			id, err := uuid.NewRandom()
+			if err != nil {
+				return nil, errors.WithMessage(err, "NewRandom")
+			}
+
+			report = &Report{
+				OrganizationName: organisation,
+				DateRange: DateRange{
+					Start: start.UTC(),
+					End:   end.UTC(),
+				},
+				ContactInfo: contact,
+				ReportID:    id.String(),
+				Policies: []PolicySet{
+					{
+						Policy: Policy{
+							PolicyType:   "no-policy-found",
+							PolicyDomain: c.PolicyDomain,
+						},
+					},
+				},
+			}
+
+			byDomain[c.PolicyDomain] = report
+		}
+
+		policy := &report.Policies[0]
+
+		if c.ResultType == ResultTypeSuccess {
+			policy.Summary.TotalSuccessfulSessionCount += c.Count
+			continue
+		}
+
+		policy.Summary.TotalFailureSessionCount += c.Count
+		policy.FailureDetails = append(policy.FailureDetails, FailureDetail{
+			ResultType:          c.ResultType,
+			SendingMTAIP:        c.SendingIP,
+			ReceivingMXHostname: normaliseHost(c.ReceivingMX),
+			ReceivingIP:         c.ReceivingIP,
+			FailedSessionCount:  c.Count,
+			FailureReasonCode:   c.Information,
+		})
+	}
+
+	reports := make([]Report, 0, len(byDomain))
+	for _, report := range byDomain {
+		reports = append(reports, *report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Domain() < reports[j].Domain()
+	})
+
+	return reports, nil
+}
@@ -0,0 +1,77 @@
+package tlsrpt
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ResultType is the result-type of a failed TLS negotiation
+// as defined in RFC 8460 section 4.3. An empty ResultType
+// represents a successful session
+type ResultType string
+
+const (
+	ResultTypeSuccess ResultType = ""
+
+	// negotiation failures
+	ResultTypeStartTLSNotSupported    ResultType = "starttls-not-supported"
+	ResultTypeCertificateHostMismatch ResultType = "certificate-host-mismatch"
+	ResultTypeCertificateExpired      ResultType = "certificate-expired"
+	ResultTypeCertificateNotTrusted   ResultType = "certificate-not-trusted"
+	ResultTypeValidationFailure       ResultType = "validation-failure"
+)
+
+// Result is recorded by the sender for every session it
+// opens with a destination MX and is aggregated in to
+// daily Reports
+type Result struct {
+	Time time.Time
+
+	// the recipient domain
+	PolicyDomain string
+
+	SendingIP   string
+	ReceivingMX string
+	ReceivingIP string
+
+	ResultType ResultType
+
+	// raw error returned from the handshake, if any
+	Information string
+}
+
+// Success reports if the session negotiated TLS
+func (r Result) Success() bool {
+	return r.ResultType == ResultTypeSuccess
+}
+
+// ResultTypeFromError maps an error returned by a STARTTLS
+// handshake to the closest RFC 8460 result-type
+func ResultTypeFromError(err error) ResultType {
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return ResultTypeCertificateHostMismatch
+	}
+
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return ResultTypeCertificateNotTrusted
+	}
+
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		if invalidErr.Reason == x509.Expired {
+			return ResultTypeCertificateExpired
+		}
+		return ResultTypeCertificateNotTrusted
+	}
+
+	return ResultTypeValidationFailure
+}
+
+// trim the trailing dot from mx hosts
+func normaliseHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,66 @@
+package tlsrpt
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	valid := map[string]string{
+		"v=TLSRPTv1; rua=mailto:tls@dest.test":                             "mailto:tls@dest.test",
+		"v=TLSRPTv1;rua=mailto:tls@dest.test,https://report.dest.test/tls": "mailto:tls@dest.test https://report.dest.test/tls",
+		"v=TLSRPTv1; foo=bar; rua=mailto:tls@dest.test":                    "mailto:tls@dest.test",
+		"v=TLSRPTv1; rua=http://report.dest.test/tls,mailto:tls@dest.test": "mailto:tls@dest.test",
+	}
+
+	for txt, want := range valid {
+		record, err := ParseRecord(txt)
+		if err != nil {
+			t.Errorf("'%s': %s", txt, err)
+			continue
+		}
+
+		var rua []string
+		for _, u := range record.Rua {
+			rua = append(rua, u.String())
+		}
+
+		if got := strings.Join(rua, " "); got != want {
+			t.Errorf("'%s': expected rua '%s', got '%s'", txt, want, got)
+		}
+	}
+
+	invalid := []string{
+		"v=TLSRPTv1; rua=http://report.dest.test/tls",
+		"v=TLSRPTv1",
+		"v=TLSRPTv2; rua=mailto:tls@dest.test",
+		"rua=mailto:tls@dest.test; v=TLSRPTv1",
+	}
+
+	for _, txt := range invalid {
+		if _, err := ParseRecord(txt); err == nil {
+			t.Errorf("'%s': expected an error", txt)
+		}
+	}
+}
+
+func TestResultTypeFromError(t *testing.T) {
+	hostname := x509.HostnameError{Host: "mx.dest.test"}
+
+	check := func(err error, want ResultType) {
+		t.Helper()
+		if got := ResultTypeFromError(err); got != want {
+			t.Errorf("%v: expected '%s', got '%s'", err, want, got)
+		}
+	}
+
+	check(hostname, ResultTypeCertificateHostMismatch)
+	check(fmt.Errorf("handshake: %w", hostname), ResultTypeCertificateHostMismatch)
+	check(x509.UnknownAuthorityError{}, ResultTypeCertificateNotTrusted)
+	check(x509.CertificateInvalidError{Reason: x509.Expired}, ResultTypeCertificateExpired)
+	check(x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}, ResultTypeCertificateNotTrusted)
+	check(errors.New("remote error: tls: handshake failure"), ResultTypeValidationFailure)
+}
@@ -146,10 +146,11 @@ func (s *Site) contact(req *http.Request, form *Form, tmpl *template.Template) e
 	}
 
 	err = s.queueEmail(smtp.Email{
-		ID:      id,
-		From:    "contact@mx.ax",
-		To:      "contact@mx.ax",
-		Message: signed.Bytes(),
+		ID:       id,
+		From:     "contact@mx.ax",
+		To:       "contact@mx.ax",
+		Message:  signed.Bytes(),
+		Priority: smtp.PrioritySystem,
 	})
 	if err != nil {
 		return err
@@ -13,11 +13,11 @@ import (
 
 	"github.com/emersion/go-msgauth/dkim"
 	"github.com/google/uuid"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/jawr/mxax/internal/smtp"
 	"github.com/jhillyerd/enmime"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
-	"github.com/streadway/amqp"
 	"github.com/stripe/stripe-go/v71"
 	"github.com/stripe/stripe-go/v71/customer"
 	"golang.org/x/crypto/bcrypt"
@@ -134,10 +134,11 @@ func (s *Site) queueVerifyEmail(address, code string, tmpl *template.Template) e
 	}
 
 	err = s.queueEmail(smtp.Email{
-		ID:      id,
-		From:    "noreply@mx.ax",
-		To:      address,
-		Message: signed.Bytes(),
+		ID:       id,
+		From:     "noreply@mx.ax",
+		To:       address,
+		Message:  signed.Bytes(),
+		Priority: smtp.PrioritySystem,
 	})
 	if err != nil {
 		return err
@@ -155,19 +156,13 @@ func (s *Site) queueEmail(email smtp.Email) error {
 		return errors.WithMessage(err, "Encode")
 	}
 
-	msg := amqp.Publishing{
+	msg := mq.Message{
 		Timestamp:   time.Now(),
 		ContentType: "application/json",
 		Body:        b.Bytes(),
 	}
 
-	err := s.emailPublisher.Publish(
-		"",
-		"emails.straw",
-		false, // mandatory
-		false, // immediate
-		msg,
-	)
+	err := s.emailPublisher.Publish(email.QueueLevel.Lane(email.Priority), msg)
 	if err != nil {
 		return errors.WithMessage(err, "Publish")
 	}
@@ -11,8 +11,8 @@ import (
 	"sync"
 
 	"github.com/dpapathanasiou/go-recaptcha"
-	"github.com/isayme/go-amqp-reconnect/rabbitmq"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/jawr/mxax/internal/mq"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
 	"github.com/stripe/stripe-go/v71"
@@ -23,14 +23,14 @@ type Site struct {
 	router     *httprouter.Router
 	bufferPool sync.Pool
 
-	emailPublisher *rabbitmq.Channel
+	emailPublisher mq.Publisher
 
 	dkimKey *rsa.PrivateKey
 
 	recaptchaPublicKey string
 }
 
-func NewSite(db *pgxpool.Pool, emailPublisher *rabbitmq.Channel) (*Site, error) {
+func NewSite(db *pgxpool.Pool, emailPublisher mq.Publisher) (*Site, error) {
 	stripe.Key = os.Getenv("MXAX_STRIPE_KEY")
 
 	s := &Site{
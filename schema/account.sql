@@ -29,6 +29,10 @@ CREATE TABLE domains (
 	verify_code TEXT UNIQUE NOT NULL,
 	verified_at TIMESTAMP WITH TIME ZONE,
 	expires_at DATE NOT NULL,
+	-- glob of local parts that get an alias on first receipt
+	auto_create_rule TEXT NOT NULL DEFAULT '',
+	-- hide correspondents behind reverse_aliases when forwarding
+	reverse_aliases BOOLEAN NOT NULL DEFAULT FALSE,
 	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
 	updated_at TIMESTAMP WITH TIME ZONE,
 	deleted_at TIMESTAMP WITH TIME ZONE
@@ -65,12 +69,47 @@ CREATE TABLE aliases (
 	id SERIAL PRIMARY KEY,
 	account_id INT NOT NULL REFERENCES accounts(id),
 	domain_id INT NOT NULL REFERENCES domains(id),
+	-- empty for the catch all
 	rule TEXT NOT NULL,
+	-- 0 regex, 1 exact, 2 glob, 3 subaddress
+	rule_type INT NOT NULL DEFAULT 0,
+	-- checked in priority order, catch alls last
+	priority INT NOT NULL DEFAULT 0,
+	catch_all BOOLEAN NOT NULL DEFAULT FALSE,
+	-- 0 enabled, 1 disabled, 2 reject with reject_message
+	state INT NOT NULL DEFAULT 0,
+	reject_message TEXT NOT NULL DEFAULT '',
+	-- only forwarded between these when set
+	active_from TIMESTAMP WITH TIME ZONE,
+	active_until TIMESTAMP WITH TIME ZONE,
+	-- burner aliases expire at expires_at or after max_uses
+	-- messages, then go to the fallback or are rejected
+	expires_at TIMESTAMP WITH TIME ZONE,
+	max_uses INT NOT NULL DEFAULT 0,
+	uses INT NOT NULL DEFAULT 0,
+	expired_at TIMESTAMP WITH TIME ZONE,
+	-- references destinations, see below
+	fallback_destination_id INT,
+	-- created by the relay from domains.auto_create_rule
+	auto_created BOOLEAN NOT NULL DEFAULT FALSE,
+	-- 0 reject, 1 drop mail from senders refused by sender_rules
+	sender_action INT NOT NULL DEFAULT 0,
+	-- strip identifying headers and trackers when forwarding
+	scrub BOOLEAN NOT NULL DEFAULT FALSE,
+	-- prefixed to forwarded subjects, empty is off
+	subject_tag TEXT NOT NULL DEFAULT '',
+	-- add X-MXAX-Alias and X-MXAX-Domain when forwarding
+	tag_headers BOOLEAN NOT NULL DEFAULT FALSE,
 	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
 	updated_at TIMESTAMP WITH TIME ZONE,
 	deleted_at TIMESTAMP WITH TIME ZONE,
-	UNIQUE(domain_id, rule)
+	-- the same rule can be both exact and a pattern
+	UNIQUE(domain_id, rule, rule_type),
+	CHECK (catch_all = (rule = ''))
 );
+
+-- a domain has at most one catch all
+CREATE UNIQUE INDEX aliases_domain_id_catch_all_idx ON aliases (domain_id) WHERE catch_all;
 ALTER TABLE aliases ENABLE ROW LEVEL SECURITY;
 DROP POLICY aliases_isolation_policy ON aliases;
 CREATE POLICY aliases_isolation_policy ON aliases 
@@ -94,9 +133,12 @@ DROP POLICY destinations_isolation_policy ON destinations;
 CREATE POLICY destinations_isolation_policy ON destinations 
 	USING (account_id = current_setting('mxax.current_account_id')::INT);
 
+ALTER TABLE aliases ADD FOREIGN KEY (fallback_destination_id) REFERENCES destinations(id);
+
 
 -- alias destinations
 CREATE TABLE alias_destinations (
+	account_id INT NOT NULL REFERENCES accounts(id),
 	alias_id INT NOT NULL REFERENCES aliases(id),
 	destination_id INT NOT NULL REFERENCES destinations(id),
 	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
@@ -110,6 +152,43 @@ CREATE POLICY alias_destinations_isolation_policy ON alias_destinations
 	USING (account_id = current_setting('mxax.current_account_id')::INT);
 
 
+-- senders allowed or blocked by an alias
+CREATE TABLE sender_rules (
+	id SERIAL PRIMARY KEY,
+	account_id INT NOT NULL REFERENCES accounts(id),
+	alias_id INT NOT NULL REFERENCES aliases(id),
+	pattern TEXT NOT NULL,
+	-- 0 address, 1 domain, 2 regex
+	pattern_type INT NOT NULL DEFAULT 0,
+	allow BOOLEAN NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE,
+	deleted_at TIMESTAMP WITH TIME ZONE,
+	UNIQUE (alias_id, pattern, allow)
+);
+
+ALTER TABLE sender_rules ENABLE ROW LEVEL SECURITY;
+DROP POLICY sender_rules_isolation_policy ON sender_rules;
+CREATE POLICY sender_rules_isolation_policy ON sender_rules 
+	USING (account_id = current_setting('mxax.current_account_id')::INT);
+
+
+-- destinations of aliases created by domains.auto_create_rule
+CREATE TABLE default_destinations (
+	account_id INT NOT NULL REFERENCES accounts(id),
+	domain_id INT NOT NULL REFERENCES domains(id),
+	destination_id INT NOT NULL REFERENCES destinations(id),
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	deleted_at TIMESTAMP WITH TIME ZONE,
+	UNIQUE (domain_id, destination_id)
+);
+
+ALTER TABLE default_destinations ENABLE ROW LEVEL SECURITY;
+DROP POLICY default_destinations_isolation_policy ON default_destinations;
+CREATE POLICY default_destinations_isolation_policy ON default_destinations 
+	USING (account_id = current_setting('mxax.current_account_id')::INT);
+
+
 -- dkim 
 CREATE TABLE dkim_keys (
 	id SERIAL PRIMARY KEY,
@@ -145,6 +224,90 @@ CREATE POLICY return_paths_isolation_policy ON return_paths
 		account_id = current_setting('mxax.current_account_id')::INT
 	);
 
+-- a correspondent of an alias, forwarded mail is shown as from
+-- the reverse alias and replies to it are sent on to the
+-- correspondent from alias_address
+CREATE TABLE reverse_aliases (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	account_id INT NOT NULL REFERENCES accounts(id),
+	domain_id INT NOT NULL REFERENCES domains(id),
+	alias_id INT NOT NULL REFERENCES aliases(id),
+	alias_address TEXT NOT NULL,
+	correspondent TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMP WITH TIME ZONE,
+	UNIQUE (alias_id, correspondent)
+);
+ALTER TABLE reverse_aliases ENABLE ROW LEVEL SECURITY;
+DROP POLICY reverse_aliases_isolation_policy ON reverse_aliases;
+CREATE POLICY reverse_aliases_isolation_policy ON reverse_aliases
+	USING (account_id = current_setting('mxax.current_account_id')::INT);
+
+-- suppressed destination addresses, account_id is NULL for the
+-- global list. A row counts permanent failures until the address
+-- is suppressed
+CREATE TABLE suppressions (
+	id SERIAL PRIMARY KEY,
+	account_id INT REFERENCES accounts(id),
+	address TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	failures INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	suppressed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX suppressions_account_id_address_idx ON suppressions (COALESCE(account_id, 0), address);
+
+ALTER TABLE suppressions ENABLE ROW LEVEL SECURITY;
+DROP POLICY suppressions_isolation_policy ON suppressions;
+CREATE POLICY suppressions_isolation_policy ON suppressions
+	USING (account_id = current_setting('mxax.current_account_id')::INT);
+
+-- submission mail held until release_at, released_at is set
+-- while a server is queueing it and the row is removed once
+-- queued, stale claims are released again. email is the encoded
+-- smtp.Email
+CREATE TABLE held_emails (
+	id SERIAL PRIMARY KEY,
+	email_id UUID NOT NULL,
+	account_id INT NOT NULL REFERENCES accounts(id),
+	domain_id INT NOT NULL REFERENCES domains(id),
+	from_email TEXT NOT NULL,
+	to_email TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email BYTEA NOT NULL,
+	release_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	released_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE INDEX held_emails_release_at_idx ON held_emails (release_at);
+
+ALTER TABLE held_emails ENABLE ROW LEVEL SECURITY;
+DROP POLICY held_emails_isolation_policy ON held_emails;
+CREATE POLICY held_emails_isolation_policy ON held_emails
+	USING (account_id = current_setting('mxax.current_account_id')::INT);
+
+-- outbound ip pools, a domain assignment takes precedence
+-- over its account type. Emails without a pool are sent
+-- from the default pool
+CREATE TABLE account_type_ip_pools (
+	account_type INT PRIMARY KEY,
+	ip_pool TEXT NOT NULL
+);
+
+CREATE TABLE domain_ip_pools (
+	domain_id INT PRIMARY KEY REFERENCES domains(id),
+	ip_pool TEXT NOT NULL
+);
+
+-- daily sends of ips that are warming up, shared by every
+-- sender so caps hold across processes
+CREATE TABLE ip_warmup_sends (
+	ip TEXT NOT NULL,
+	day DATE NOT NULL,
+	sent INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (ip, day)
+);
+
 -- timescale table for loggin
 CREATE EXTENSION IF NOT EXISTS timescaledb CASCADE;
 
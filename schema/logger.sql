@@ -0,0 +1,14 @@
+
+-- tls negotiation results recorded by the sender, aggregated
+-- daily in to tls reports (RFC 8460)
+CREATE TABLE tls_results (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	policy_domain TEXT NOT NULL,
+	sending_ip TEXT NOT NULL,
+	receiving_mx TEXT NOT NULL,
+	receiving_ip TEXT NOT NULL,
+	result_type TEXT NOT NULL,
+	information TEXT NOT NULL
+);
+
+SELECT create_hypertable('tls_results', 'time');